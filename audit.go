@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+)
+
+const auditUsage = `usage:
+  ginie audit verify [-key PUBLIC_KEY] [-head HASH] [-json]
+  ginie audit keygen PATH`
+
+// runAudit verifies the history, or creates a key to sign it with, and
+// returns the exit code: 0 if the history is intact, 2 if it was tampered
+// with and 1 if verification failed.
+func runAudit(args []string) int {
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 1
+	}
+
+	switch args[0] {
+	case "keygen":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, auditUsage)
+			return 1
+		}
+		if err := audit.GenerateKey(args[1]); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Wrote %s and %s.pub, set GINIE_AUDIT_KEY=%s to sign the history.\n", args[1], args[1], args[1])
+		return 0
+	case "verify":
+	default:
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 1
+	}
+
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "public key to verify the signatures with, GINIE_AUDIT_KEY.pub by default")
+	head := fs.String("head", "", "hash of an entry noted down earlier that must still be in the history")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	_ = fs.Parse(args[1:])
+
+	if *keyPath == "" && os.Getenv("GINIE_AUDIT_KEY") != "" {
+		*keyPath = os.Getenv("GINIE_AUDIT_KEY") + ".pub"
+	}
+	var publicKey ed25519.PublicKey
+	if *keyPath != "" {
+		var err error
+		if publicKey, err = audit.LoadPublicKey(*keyPath); err != nil {
+			return fail(err)
+		}
+	}
+
+	l, err := audit.Open(historyFile)
+	if err != nil {
+		return fail(err)
+	}
+	v, err := l.Verify(publicKey, *head)
+	if err != nil {
+		return fail(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return fail(err)
+		}
+	} else {
+		for _, problem := range v.Problems {
+			fmt.Println(problem)
+		}
+		fmt.Printf("%d entries, %d signatures verified, head %s\n", v.Entries, v.Signed, v.Head)
+	}
+	if !v.OK() {
+		if !*asJSON {
+			fmt.Println("The history was tampered with.")
+		}
+		return 2
+	}
+	return 0
+}
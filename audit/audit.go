@@ -0,0 +1,282 @@
+// Package audit keeps the history of the runs, who started them, why and what
+// they changed, in an append-only file of JSON lines.
+//
+// The entries form a hash chain: each carries the sha256 of its own JSON
+// encoding, without the hash and the signature, which covers the hash of the
+// entry before it, so deleting or modifying an entry breaks the chain from
+// there on. With a signing key the hash of every entry is signed as well,
+// so the chain cannot be rebuilt without the key.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Change is a change of a single resource made, or planned, by a run.
+type Change struct {
+	Action  string `json:"action"`
+	Address string `json:"address"`
+}
+
+// Entry records a single run.
+type Entry struct {
+	Run  string    `json:"run"`
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+	// UserUnverified marks a user the client named itself, not one Ginie
+	// could verify
+	UserUnverified     bool     `json:"userUnverified,omitempty"`
+	Session            string   `json:"session"`
+	Workspace          string   `json:"workspace"`
+	TerraformWorkspace string   `json:"terraformWorkspace"`
+	Action             string   `json:"action"`
+	Args               []string `json:"args,omitempty"`
+	// Prompt is the last prompt of the conversation before the run
+	Prompt string `json:"prompt,omitempty"`
+	// ProgramHash is the sha256 of the program the run deployed
+	ProgramHash      string   `json:"programHash,omitempty"`
+	Engine           string   `json:"engine"`
+	TerraformVersion string   `json:"terraformVersion,omitempty"`
+	PlanSummary      string   `json:"planSummary,omitempty"`
+	Changes          []Change `json:"changes,omitempty"`
+	// Duration is in milliseconds
+	Duration int64  `json:"duration"`
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+
+	// Seq numbers the entries of the history from 1
+	Seq int64 `json:"seq,omitempty"`
+	// PrevHash is the hash of the entry before, empty for the first one
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	// Signature is the base64 encoded ed25519 signature of the hash
+	Signature string `json:"signature,omitempty"`
+}
+
+// digest returns the hash of the entry, that of its JSON encoding without the
+// hash and the signature.
+func (e *Entry) digest() (string, error) {
+	unsealed := *e
+	unsealed.Hash, unsealed.Signature = "", ""
+	b, err := json.Marshal(&unsealed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Filter selects entries of the history. Empty fields match any entry.
+type Filter struct {
+	Workspace string
+	Action    string
+	User      string
+	Session   string
+	// Resource matches entries changing a resource whose address contains it
+	Resource string
+	Since    time.Time
+	Until    time.Time
+}
+
+func (f Filter) match(e *Entry) bool {
+	switch {
+	case f.Workspace != "" && e.Workspace != f.Workspace,
+		f.Action != "" && e.Action != f.Action,
+		f.User != "" && e.User != f.User,
+		f.Session != "" && e.Session != f.Session,
+		!f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	if f.Resource == "" {
+		return true
+	}
+	for _, change := range e.Changes {
+		if strings.Contains(change.Address, f.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// Log is the history of the runs, stored in a file entries are only ever
+// appended to.
+type Log struct {
+	path string
+	key  ed25519.PrivateKey
+	mu   sync.Mutex
+}
+
+// Open returns the history stored in the file at path, created on the first
+// entry.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &Log{path: path}, nil
+}
+
+// SetSigningKey makes the history sign the entries appended from now on.
+func (l *Log) SetSigningKey(key ed25519.PrivateKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.key = key
+}
+
+// Append adds an entry to the history, chaining it to the last one. It sets
+// the sequence number, hashes and signature of the entry.
+func (l *Log) Append(e *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// other processes append to the same file, it stays locked until the
+	// entry chained to the last one is written
+	f, err := openLocked(l.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	// the last entry is read back every time, other processes may have
+	// appended since
+	last, err := lastLine(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	e.Seq, e.PrevHash = 1, ""
+	if len(last) > 0 {
+		var prev Entry
+		if err := json.Unmarshal(last, &prev); err != nil {
+			f.Close()
+			return fmt.Errorf("invalid last history entry: %s", err)
+		}
+		e.Seq, e.PrevHash = prev.Seq+1, prev.Hash
+	}
+	e.Signature = ""
+	if e.Hash, err = e.digest(); err != nil {
+		f.Close()
+		return err
+	}
+	if l.key != nil {
+		e.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.key, []byte(e.Hash)))
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// openLocked opens the file and flocks it, exclusively to append to it or
+// shared to read it. Closing the file releases the lock.
+func openLocked(path string, flag, how int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking %s: %s", path, err)
+	}
+	return f, nil
+}
+
+// lastLine returns the last line of the file, nil if it is empty.
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	for off := info.Size(); off > 0; {
+		n := int64(4096)
+		if n > off {
+			n = off
+		}
+		off -= n
+		chunk := make([]byte, n)
+		if _, err := f.ReadAt(chunk, off); err != nil {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+
+		trimmed := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if off == 0 && len(trimmed) > 0 {
+			return trimmed, nil
+		}
+	}
+	return nil, nil
+}
+
+// Entries returns the entries matching the filter, oldest first.
+func (l *Log) Entries(filter Filter) ([]*Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := openLocked(l.path, os.O_RDONLY, syscall.LOCK_SH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid history entry on line %d: %s", line, err)
+		}
+		if filter.match(&e) {
+			entries = append(entries, &e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// ParseTime parses the bounds of a Filter: a duration ago, as in 24h, a date
+// or an RFC 3339 time.
+func ParseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a duration such as 24h, a date or an RFC 3339 time", s)
+}
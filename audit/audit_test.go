@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func appendEntries(t *testing.T, l *Log, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		e := &Entry{Run: fmt.Sprintf("run-%d", i), Time: time.Now(), User: "alice", Workspace: "web", Action: "apply", Result: "succeeded"}
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetSigningKey(private)
+	appendEntries(t, l, 3)
+
+	v, err := l.Verify(public, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK() || v.Entries != 3 || v.Signed != 3 {
+		t.Errorf("got %+v, want 3 signed entries without problems", v)
+	}
+	if v, err := l.Verify(public, v.Head); err != nil || !v.OK() {
+		t.Errorf("verify against head: got %+v, %v", v, err)
+	}
+}
+
+func TestVerifyDetectsEdit(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEntries(t, l, 3)
+
+	b, err := os.ReadFile(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(b), "\n")
+	lines[1] = strings.Replace(lines[1], `"user":"alice"`, `"user":"mallory"`, 1)
+	if err := os.WriteFile(l.path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := l.Verify(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.OK() {
+		t.Fatal("edited history verified")
+	}
+	if v.Problems[0].Line != 2 || v.Problems[0].Seq != 2 {
+		t.Errorf("got problems %v, want one of entry 2", v.Problems)
+	}
+}
+
+func TestVerifyDetectsRemovedHead(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEntries(t, l, 3)
+	v, err := l.Verify(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if err := os.WriteFile(l.path, []byte(strings.Join(lines[:2], "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := l.Verify(nil, v.Head); err != nil || v.OK() {
+		t.Errorf("history without its head verified: %+v, %v", v, err)
+	}
+}
+
+// helperAppendEnv makes the test binary append entries to the history it
+// names, as another ginie process would.
+const helperAppendEnv = "GINIE_TEST_AUDIT_APPEND"
+
+func TestHelperAppend(t *testing.T) {
+	path := os.Getenv(helperAppendEnv)
+	if path == "" {
+		t.Skip("helper process")
+	}
+	n, _ := strconv.Atoi(os.Getenv(helperAppendEnv + "_COUNT"))
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEntries(t, l, n)
+}
+
+// TestConcurrentAppends appends from processes and goroutines side by side,
+// the chain must stay intact.
+func TestConcurrentAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	const processes, goroutines, n = 3, 3, 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < processes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHelperAppend$")
+			cmd.Env = append(os.Environ(), helperAppendEnv+"="+path, fmt.Sprintf("%s_COUNT=%d", helperAppendEnv, n))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("helper process: %s\n%s", err, out)
+			}
+		}()
+	}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a log of its own, as in another process
+			l, err := Open(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < n; j++ {
+				if err := l.Append(&Entry{Run: "run", Action: "plan"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := l.Verify(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (processes + goroutines) * n; !v.OK() || v.Entries != want {
+		t.Errorf("got %d entries and problems %v, want %d entries without problems", v.Entries, v.Problems, want)
+	}
+}
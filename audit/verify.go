@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Problem is a sign of tampering found in the history.
+type Problem struct {
+	// Line is the line of the file the problem was found on, 0 for the
+	// history as a whole
+	Line   int    `json:"line"`
+	Seq    int64  `json:"seq,omitempty"`
+	Run    string `json:"run,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Reason
+	}
+	if p.Seq == 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Reason)
+	}
+	return fmt.Sprintf("line %d, entry %d (run %s): %s", p.Line, p.Seq, p.Run, p.Reason)
+}
+
+// Verification is the result of verifying the history.
+type Verification struct {
+	Entries int `json:"entries"`
+	// Signed is the number of entries whose signature was verified
+	Signed int `json:"signed"`
+	// Head is the hash of the last entry, which verifying against later
+	// detects entries removed from the end
+	Head     string    `json:"head,omitempty"`
+	Problems []Problem `json:"problems,omitempty"`
+
+	// hashes of the entries of the chain
+	seen map[string]bool
+}
+
+// OK reports whether the history shows no sign of tampering.
+func (v *Verification) OK() bool {
+	return len(v.Problems) == 0
+}
+
+// Verify walks the hash chain of the history, reporting entries that were
+// modified, deleted or inserted. With a public key every entry must carry a
+// valid signature of it. A non-empty head, the hash of an entry noted down
+// earlier, must still be part of the chain, which detects entries removed
+// from the end.
+func (l *Log) Verify(publicKey ed25519.PublicKey, head string) (*Verification, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v := &Verification{seen: make(map[string]bool)}
+	f, err := openLocked(l.path, os.O_RDONLY, syscall.LOCK_SH)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		defer f.Close()
+		if err := v.walk(f, publicKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if head != "" && !v.contains(head) {
+		v.Problems = append(v.Problems, Problem{Reason: fmt.Sprintf("head %s is not part of the history, entries were removed from the end or rewritten", head)})
+	}
+	return v, nil
+}
+
+func (v *Verification) walk(f *os.File, publicKey ed25519.PublicKey) error {
+	var prev *Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		v.Entries++
+
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			v.Problems = append(v.Problems, Problem{Line: line, Reason: fmt.Sprintf("not a valid entry: %s", err)})
+			continue
+		}
+		problem := func(reason string, args ...interface{}) {
+			v.Problems = append(v.Problems, Problem{Line: line, Seq: e.Seq, Run: e.Run, Reason: fmt.Sprintf(reason, args...)})
+		}
+
+		if e.Hash == "" {
+			problem("entry has no hash, it was recorded before the history was chained or stripped of it")
+			prev = &e
+			continue
+		}
+		digest, err := e.digest()
+		if err != nil {
+			return err
+		}
+		modified := digest != e.Hash
+		if modified {
+			problem("entry was modified, its hash does not match")
+		}
+
+		var prevSeq int64
+		var prevHash string
+		if prev != nil {
+			prevSeq, prevHash = prev.Seq, prev.Hash
+		}
+		switch {
+		case e.Seq == prevSeq+2:
+			problem("entry %d is missing", prevSeq+1)
+		case e.Seq > prevSeq+2:
+			problem("entries %d to %d are missing", prevSeq+1, e.Seq-1)
+		case e.PrevHash != prevHash:
+			problem("entry does not follow the entry before it, entries were deleted, inserted or modified")
+		}
+
+		// the signature of a modified entry is that of the original
+		if publicKey != nil && !modified {
+			signature, err := base64.StdEncoding.DecodeString(e.Signature)
+			switch {
+			case e.Signature == "":
+				problem("entry is not signed")
+			case err != nil || !ed25519.Verify(publicKey, []byte(e.Hash), signature):
+				problem("signature is invalid")
+			default:
+				v.Signed++
+			}
+		}
+
+		v.Head = e.Hash
+		v.seen[e.Hash] = true
+		prev = &e
+	}
+	return scanner.Err()
+}
+
+// contains reports whether the hash is that of an entry of the chain.
+func (v *Verification) contains(hash string) bool {
+	return v.seen[hash]
+}
@@ -0,0 +1,481 @@
+// Package backend implements terraform's http backend protocol on top of a
+// local directory, giving state locking and history without any cloud
+// storage.
+//
+//	GET    /state/{name}                    current state, 204 if there is none
+//	POST   /state/{name}?ID={lock}          store a new state
+//	DELETE /state/{name}                    delete the state
+//	LOCK   /state/{name}                    acquire the lock, 423 if it is held
+//	UNLOCK /state/{name}                    release the lock, an empty body forces it
+//	GET    /state/{name}/versions           previous states
+//	GET    /state/{name}/versions/{version} a previous state
+//
+// See https://developer.hashicorp.com/terraform/language/settings/backends/http
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	stateFile   = "terraform.tfstate"
+	lockFile    = "lock.json"
+	versionsDir = "versions"
+	// guardFile is flocked while a state or its lock is read and changed,
+	// ginie processes sharing the dir take turns
+	guardFile = ".guard"
+
+	methodLock   = "LOCK"
+	methodUnlock = "UNLOCK"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// LockInfo is the lock information terraform sends along with LOCK and
+// UNLOCK requests.
+type LockInfo struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Info      string    `json:"Info"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+}
+
+// Version is a previous state.
+type Version struct {
+	Name      string    `json:"name"`
+	Serial    int64     `json:"serial"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Server stores the states below a directory, one sub directory per state.
+// Servers of different processes may share the directory.
+type Server struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// guard locks the states of the server against the other goroutines and
+// processes using them, until the returned func is called.
+func (s *Server) guard() (func(), error) {
+	s.mu.Lock()
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, guardFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		s.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		// closing the file releases the flock
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+func NewServer(dir string) *Server {
+	return &Server{dir: dir}
+}
+
+// Exists reports whether a state was stored under the name.
+func (s *Server) Exists(name string) bool {
+	_, err := s.State(name)
+	return err == nil
+}
+
+// State returns the current state stored under the name.
+func (s *Server) State(name string) ([]byte, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid state name: %s", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.ReadFile(filepath.Join(s.dir, name, stateFile))
+}
+
+// List returns the names of all states, including the ones created but not
+// stored yet.
+func (s *Server) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && validName.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// Create makes room for a state, so that it is listed before terraform
+// stored it.
+func (s *Server) Create(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid state name: %s", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.MkdirAll(filepath.Join(s.dir, name), 0700)
+}
+
+// Remove deletes a state together with its versions and lock.
+func (s *Server) Remove(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid state name: %s", name)
+	}
+	release, err := s.guard()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return os.RemoveAll(filepath.Join(s.dir, name))
+}
+
+// Versions lists the previous states stored under the name, oldest first.
+func (s *Server) Versions(name string) ([]Version, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid state name: %s", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, name, versionsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []Version
+	for _, e := range entries {
+		var v Version
+		var created string
+		if _, err := fmt.Sscanf(strings.TrimSuffix(e.Name(), ".tfstate"), "%d-%s", &v.Serial, &created); err != nil {
+			continue
+		}
+		v.Name = strings.TrimSuffix(e.Name(), ".tfstate")
+		v.CreatedAt, _ = time.Parse(versionTimeFormat, created)
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Serial != versions[j].Serial {
+			return versions[i].Serial < versions[j].Serial
+		}
+		return versions[i].Name < versions[j].Name
+	})
+	return versions, nil
+}
+
+// versionTimeFormat sorts lexically
+const versionTimeFormat = "20060102T150405.000000000Z"
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "state" || !validName.MatchString(parts[1]) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	name := parts[1]
+
+	if len(parts) > 2 {
+		if parts[2] != versionsDir || len(parts) > 4 || r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if len(parts) == 3 {
+			s.listVersions(w, name)
+			return
+		}
+		s.getVersion(w, name, parts[3])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getState(w, name)
+	case http.MethodPost:
+		s.putState(w, r, name)
+	case http.MethodDelete:
+		s.deleteState(w, name)
+	case methodLock:
+		s.lock(w, r, name)
+	case methodUnlock:
+		s.unlock(w, r, name)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) getState(w http.ResponseWriter, name string) {
+	b, err := s.State(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func (s *Server) putState(w http.ResponseWriter, r *http.Request, name string) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var state struct {
+		Serial int64 `json:"serial"`
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		http.Error(w, fmt.Sprintf("invalid state: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	release, err := s.guard()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	lock, err := s.readLock(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if lock != nil && lock.ID != r.URL.Query().Get("ID") {
+		writeLock(w, http.StatusConflict, lock)
+		return
+	}
+
+	dir := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Join(dir, versionsDir), 0700); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// every state ever stored is kept as a version
+	version := fmt.Sprintf("%d-%s.tfstate", state.Serial, time.Now().UTC().Format(versionTimeFormat))
+	if err := os.WriteFile(filepath.Join(dir, versionsDir, version), b, 0600); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(dir, stateFile), b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) deleteState(w http.ResponseWriter, name string) {
+	release, err := s.guard()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	// versions are kept, the state can still be recovered
+	if err := os.Remove(filepath.Join(s.dir, name, stateFile)); err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) lock(w http.ResponseWriter, r *http.Request, name string) {
+	var info LockInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil || info.ID == "" {
+		http.Error(w, "invalid lock info", http.StatusBadRequest)
+		return
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	release, err := s.guard()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	if err := os.MkdirAll(filepath.Join(s.dir, name), 0700); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the lock file is only ever created, never overwritten
+	f, err := os.OpenFile(filepath.Join(s.dir, name, lockFile), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		if !os.IsExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lock, err := s.readLock(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeLock(w, http.StatusLocked, lock)
+		return
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request, name string) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, err := s.guard()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	lock, err := s.readLock(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if lock == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// terraform force-unlock does not know the lock info and sends none
+	if len(b) > 0 {
+		var info LockInfo
+		if err := json.Unmarshal(b, &info); err != nil {
+			http.Error(w, "invalid lock info", http.StatusBadRequest)
+			return
+		}
+		if info.ID != lock.ID {
+			writeLock(w, http.StatusConflict, lock)
+			return
+		}
+	}
+
+	if err := os.Remove(filepath.Join(s.dir, name, lockFile)); err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) listVersions(w http.ResponseWriter, name string) {
+	versions, err := s.Versions(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if versions == nil {
+		versions = []Version{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(versions)
+}
+
+func (s *Server) getVersion(w http.ResponseWriter, name, version string) {
+	if !validName.MatchString(version) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	b, err := os.ReadFile(filepath.Join(s.dir, name, versionsDir, version+".tfstate"))
+	s.mu.Unlock()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// readLock returns the lock held on the state, nil if there is none. The
+// caller must hold the guard.
+func (s *Server) readLock(name string) (*LockInfo, error) {
+	b, err := os.ReadFile(filepath.Join(s.dir, name, lockFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var info LockInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// writeLock answers with the lock currently held, terraform shows it to the
+// user.
+func writeLock(w http.ResponseWriter, status int, lock *LockInfo) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(lock)
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
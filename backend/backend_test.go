@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func request(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func lockBody(id string) string {
+	return fmt.Sprintf(`{"ID":%q,"Operation":"OperationTypeApply","Who":"test"}`, id)
+}
+
+func TestLockUnlock(t *testing.T) {
+	s := NewServer(t.TempDir())
+
+	if w := request(t, s, methodLock, "/state/ws", lockBody("a")); w.Code != http.StatusOK {
+		t.Fatalf("lock: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	w := request(t, s, methodLock, "/state/ws", lockBody("b"))
+	if w.Code != http.StatusLocked {
+		t.Fatalf("second lock: got %d, want %d", w.Code, http.StatusLocked)
+	}
+	var held LockInfo
+	if err := json.NewDecoder(w.Body).Decode(&held); err != nil || held.ID != "a" {
+		t.Fatalf("second lock: got lock %+v (%v), want the one held", held, err)
+	}
+
+	if w := request(t, s, http.MethodPost, "/state/ws?ID=b", `{"serial":1}`); w.Code != http.StatusConflict {
+		t.Errorf("store with another lock: got %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := request(t, s, http.MethodPost, "/state/ws?ID=a", `{"serial":1}`); w.Code != http.StatusOK {
+		t.Errorf("store with the lock: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := request(t, s, methodUnlock, "/state/ws", lockBody("b")); w.Code != http.StatusConflict {
+		t.Errorf("unlock with another lock: got %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := request(t, s, methodUnlock, "/state/ws", lockBody("a")); w.Code != http.StatusOK {
+		t.Errorf("unlock: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := request(t, s, methodLock, "/state/ws", lockBody("b")); w.Code != http.StatusOK {
+		t.Errorf("lock after unlock: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// terraform force-unlock sends no lock info
+	if w := request(t, s, methodUnlock, "/state/ws", ""); w.Code != http.StatusOK {
+		t.Errorf("force unlock: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := request(t, s, methodLock, "/state/ws", lockBody("c")); w.Code != http.StatusOK {
+		t.Errorf("lock after force unlock: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestLockInvalid(t *testing.T) {
+	s := NewServer(t.TempDir())
+	if w := request(t, s, methodLock, "/state/ws", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("lock without id: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := request(t, s, methodLock, "/state/..", lockBody("a")); w.Code != http.StatusNotFound {
+		t.Errorf("lock of invalid name: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestLockShared has servers sharing a dir, as ginie processes do, race for
+// the lock. Exactly one of them gets it.
+func TestLockShared(t *testing.T) {
+	dir := t.TempDir()
+	const n = 8
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			srv := httptest.NewServer(NewServer(dir))
+			defer srv.Close()
+
+			req, err := http.NewRequest(methodLock, srv.URL+"/state/ws", strings.NewReader(lockBody(fmt.Sprint(i))))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	locked := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			locked++
+		case http.StatusLocked:
+		default:
+			t.Errorf("got %d", code)
+		}
+	}
+	if locked != 1 {
+		t.Errorf("%d servers got the lock, want 1", locked)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	s := NewServer(t.TempDir())
+	for serial := 1; serial <= 2; serial++ {
+		if w := request(t, s, http.MethodPost, "/state/ws", fmt.Sprintf(`{"serial":%d}`, serial)); w.Code != http.StatusOK {
+			t.Fatalf("store: got %d", w.Code)
+		}
+	}
+	w := request(t, s, http.MethodGet, "/state/ws", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"serial":2`) {
+		t.Errorf("get: got %d %s", w.Code, w.Body)
+	}
+
+	var versions []Version
+	w = request(t, s, http.MethodGet, "/state/ws/versions", "")
+	if err := json.NewDecoder(w.Body).Decode(&versions); err != nil || len(versions) != 2 {
+		t.Fatalf("versions: got %v (%v)", versions, err)
+	}
+	w = request(t, s, http.MethodGet, "/state/ws/versions/"+versions[0].Name, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"serial":1`) {
+		t.Errorf("first version: got %d %s", w.Code, w.Body)
+	}
+
+	if w := request(t, s, http.MethodGet, "/state/other", ""); w.Code != http.StatusNoContent {
+		t.Errorf("missing state: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
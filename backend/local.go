@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// OverrideFile configures the http backend in a work dir. Being an override
+// file, it takes precedence over any backend of the generated program.
+const OverrideFile = "ginie_backend_override.tf"
+
+const overrideBlock = `terraform {
+  backend "http" {}
+}
+`
+
+// Local is a Server listening on the loopback interface, for the terraform
+// processes spawned by Ginie.
+type Local struct {
+	*Server
+	URL string
+}
+
+// Start serves the states stored below dir on a random local port.
+func Start(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	srv := NewServer(dir)
+	go func() {
+		_ = http.Serve(l, srv)
+	}()
+
+	return &Local{
+		Server: srv,
+		URL:    fmt.Sprintf("http://%s", l.Addr().String()),
+	}, nil
+}
+
+// Configure writes the backend block into workDir and returns the
+// -backend-config entries pointing terraform to the state of that name.
+func (l *Local) Configure(workDir, name string) ([]string, error) {
+	if err := os.WriteFile(filepath.Join(workDir, OverrideFile), []byte(overrideBlock), 0644); err != nil {
+		return nil, err
+	}
+
+	address := fmt.Sprintf("%s/state/%s", l.URL, name)
+	return []string{
+		"address=" + address,
+		"lock_address=" + address,
+		"unlock_address=" + address,
+		"lock_method=" + methodLock,
+		"unlock_method=" + methodUnlock,
+	}, nil
+}
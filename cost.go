@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/niravparikh05/ginie-ai/cost"
+)
+
+// loadPricing reads the catalog plans are priced with, from the file in
+// GINIE_PRICING_FILE or else gen-ai-tf/pricing.json, nil if there is none.
+func loadPricing() (*cost.Catalog, error) {
+	path := os.Getenv("GINIE_PRICING_FILE")
+	if path == "" {
+		path = filepath.Join(work_dir, "pricing.json")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	return cost.Load(path)
+}
+
+// budget handles !budget, showing the monthly budget of the current
+// workspace, !budget <amount>, setting it, and !budget off.
+func (g *ginie) budget(args []string) error {
+	switch {
+	case len(args) == 0:
+		if budget := g.sess.Budget(); budget > 0 {
+			fmt.Printf("%.2f/month\n", budget)
+		} else {
+			fmt.Println("no budget")
+		}
+		return nil
+	case len(args) == 1 && args[0] == "off":
+		return g.sess.SetBudget(0)
+	case len(args) == 1:
+		budget, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || budget <= 0 {
+			return fmt.Errorf("usage: !budget [amount|off]")
+		}
+		return g.sess.SetBudget(budget)
+	default:
+		return fmt.Errorf("usage: !budget [amount|off]")
+	}
+}
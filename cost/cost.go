@@ -0,0 +1,237 @@
+// Package cost estimates the monthly cost of terraform plans from a locally
+// maintained pricing catalog, without calling any pricing API.
+//
+// A catalog is JSON:
+//
+//	{
+//	  "currency": "USD",
+//	  "prices": [
+//	    {"resource": "aws_instance", "attribute": "instance_type", "value": "t3.micro", "region": "eu-west-1", "monthly": 8.18},
+//	    {"resource": "aws_instance", "attribute": "instance_type", "value": "t3.micro", "monthly": 7.59},
+//	    {"resource": "aws_db_instance", "attribute": "instance_class", "value": "db.t3.micro", "monthly": 12.41},
+//	    {"resource": "aws_db_instance", "quantity": "allocated_storage", "monthly": 0.115},
+//	    {"resource": "aws_nat_gateway", "monthly": 32.85}
+//	  ]
+//	}
+//
+// A price applies to the resources of its type whose attribute, the size or
+// sku, has the value, and to those of any size without one. A price with a
+// quantity is per unit of that numeric attribute, e.g. per GB of storage.
+// Prices of a region are preferred to those without one. The cost of a
+// resource adds up the prices of its type along different attributes and
+// quantities, e.g. the instance class and the storage of a database.
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+// Catalog holds the monthly prices of resources.
+type Catalog struct {
+	Currency string  `json:"currency"`
+	Prices   []Price `json:"prices"`
+}
+
+// Price is the monthly price of a resource type, of a size or sku of it, in
+// a region or in any.
+type Price struct {
+	Resource  string `json:"resource"`
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// Quantity is the numeric attribute the price is per unit of
+	Quantity string  `json:"quantity,omitempty"`
+	Monthly  float64 `json:"monthly"`
+}
+
+// ResourceCost is the monthly cost of a resource before and after a change.
+type ResourceCost struct {
+	Address string  `json:"address"`
+	Action  string  `json:"action"`
+	Before  float64 `json:"before"`
+	After   float64 `json:"after"`
+}
+
+// Estimate is the monthly cost of the resources of a plan.
+type Estimate struct {
+	Currency string `json:"currency"`
+	// Before and After are the costs of all resources before and after the
+	// plan is applied
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
+	// Changes holds the costs of the changed resources the catalog prices
+	Changes []ResourceCost `json:"changes,omitempty"`
+	// Unpriced holds the resources missing from the catalog
+	Unpriced []string `json:"unpriced,omitempty"`
+}
+
+// Load reads the catalog at path.
+func Load(path string) (*Catalog, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Catalog
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid pricing catalog %s: %s", path, err)
+	}
+	for i, p := range c.Prices {
+		switch {
+		case p.Resource == "":
+			return nil, fmt.Errorf("invalid pricing catalog %s: price %d has no resource", path, i+1)
+		case (p.Attribute == "") != (p.Value == ""):
+			return nil, fmt.Errorf("invalid pricing catalog %s: price %d of %s needs both attribute and value", path, i+1, p.Resource)
+		case p.Monthly < 0:
+			return nil, fmt.Errorf("invalid pricing catalog %s: price %d of %s is negative", path, i+1, p.Resource)
+		}
+	}
+	if c.Currency == "" {
+		c.Currency = "USD"
+	}
+	return &c, nil
+}
+
+// Estimate returns the monthly cost of the resources of the plan, those it
+// leaves unchanged included. The addresses of a stack are prefixed with it,
+// STACK:ADDRESS.
+func (c *Catalog) Estimate(stack string, plan *terraform.PlanResult) *Estimate {
+	if c == nil || plan == nil {
+		return nil
+	}
+
+	e := &Estimate{Currency: c.Currency}
+	unpriced := make(map[string]bool)
+	resources := append(plan.Changes(), plan.Unchanged...)
+	for _, change := range resources {
+		address := change.Address
+		if stack != "" {
+			address = stack + ":" + address
+		}
+
+		rc := ResourceCost{Address: address, Action: change.Action}
+		priced := false
+		if change.Action != terraform.ActionCreate {
+			cost, ok := c.price(change, change.Before)
+			rc.Before, priced = cost, ok
+		}
+		if change.Action != terraform.ActionDelete {
+			cost, ok := c.price(change, change.After)
+			rc.After, priced = cost, priced || ok
+		}
+		if !priced {
+			unpriced[address] = true
+			continue
+		}
+
+		e.Before += rc.Before
+		e.After += rc.After
+		if change.Action != terraform.ActionNoop && rc.Before != rc.After {
+			e.Changes = append(e.Changes, rc)
+		}
+	}
+	for address := range unpriced {
+		e.Unpriced = append(e.Unpriced, address)
+	}
+	sort.Strings(e.Unpriced)
+	e.Delta = e.After - e.Before
+	return e
+}
+
+// price returns the monthly cost of the resource with the values, whether
+// the catalog has a price for it.
+func (c *Catalog) price(change terraform.ResourceChange, values interface{}) (float64, bool) {
+	v, _ := values.(map[string]interface{})
+	if v == nil {
+		return 0, false
+	}
+	region, _ := v["region"].(string)
+	if region == "" {
+		region = change.Region
+	}
+
+	// the best price along every attribute and quantity, those of the
+	// region win
+	type dimension struct{ attribute, quantity string }
+	best := make(map[dimension]Price)
+	for _, p := range c.Prices {
+		if p.Resource != change.Type || (p.Region != "" && p.Region != region) {
+			continue
+		}
+		if p.Attribute != "" && fmt.Sprint(v[p.Attribute]) != p.Value {
+			continue
+		}
+		d := dimension{p.Attribute, p.Quantity}
+		if current, ok := best[d]; ok && current.Region != "" {
+			continue
+		}
+		best[d] = p
+	}
+	if len(best) == 0 {
+		return 0, false
+	}
+
+	total := 0.0
+	for _, p := range best {
+		if p.Quantity == "" {
+			total += p.Monthly
+			continue
+		}
+		// unknown until applied, or not set
+		if quantity, ok := v[p.Quantity].(float64); ok {
+			total += p.Monthly * quantity
+		}
+	}
+	return total, true
+}
+
+// Add adds the estimate of another stack of the same plan.
+func (e *Estimate) Add(other *Estimate) {
+	if other == nil {
+		return
+	}
+	if e.Currency == "" {
+		e.Currency = other.Currency
+	}
+	e.Before += other.Before
+	e.After += other.After
+	e.Delta += other.Delta
+	e.Changes = append(e.Changes, other.Changes...)
+	e.Unpriced = append(e.Unpriced, other.Unpriced...)
+	sort.Strings(e.Unpriced)
+}
+
+// Summary renders the estimate as the cost of the changed resources and the
+// total before and after the plan.
+func (e *Estimate) Summary() string {
+	var sb strings.Builder
+	for _, rc := range e.Changes {
+		fmt.Fprintf(&sb, "  %s: %s/month\n", rc.Address, e.signed(rc.After-rc.Before))
+	}
+	fmt.Fprintf(&sb, "Cost: %s/month -> %s/month (%s/month)", e.format(e.Before), e.format(e.After), e.signed(e.Delta))
+	switch len(e.Unpriced) {
+	case 0:
+	case 1:
+		sb.WriteString(", 1 resource not in the pricing catalog")
+	default:
+		fmt.Fprintf(&sb, ", %d resources not in the pricing catalog", len(e.Unpriced))
+	}
+	return sb.String()
+}
+
+func (e *Estimate) format(amount float64) string {
+	return fmt.Sprintf("%.2f %s", amount, e.Currency)
+}
+
+func (e *Estimate) signed(amount float64) string {
+	if amount >= 0 {
+		return "+" + e.format(amount)
+	}
+	return e.format(amount)
+}
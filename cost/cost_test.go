@@ -0,0 +1,190 @@
+package cost
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+var catalog = &Catalog{
+	Currency: "USD",
+	Prices: []Price{
+		{Resource: "aws_instance", Attribute: "instance_type", Value: "t3.micro", Region: "eu-west-1", Monthly: 8},
+		{Resource: "aws_instance", Attribute: "instance_type", Value: "t3.micro", Monthly: 7},
+		{Resource: "aws_instance", Attribute: "instance_type", Value: "t3.large", Monthly: 60},
+		{Resource: "aws_db_instance", Attribute: "instance_class", Value: "db.t3.micro", Monthly: 12},
+		{Resource: "aws_db_instance", Quantity: "allocated_storage", Monthly: 0.5},
+		{Resource: "aws_nat_gateway", Monthly: 30},
+	},
+}
+
+func plan(changes ...terraform.ResourceChange) *terraform.PlanResult {
+	p := &terraform.PlanResult{ResourceChanges: make(map[string][]terraform.ResourceChange)}
+	for _, change := range changes {
+		if change.Action == terraform.ActionNoop {
+			p.Unchanged = append(p.Unchanged, change)
+			continue
+		}
+		p.ResourceChanges[change.Action] = append(p.ResourceChanges[change.Action], change)
+	}
+	return p
+}
+
+func instance(size string) map[string]interface{} {
+	return map[string]interface{}{"instance_type": size}
+}
+
+func database(storage interface{}) map[string]interface{} {
+	values := map[string]interface{}{"instance_class": "db.t3.micro"}
+	if storage != nil {
+		values["allocated_storage"] = storage
+	}
+	return values
+}
+
+func TestEstimate(t *testing.T) {
+	e := catalog.Estimate("", plan(
+		// the region of the provider
+		terraform.ResourceChange{Address: "aws_instance.new", Type: "aws_instance", Action: terraform.ActionCreate, Region: "eu-west-1", After: instance("t3.micro")},
+		// storage only known once applied
+		terraform.ResourceChange{Address: "aws_db_instance.new", Type: "aws_db_instance", Action: terraform.ActionCreate, After: database(nil)},
+		terraform.ResourceChange{Address: "aws_s3_bucket.logs", Type: "aws_s3_bucket", Action: terraform.ActionCreate, After: map[string]interface{}{}},
+		terraform.ResourceChange{Address: "aws_instance.resize", Type: "aws_instance", Action: terraform.ActionUpdate, Region: "us-east-1", Before: instance("t3.micro"), After: instance("t3.large")},
+		// a change of the tags costs nothing
+		terraform.ResourceChange{Address: "aws_instance.tags", Type: "aws_instance", Action: terraform.ActionUpdate, Before: instance("t3.micro"), After: instance("t3.micro")},
+		terraform.ResourceChange{Address: "aws_db_instance.db", Type: "aws_db_instance", Action: terraform.ActionReplace, Before: database(float64(20)), After: database(float64(40))},
+		terraform.ResourceChange{Address: "aws_nat_gateway.gw", Type: "aws_nat_gateway", Action: terraform.ActionDelete, Before: map[string]interface{}{}},
+		terraform.ResourceChange{Address: "aws_iam_role.ci", Type: "aws_iam_role", Action: terraform.ActionDelete, Before: map[string]interface{}{}},
+		// its own region wins over that of the provider
+		terraform.ResourceChange{Address: "aws_instance.same", Type: "aws_instance", Action: terraform.ActionNoop, Region: "us-east-1",
+			Before: map[string]interface{}{"instance_type": "t3.micro", "region": "eu-west-1"},
+			After:  map[string]interface{}{"instance_type": "t3.micro", "region": "eu-west-1"}},
+	))
+
+	want := &Estimate{
+		Currency: "USD",
+		Before:   7 + 7 + 12 + 20*0.5 + 30 + 8,
+		After:    8 + 12 + 60 + 7 + 12 + 40*0.5 + 8,
+		Changes: []ResourceCost{
+			{Address: "aws_instance.new", Action: terraform.ActionCreate, After: 8},
+			{Address: "aws_db_instance.new", Action: terraform.ActionCreate, After: 12},
+			{Address: "aws_instance.resize", Action: terraform.ActionUpdate, Before: 7, After: 60},
+			{Address: "aws_db_instance.db", Action: terraform.ActionReplace, Before: 22, After: 32},
+			{Address: "aws_nat_gateway.gw", Action: terraform.ActionDelete, Before: 30},
+		},
+		Unpriced: []string{"aws_iam_role.ci", "aws_s3_bucket.logs"},
+	}
+	want.Delta = want.After - want.Before
+	if !reflect.DeepEqual(e, want) {
+		t.Fatalf("got %+v, want %+v", e, want)
+	}
+}
+
+func TestEstimateStack(t *testing.T) {
+	e := catalog.Estimate("network", plan(
+		terraform.ResourceChange{Address: "aws_nat_gateway.gw", Type: "aws_nat_gateway", Action: terraform.ActionCreate, After: map[string]interface{}{}},
+		terraform.ResourceChange{Address: "aws_vpc.main", Type: "aws_vpc", Action: terraform.ActionCreate, After: map[string]interface{}{}},
+	))
+	if len(e.Changes) != 1 || e.Changes[0].Address != "network:aws_nat_gateway.gw" {
+		t.Fatalf("got changes %+v, want those of the stack", e.Changes)
+	}
+	if !reflect.DeepEqual(e.Unpriced, []string{"network:aws_vpc.main"}) {
+		t.Fatalf("got unpriced %v, want those of the stack", e.Unpriced)
+	}
+
+	var none *Catalog
+	if e := none.Estimate("", plan()); e != nil {
+		t.Fatalf("got %+v without a catalog", e)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	e := &Estimate{}
+	e.Add(catalog.Estimate("web", plan(
+		terraform.ResourceChange{Address: "aws_instance.a", Type: "aws_instance", Action: terraform.ActionCreate, After: instance("t3.micro")},
+		terraform.ResourceChange{Address: "aws_vpc.main", Type: "aws_vpc", Action: terraform.ActionCreate, After: map[string]interface{}{}},
+	)))
+	e.Add(catalog.Estimate("network", plan(
+		terraform.ResourceChange{Address: "aws_nat_gateway.gw", Type: "aws_nat_gateway", Action: terraform.ActionDelete, Before: map[string]interface{}{}},
+		terraform.ResourceChange{Address: "aws_eip.gw", Type: "aws_eip", Action: terraform.ActionCreate, After: map[string]interface{}{}},
+	)))
+	e.Add(nil)
+
+	if e.Currency != "USD" || e.Before != 30 || e.After != 7 || e.Delta != -23 || len(e.Changes) != 2 {
+		t.Fatalf("got %+v, want the costs of both stacks", e)
+	}
+	if !reflect.DeepEqual(e.Unpriced, []string{"network:aws_eip.gw", "web:aws_vpc.main"}) {
+		t.Fatalf("got unpriced %v", e.Unpriced)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		estimate Estimate
+		want     string
+	}{
+		{"no changes", Estimate{Currency: "USD", Before: 10, After: 10}, "Cost: 10.00 USD/month -> 10.00 USD/month (+0.00 USD/month)"},
+		{"changes", Estimate{
+			Currency: "EUR",
+			Before:   40,
+			After:    22.5,
+			Delta:    -17.5,
+			Changes: []ResourceCost{
+				{Address: "aws_instance.a", Action: terraform.ActionCreate, After: 12.5},
+				{Address: "aws_nat_gateway.gw", Action: terraform.ActionDelete, Before: 30},
+			},
+			Unpriced: []string{"aws_vpc.main"},
+		}, "  aws_instance.a: +12.50 EUR/month\n  aws_nat_gateway.gw: -30.00 EUR/month\n" +
+			"Cost: 40.00 EUR/month -> 22.50 EUR/month (-17.50 EUR/month), 1 resource not in the pricing catalog"},
+		{"unpriced", Estimate{Currency: "USD", Unpriced: []string{"aws_vpc.a", "aws_vpc.b"}},
+			"Cost: 0.00 USD/month -> 0.00 USD/month (+0.00 USD/month), 2 resources not in the pricing catalog"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.estimate.Summary(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		want    string
+	}{
+		{"valid", `{"prices": [{"resource": "aws_instance", "attribute": "instance_type", "value": "t3.micro", "monthly": 7.59}]}`, ""},
+		{"json", `{"prices": [`, "unexpected end of JSON input"},
+		{"no resource", `{"prices": [{"monthly": 1}]}`, "price 1 has no resource"},
+		{"no value", `{"prices": [{"resource": "aws_instance", "attribute": "instance_type", "monthly": 1}]}`, "price 1 of aws_instance needs both attribute and value"},
+		{"no attribute", `{"prices": [{"resource": "aws_instance", "value": "t3.micro", "monthly": 1}]}`, "price 1 of aws_instance needs both attribute and value"},
+		{"negative", `{"prices": [{"resource": "aws_eip", "monthly": 1}, {"resource": "aws_instance", "monthly": -1}]}`, "price 2 of aws_instance is negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pricing.json")
+			if err := os.WriteFile(path, []byte(tt.catalog), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c, err := Load(path)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.Currency != "USD" || len(c.Prices) != 1 {
+					t.Fatalf("got %+v, want the prices in USD", c)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), "invalid pricing catalog "+path+": ") || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
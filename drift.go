@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/backend"
+	"github.com/niravparikh05/ginie-ai/llm"
+	"github.com/niravparikh05/ginie-ai/session"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// drift handles !drift, detecting the drift of the current workspace, and
+// !drift propose, which asks the model how to resolve it as well.
+func (g *ginie) drift(ctx context.Context, args []string) error {
+	propose := len(args) == 1 && args[0] == "propose"
+	if len(args) > 0 && !propose {
+		return fmt.Errorf("usage: !drift [propose]")
+	}
+
+	report, err := g.sess.DetectDrift(ctx, os.Stdout)
+	if report != nil {
+		fmt.Println(report.Summary())
+	}
+	if err != nil || !propose || !report.Drifted {
+		return err
+	}
+
+	response, err := g.sess.ProposeDriftFix(ctx, report)
+	if err != nil {
+		return fmt.Errorf("ERROR: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", response)
+	return nil
+}
+
+// runDrift detects the drift of the workspaces, all of them unless named
+// with -workspace, and returns the exit code: 0 without drift, 2 with drift
+// and 1 if detection failed, as terraform's -detailed-exitcode does.
+func runDrift(args []string) int {
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	names := fs.String("workspace", "", "comma separated workspaces to check, all by default")
+	propose := fs.Bool("propose", false, "ask the model how to resolve the drift")
+	verbose := fs.Bool("v", false, "print the output of terraform")
+	_ = fs.Parse(args)
+
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	var provider llm.Provider
+	if *propose {
+		if len(os.Getenv("OPENAI_API_KEY")) == 0 || len(os.Getenv("OPENAI_MODEL")) == 0 {
+			return fail(fmt.Errorf("-propose needs OPENAI_API_KEY and OPENAI_MODEL"))
+		}
+		var err error
+		if provider, err = llm.NewOpenAI(os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_MODEL")); err != nil {
+			return fail(err)
+		}
+	}
+
+	workspaces, err := openWorkspaces()
+	if err != nil {
+		return fail(err)
+	}
+	var selected []*workspace.Workspace
+	if *names == "" {
+		list, err := workspaces.List()
+		if err != nil {
+			return fail(err)
+		}
+		selected = list
+	} else {
+		for _, name := range strings.Split(*names, ",") {
+			ws, err := workspaces.Get(strings.TrimSpace(name))
+			if err != nil {
+				return fail(err)
+			}
+			selected = append(selected, ws)
+		}
+	}
+
+	stateBackend, err := backend.Start(filepath.Join(work_dir, state_dir))
+	if err != nil {
+		return fail(err)
+	}
+
+	ctx, stop := terraform.SetupSignalHandler(context.Background())
+	defer stop()
+
+	var out io.Writer = io.Discard
+	if *verbose {
+		out = os.Stdout
+	}
+
+	code := 0
+	for _, ws := range selected {
+		sess := session.New(session.NewID(), ws, provider)
+		sess.SetBackend(stateBackend)
+		if err := recordHistory(sess); err != nil {
+			return fail(err)
+		}
+
+		report, err := sess.DetectDrift(ctx, out)
+		if err != nil {
+			fmt.Printf("%s: %s\n", ws.Name, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%s:\n%s\n", ws.Name, report.Summary())
+		if !report.Drifted {
+			continue
+		}
+		if code == 0 {
+			code = 2
+		}
+
+		if *propose {
+			response, err := sess.ProposeDriftFix(ctx, report)
+			if err != nil {
+				fmt.Printf("%s: %s\n", ws.Name, err)
+				code = 1
+				continue
+			}
+			fmt.Printf("%s\n", response)
+		}
+	}
+	return code
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+	"github.com/niravparikh05/ginie-ai/session"
+)
+
+// historyFile is the history of the runs of all workspaces.
+var historyFile = filepath.Join(work_dir, ".audit", "history.jsonl")
+
+// currentUser returns who runs are recorded for in the history.
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// openHistory opens the history, signing the entries with the private key in
+// GINIE_AUDIT_KEY if set.
+func openHistory() (*audit.Log, error) {
+	l, err := audit.Open(historyFile)
+	if err != nil {
+		return nil, err
+	}
+	if path := os.Getenv("GINIE_AUDIT_KEY"); path != "" {
+		key, err := audit.LoadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		l.SetSigningKey(key)
+	}
+	return l, nil
+}
+
+// recordHistory makes the session record its runs in the history, for the
+// current user.
+func recordHistory(sess *session.Session) error {
+	l, err := openHistory()
+	if err != nil {
+		return err
+	}
+	sess.SetAuditLog(l)
+	sess.SetUser(currentUser())
+	return nil
+}
+
+// history handles !history [n], printing the last n runs of the current
+// workspace, 10 by default.
+func (g *ginie) history(args []string) error {
+	n := 10
+	if len(args) > 1 {
+		return fmt.Errorf("usage: !history [n]")
+	}
+	if len(args) == 1 {
+		if _, err := fmt.Sscanf(args[0], "%d", &n); err != nil || n <= 0 {
+			return fmt.Errorf("usage: !history [n]")
+		}
+	}
+
+	l, err := audit.Open(historyFile)
+	if err != nil {
+		return err
+	}
+	entries, err := l.Entries(audit.Filter{Workspace: g.sess.Workspace().Name})
+	if err != nil {
+		return err
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	printHistory(os.Stdout, entries)
+	return nil
+}
+
+// runHistory prints the history of the runs, filtered by the flags, as a
+// table or with -json as JSON for export.
+func runHistory(args []string) error {
+	var filter audit.Filter
+	var since, until string
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.StringVar(&filter.Workspace, "workspace", "", "only runs in this workspace")
+	fs.StringVar(&filter.Action, "action", "", "only runs of this action, e.g. apply")
+	fs.StringVar(&filter.User, "user", "", "only runs of this user")
+	fs.StringVar(&filter.Session, "session", "", "only runs of this session")
+	fs.StringVar(&filter.Resource, "resource", "", "only runs changing a resource whose address contains this")
+	fs.StringVar(&since, "since", "", "only runs since, e.g. 24h, 2024-01-31 or an RFC 3339 time")
+	fs.StringVar(&until, "until", "", "only runs until, e.g. 2024-01-31 or an RFC 3339 time")
+	asJSON := fs.Bool("json", false, "print the runs as JSON")
+	_ = fs.Parse(args)
+
+	var err error
+	if since != "" {
+		if filter.Since, err = audit.ParseTime(since); err != nil {
+			return err
+		}
+	}
+	if until != "" {
+		if filter.Until, err = audit.ParseTime(until); err != nil {
+			return err
+		}
+	}
+
+	l, err := audit.Open(historyFile)
+	if err != nil {
+		return err
+	}
+	entries, err := l.Entries(filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if entries == nil {
+			entries = []*audit.Entry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	printHistory(os.Stdout, entries)
+	return nil
+}
+
+func printHistory(w io.Writer, entries []*audit.Entry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tUSER\tWORKSPACE\tACTION\tRESULT\tDURATION\tPLAN\tPROMPT")
+	for _, e := range entries {
+		user := e.User
+		if e.UserUnverified {
+			user += " (unverified)"
+		}
+		prompt := strings.Join(strings.Fields(e.Prompt), " ")
+		if len(prompt) > 60 {
+			prompt = prompt[:57] + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"),
+			user,
+			e.Workspace,
+			strings.TrimSpace(e.Action+" "+strings.Join(e.Args, " ")),
+			e.Result,
+			(time.Duration(e.Duration) * time.Millisecond).String(),
+			e.PlanSummary,
+			prompt,
+		)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+const (
+	downloadTokenEnv = "GINIE_DOWNLOAD_TOKEN"
+	uploadTokenEnv   = "GINIE_UPLOAD_TOKEN"
+)
+
+// runJob runs terraform as a stateless job: the work dir is downloaded from
+// -download-url, the actions run and the plan, outputs, log and state are
+// uploaded to -upload-url. The tokens are read from the environment to keep
+// them out of the process list.
+func runJob(args []string) error {
+	config := terraform.NewDriverConfig(nil, os.Getenv(terraform.VersionEnv), "job")
+	config.DownloadToken = os.Getenv(downloadTokenEnv)
+	config.UploadToken = os.Getenv(uploadTokenEnv)
+
+	fs := flag.NewFlagSet("job", flag.ExitOnError)
+	fs.Var(&config.Actions, "action", "terraform action to run, repeatable")
+	fs.StringVar(&config.WorkDir, "work-dir", config.WorkDir, "directory the work dir is unpacked into")
+	fs.StringVar(&config.Version, "version", config.Version, "terraform version, latest, a constraint or path")
+	fs.StringVar(&config.Engine, "engine", config.Engine, "terraform or tofu")
+	fs.StringVar(&config.DownloadUrl, "download-url", "", "url of a zip or tar.zst archive of the work dir")
+	fs.StringVar(&config.UploadUrl, "upload-url", "", "url the results are uploaded to as job.tar.zst")
+	fs.BoolVar(&config.SkipTLSVerify, "skip-tls-verify", false, "skip verifying the certificates of the urls")
+	fs.StringVar(&config.LogPath, "log-path", "", "path of terraform's log")
+	fs.StringVar(&config.LogLevel, "log-level", config.LogLevel, "level of ginie's log: debug, info, warn or error")
+	fs.StringVar(&config.TerraformLogLevel, "tf-log", config.TerraformLogLevel, "level of terraform's log: TRACE, DEBUG, INFO, WARN, ERROR, JSON or OFF")
+	fs.StringVar(&config.ProviderLogLevel, "tf-log-provider", config.ProviderLogLevel, "level of the providers' log: TRACE, DEBUG, INFO, WARN, ERROR or OFF")
+	fs.StringVar(&config.OverrideDir, "override-dir", config.OverrideDir, "directory of files layered onto the program")
+	fs.StringVar(&config.ContextDataDir, "context-data-dir", config.ContextDataDir, "directory of files made available below contextdata/")
+	fs.Var(&config.BackendConfig, "backend-config", "backend configuration, repeatable")
+	fs.Var(&config.Var, "var", "input variable, repeatable")
+	fs.Var(&config.VarFile, "var-file", "input variables file, repeatable")
+	fs.BoolVar(&config.RefreshOnly, "refresh-only", false, "plan only the drift of the state, not the changes of the program")
+	fs.BoolVar(&config.Debug, "debug", false, "print plan and outputs as well")
+	_ = fs.Parse(args)
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	ctx, stop := terraform.SetupSignalHandler(context.Background())
+	defer stop()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: config.SlogLevel()}))
+	return terraform.NewTerraformRunner(logger, config).Execute(ctx)
+}
@@ -0,0 +1,28 @@
+// Package llm abstracts the language model Ginie converses with, so the REPL,
+// the API server and tests can swap the OpenAI backed provider for another.
+package llm
+
+import "context"
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a single turn of a conversation.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Provider completes a conversation with the next assistant message.
+type Provider interface {
+	Complete(ctx context.Context, messages []Message) (string, error)
+}
+
+// Streamer is implemented by providers able to hand out the reply token by
+// token while it is being generated.
+type Streamer interface {
+	Stream(ctx context.Context, messages []Message, onToken func(string)) (string, error)
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+)
+
+const (
+	apiEndpoint = "https://api.openai.com/v1"
+)
+
+// OpenAI is a Provider backed by the OpenAI chat completions API.
+type OpenAI struct {
+	client            *azopenai.Client
+	modelDeploymentID string
+}
+
+func NewOpenAI(apiKey, model string) (*OpenAI, error) {
+	keyCredential := azcore.NewKeyCredential(apiKey)
+	client, err := azopenai.NewClientForOpenAI(apiEndpoint, keyCredential, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OpenAI{
+		client:            client,
+		modelDeploymentID: model,
+	}, nil
+}
+
+func (o *OpenAI) Complete(ctx context.Context, messages []Message) (string, error) {
+	resp, err := o.client.GetChatCompletions(ctx, azopenai.ChatCompletionsOptions{
+		// This is a conversation in progress.
+		// NOTE: all messages count against token usage for this API.
+		Messages:       toChatMessages(messages),
+		DeploymentName: &o.modelDeploymentID,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	completion := ""
+	for _, choice := range resp.Choices {
+
+		if choice.ContentFilterResults != nil {
+			printContentFilterResults(choice.ContentFilterResults)
+		}
+
+		if choice.Message != nil && choice.Message.Content != nil {
+			completion = *choice.Message.Content
+		}
+
+	}
+	return completion, nil
+}
+
+func (o *OpenAI) Stream(ctx context.Context, messages []Message, onToken func(string)) (string, error) {
+	resp, err := o.client.GetChatCompletionsStream(ctx, azopenai.ChatCompletionsOptions{
+		Messages:       toChatMessages(messages),
+		DeploymentName: &o.modelDeploymentID,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.ChatCompletionsStream.Close()
+
+	var completion strings.Builder
+	for {
+		chatCompletions, err := resp.ChatCompletionsStream.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		for _, choice := range chatCompletions.Choices {
+			if choice.Delta != nil && choice.Delta.Content != nil {
+				completion.WriteString(*choice.Delta.Content)
+				onToken(*choice.Delta.Content)
+			}
+		}
+	}
+	return completion.String(), nil
+}
+
+func toChatMessages(messages []Message) []azopenai.ChatRequestMessageClassification {
+	var chatMessages []azopenai.ChatRequestMessageClassification
+	for _, m := range messages {
+		switch m.Role {
+		case RoleSystem:
+			chatMessages = append(chatMessages, &azopenai.ChatRequestSystemMessage{Content: to.Ptr(m.Content)})
+		case RoleAssistant:
+			chatMessages = append(chatMessages, &azopenai.ChatRequestAssistantMessage{Content: to.Ptr(m.Content)})
+		default:
+			chatMessages = append(chatMessages, &azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent(m.Content)})
+		}
+	}
+	return chatMessages
+}
+
+func printContentFilterResults(results *azopenai.ContentFilterResultsForChoice) {
+	fmt.Fprintf(os.Stderr, "Content filter results\n")
+
+	if results.Error != nil {
+		fmt.Fprintf(os.Stderr, "  Error:%v\n", results.Error)
+	}
+
+	fmt.Fprintf(os.Stderr, "  Hate: sev: %v, filtered: %v\n", *results.Hate.Severity, *results.Hate.Filtered)
+	fmt.Fprintf(os.Stderr, "  SelfHarm: sev: %v, filtered: %v\n", *results.SelfHarm.Severity, *results.SelfHarm.Filtered)
+	fmt.Fprintf(os.Stderr, "  Sexual: sev: %v, filtered: %v\n", *results.Sexual.Severity, *results.Sexual.Filtered)
+	fmt.Fprintf(os.Stderr, "  Violence: sev: %v, filtered: %v\n", *results.Violence.Severity, *results.Violence.Filtered)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// logs handles !logs runs, listing the runs with logs, and
+// !logs [run|latest] [pattern], printing the logs of a run, the latest one by
+// default, or only their lines containing pattern. On a terminal the logs are
+// paged with $PAGER.
+func (g *ginie) logs(args []string) error {
+	if len(args) == 1 && args[0] == "runs" {
+		runs, err := g.sess.LoggedRuns()
+		if err != nil {
+			return err
+		}
+		for _, r := range runs {
+			fmt.Printf("%s  %s\n", r.Time.Format("2006-01-02 15:04:05"), r.ID)
+		}
+		return nil
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("usage: !logs [runs|run|latest] [pattern]")
+	}
+
+	var id, pattern string
+	if len(args) > 0 && args[0] != "latest" {
+		id = args[0]
+	}
+	if len(args) > 1 {
+		pattern = args[1]
+	}
+
+	files, err := g.sess.RunLogFiles(id)
+	if err != nil {
+		return err
+	}
+
+	return page(func(w io.Writer) error {
+		for _, file := range files {
+			fmt.Fprintf(w, "==> %s <==\n", filepath.Base(file))
+			if err := printLog(w, file, pattern); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// printLog copies the log to w, only the lines containing pattern if set.
+func printLog(w io.Writer, path, pattern string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if pattern == "" || strings.Contains(line, pattern) {
+			fmt.Fprintln(w, line)
+		}
+	}
+	return scanner.Err()
+}
+
+// page sends what write writes through $PAGER, or less, when stdout is a
+// terminal, otherwise straight to stdout.
+func page(write func(io.Writer) error) error {
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less"}
+	}
+	if !isTerminal(os.Stdout) {
+		return write(os.Stdout)
+	}
+	path, err := exec.LookPath(pager[0])
+	if err != nil {
+		return write(os.Stdout)
+	}
+
+	cmd := exec.Command(path, pager[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	writeErr := write(in)
+	in.Close()
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	// the pager was quit before reading everything
+	if writeErr != nil && !strings.Contains(writeErr.Error(), "broken pipe") {
+		return writeErr
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stdout := os.Stdout
+	os.Stdout = file
+	err = f()
+	os.Stdout = stdout
+
+	b, readErr := os.ReadFile(file.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return string(b), err
+}
+
+func TestLogs(t *testing.T) {
+	g := newScriptGinie(t, false)
+	logDir := g.sess.Workspace().LogDir()
+	now := time.Now()
+	for i, id := range []string{"first", "latest"} {
+		dir := filepath.Join(logDir, id)
+		writeLog := func(name, content string) {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeLog(terraform.OutputLogFile, "Initializing "+id+"\nApply complete!\n")
+		writeLog(terraform.GinieLogFile, `{"level":"INFO","msg":"running terraform command","run":"`+id+`"}`+"\n")
+		modTime := now.Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args []string
+		want string
+		err  string
+	}{
+		{
+			args: nil,
+			want: "==> output.log <==\nInitializing latest\nApply complete!\n" +
+				"==> ginie.log <==\n{\"level\":\"INFO\",\"msg\":\"running terraform command\",\"run\":\"latest\"}\n",
+		},
+		{
+			args: []string{"latest", "Initializing"},
+			want: "==> output.log <==\nInitializing latest\n==> ginie.log <==\n",
+		},
+		{
+			args: []string{"first", "run"},
+			want: "==> output.log <==\n==> ginie.log <==\n{\"level\":\"INFO\",\"msg\":\"running terraform command\",\"run\":\"first\"}\n",
+		},
+		{
+			args: []string{"runs"},
+			want: now.Add(-time.Hour).Format("2006-01-02 15:04:05") + "  latest\n" +
+				now.Add(-2*time.Hour).Format("2006-01-02 15:04:05") + "  first\n",
+		},
+		{args: []string{"second"}, err: "no logs for run second"},
+		{args: []string{"first", "run", "web"}, err: "usage: !logs [runs|run|latest] [pattern]"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(append([]string{"!logs"}, tt.args...), " "), func(t *testing.T) {
+			got, err := captureStdout(t, func() error { return g.logs(tt.args) })
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
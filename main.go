@@ -3,180 +3,509 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/niravparikh05/ginie-ai/backend"
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/llm"
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/server"
+	"github.com/niravparikh05/ginie-ai/session"
 	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
 )
 
 const (
-	work_dir = "gen-ai-tf"
+	work_dir  = "gen-ai-tf"
+	state_dir = ".state"
 )
 
-const (
-	apiEndpoint = "https://api.openai.com/v1"
-)
-
-var (
-	messages          []azopenai.ChatRequestMessageClassification
-	modelDeploymentID string
-	temperature       float32
-)
+const usage = `usage:
+  ginie                   start a conversation
+  ginie run [script]      execute a prompt script, stdin if omitted
+  ginie serve [-addr]     serve the HTTP/JSON API
+  ginie tf ACTION [ARGS]  run a terraform action on the default workspace
+  ginie job [flags]       run terraform as a stateless job
+  ginie drift [flags]     detect drift of the workspaces
+  ginie history [flags]   list the runs of the workspaces
+  ginie audit COMMAND     verify the history, create a key to sign it`
 
 func main() {
-	fmt.Println("Hey There ! I am Ginie, What would you like to spin up today ?")
+	// ginie run <script> executes a prompt script non-interactively, so does
+	// piping a script into ginie's stdin.
+	var script, addr string
+	var driftInterval time.Duration
+	var tfArgs []string
+	serve := false
+	batch := !isTerminal(os.Stdin)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			batch = true
+			if len(os.Args) > 2 {
+				script = os.Args[2]
+			}
+		case "serve":
+			serve = true
+			fs := flag.NewFlagSet("serve", flag.ExitOnError)
+			fs.StringVar(&addr, "addr", ":8080", "address to listen on")
+			fs.DurationVar(&driftInterval, "drift-interval", 0, "detect the drift of all workspaces this often, e.g. 1h")
+			_ = fs.Parse(os.Args[2:])
+		case "job":
+			if err := runJob(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		case "drift":
+			os.Exit(runDrift(os.Args[2:]))
+		case "audit":
+			os.Exit(runAudit(os.Args[2:]))
+		case "history":
+			if err := runHistory(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		case "tf":
+			tfArgs = os.Args[2:]
+			if len(tfArgs) == 0 {
+				fmt.Fprintf(os.Stderr, "usage: ginie tf ACTION [ARGS]\nactions: %s\n", strings.Join(session.Actions(), ", "))
+				os.Exit(2)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n%s\n", os.Args[1], usage)
+			os.Exit(2)
+		}
+	}
+
+	// terraform actions on an existing program need no model
+	if tfArgs != nil {
+		if err := runTerraform(tfArgs); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !batch && !serve {
+		fmt.Println("Hey There ! I am Ginie, What would you like to spin up today ?")
+	}
 
 	if len(os.Getenv("OPENAI_API_KEY")) == 0 || len(os.Getenv("OPENAI_MODEL")) == 0 {
 		fmt.Fprintf(os.Stderr, "Skipping example, environment variables missing\n")
+		if batch || serve {
+			os.Exit(1)
+		}
 		return
 	}
 
-	keyCredential := azcore.NewKeyCredential(os.Getenv("OPENAI_API_KEY"))
-	client, err := azopenai.NewClientForOpenAI(apiEndpoint, keyCredential, nil)
+	provider, err := llm.NewOpenAI(os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_MODEL"))
 	if err != nil {
 		log.Fatalf("ERROR: %s", err)
 	}
 
-	modelDeploymentID = os.Getenv("OPENAI_MODEL")
-	temperature = float32(0.8)
-
-	/// This is a conversation in progress.
-	// NOTE: all messages, regardless of role, count against token usage for this API.
-	messages = []azopenai.ChatRequestMessageClassification{
-		// You set the tone and rules of the conversation with a prompt as the system role.
-		&azopenai.ChatRequestSystemMessage{Content: to.Ptr(`You are Ginie, an AI conversation assistant that builds and deploys Cloud Infrastructure written in Terraform.
-		Generate a description of the Terraform program you will define, followed by a single Terraform program which includes default values in response to each of my Instructions.
-		I will then deploy that program for you and let you know if there were errors.
-		You should modify the current program based on my instructions.
-		You should not start from scratch unless asked.`)},
+	workspaces, err := openWorkspaces()
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
 
-		// The user asks a question
-		&azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent("Can you help create a working terraform template with default values and credentials section which I will update later if needed?")},
+	// plans are checked against the policy before they are applied
+	rules, err := loadPolicy()
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	acceptWarnings, err := acceptPolicyWarnings()
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	pricing, err := loadPricing()
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
 
-		// The reply would come back from the ChatGPT. You'd add it to the conversation so we can maintain context.
-		&azopenai.ChatRequestAssistantMessage{Content: to.Ptr("Of course! Which resource would you like to create?")},
+	// state of all workspaces is kept by the built-in http backend
+	stateBackend, err := backend.Start(filepath.Join(work_dir, state_dir))
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
 	}
 
-	_, err = client.GetChatCompletions(context.Background(), azopenai.ChatCompletionsOptions{
-		// This is a conversation in progress.
-		// NOTE: all messages count against token usage for this API.
-		Messages:       messages,
-		DeploymentName: &modelDeploymentID,
-		Temperature:    &temperature,
-	}, nil)
+	if serve {
+		logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+		if err := listenAndServe(addr, driftInterval, provider, workspaces, stateBackend, rules, pricing, logger); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		return
+	}
 
+	ws, err := workspaces.Open(workspace.DefaultName)
 	if err != nil {
 		log.Fatalf("ERROR: %s", err)
 	}
+	g := &ginie{
+		sess:       session.New(session.NewID(), ws, provider),
+		workspaces: workspaces,
+	}
+	g.sess.SetBackend(stateBackend)
+	if err := recordHistory(g.sess); err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	g.sess.SetPolicy(rules)
+	g.sess.SetAcceptPolicyWarnings(acceptWarnings)
+	g.sess.SetPricing(pricing)
+
+	if batch {
+		os.Exit(g.runBatch(script))
+	}
 
 	scanner := bufio.NewScanner(os.Stdin)
+	g.sess.SetPolicyPrompt(confirmWarnings(scanner))
 	for {
 		fmt.Print(">>> ")
-		scanner.Scan()
-		err := scanner.Err()
-		if err != nil {
-			log.Fatal(err)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				log.Fatal(err)
+			}
+			return
 		}
-		query := scanner.Text()
-		//query := "!deploy"
-		switch query {
-		case "!quit":
+		// Ctrl-C cancels the command in flight and returns to the prompt
+		ctx, stop := terraform.SetupSignalHandler(context.Background())
+		quit, err := g.handle(ctx, scanner.Text())
+		if ctx.Err() != nil {
+			fmt.Println("interrupted")
+		} else if err != nil {
+			fmt.Println(err.Error())
+		}
+		stop()
+		if quit {
 			return
-		case "!deploy":
-
-			response, err := callLlm(client, "respond with only terraform hcl code")
-			if err != nil {
-				log.Fatalf("ERROR: %s", err)
-			}
-			writeToFile("main.tf", response)
-
-			// deploy using terraform
-			fmt.Println("hold on ! publishing the infrastructure for you.")
-			config := terraform.NewDriverConfig([]string{"init", "plan", "apply"}, "1.5.7", work_dir)
-			tfRunner := terraform.NewTerraformRunner(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})), config)
-			if err := tfRunner.Execute(); err != nil {
-				fmt.Println("failed to publish infrastructure: ", err.Error())
-			}
-		case "!destroy":
-			// destroy using terraform
-			fmt.Println("hold on ! destroying the infrastructure for you.")
-			config := terraform.NewDriverConfig([]string{"init", "destroy"}, "1.5.7", string(work_dir))
-			tfRunner := terraform.NewTerraformRunner(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})), config)
-			if err := tfRunner.Execute(); err != nil {
-				fmt.Println("failed to destroy infrastructure: ", err.Error())
-			}
-		default:
-			response, err := callLlm(client, query)
-			if err != nil {
-				log.Fatalf("ERROR: %s", err)
-			}
-			fmt.Fprintf(os.Stderr, "%s\n", response)
 		}
 	}
 
 }
 
-func callLlm(client *azopenai.Client, query string) (string, error) {
-	messages = append(messages, &azopenai.ChatRequestUserMessage{
-		Content: azopenai.NewChatRequestUserMessageContent(query),
-	})
+// ginie is a conversation driven from the terminal or a script.
+type ginie struct {
+	sess       *session.Session
+	workspaces *workspace.Manager
+}
+
+// handle processes a single line of input, either a prompt for the model or a
+// ! command. It reports whether the session should end.
+func (g *ginie) handle(ctx context.Context, query string) (bool, error) {
+	sess := g.sess
+
+	args := strings.Fields(query)
+	if len(args) > 0 && args[0] == "!workspace" {
+		return false, g.workspace(args[1:])
+	}
+	if len(args) > 0 && args[0] == "!logs" {
+		return false, g.logs(args[1:])
+	}
+	if len(args) > 0 && args[0] == "!drift" {
+		return false, g.drift(ctx, args[1:])
+	}
+	if len(args) > 0 && args[0] == "!budget" {
+		return false, g.budget(args[1:])
+	}
+	if len(args) > 0 && args[0] == "!history" {
+		return false, g.history(args[1:])
+	}
+	if len(args) > 0 && args[0] == "!stacks" {
+		return false, g.stacks(args[1:])
+	}
+	if len(args) > 0 && args[0] == "!engine" {
+		return false, g.engine(args[1:])
+	}
+	if len(args) > 0 && args[0] == "!tf-workspace" {
+		return false, g.tfWorkspace(ctx, args[1:])
+	}
+	if action, actionArgs, ok := terraformCommand(args); ok {
+		_, err := sess.Run(ctx, action, os.Stdout, actionArgs...)
+		return false, err
+	}
+
+	switch query {
+	case "!quit":
+		return true, nil
+	case "!deploy":
+		return false, g.deploy(ctx)
+	case "!plan":
+		if err := sess.Generate(ctx); err != nil {
+			return false, fmt.Errorf("ERROR: %s", err)
+		}
+
+		fmt.Println("hold on ! planning the infrastructure for you.")
+		r, err := sess.Run(ctx, session.ActionPlan, os.Stdout)
+		if err != nil {
+			return false, fmt.Errorf("failed to plan infrastructure: %s", err)
+		}
+		printPlan(r)
+	case "!destroy":
+		// destroy using terraform
+		fmt.Println("hold on ! destroying the infrastructure for you.")
+		if _, err := sess.Run(ctx, session.ActionDestroy, os.Stdout); err != nil {
+			return false, fmt.Errorf("failed to destroy infrastructure: %s", err)
+		}
+	default:
+		response, err := sess.Send(ctx, query)
+		if err != nil {
+			return false, fmt.Errorf("ERROR: %s", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", response)
+	}
+	return false, nil
+}
+
+// workspace handles !workspace list, !workspace new <name> and
+// !workspace switch <name>.
+func (g *ginie) workspace(args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
 
-	resp, err := client.GetChatCompletions(context.Background(), azopenai.ChatCompletionsOptions{
-		// This is a conversation in progress.
-		// NOTE: all messages count against token usage for this API.
-		Messages:       messages,
-		DeploymentName: &modelDeploymentID,
-	}, nil)
+	switch args[0] {
+	case "list":
+		workspaces, err := g.workspaces.List()
+		if err != nil {
+			return err
+		}
+		current := g.sess.Workspace().Name
+		for _, ws := range workspaces {
+			marker := " "
+			if ws.Name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, ws.Name)
+		}
+	case "new", "switch":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: !workspace %s <name>", args[0])
+		}
+		var ws *workspace.Workspace
+		var err error
+		if args[0] == "new" {
+			ws, err = g.workspaces.Create(args[1])
+		} else {
+			ws, err = g.workspaces.Get(args[1])
+		}
+		if err != nil {
+			return err
+		}
+		if err := g.sess.SetWorkspace(ws); err != nil {
+			return err
+		}
+		fmt.Printf("switched to workspace %s\n", ws.Name)
+	default:
+		return fmt.Errorf("usage: !workspace [list|new <name>|switch <name>]")
+	}
+	return nil
+}
+
+// listenAndServe serves the API until SIGINT or SIGTERM, which also stops the
+// runs in progress. With a drift interval the drift of all workspaces is
+// detected on that schedule.
+func listenAndServe(addr string, driftInterval time.Duration, provider llm.Provider, workspaces *workspace.Manager, stateBackend *backend.Local, rules *policy.Policy, pricing *cost.Catalog, logger *slog.Logger) error {
+	ctx, stop := terraform.SetupSignalHandler(context.Background())
+	defer stop()
 
+	handler := server.New(ctx, provider, workspaces, stateBackend, logger)
+	auditLog, err := openHistory()
 	if err != nil {
-		log.Fatalf("ERROR: %s", err)
-		return "", err
+		return err
+	}
+	handler.SetAuditLog(auditLog)
+	handler.SetPolicy(rules)
+	acceptWarnings, err := acceptPolicyWarnings()
+	if err != nil {
+		return err
+	}
+	handler.SetAcceptPolicyWarnings(acceptWarnings)
+	handler.SetPricing(pricing)
+	if driftInterval > 0 {
+		handler.ScheduleDrift(driftInterval)
+	}
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		// ends the event streams on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
 
-	completion := ""
-	for _, choice := range resp.Choices {
+	logger.Info("serving ginie api", "addr", addr, "backend", stateBackend.URL)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
 
-		if choice.ContentFilterResults != nil {
-			fmt.Fprintf(os.Stderr, "Content filter results\n")
+// tfWorkspace handles !tf-workspace list, !tf-workspace new <name>,
+// !tf-workspace select <name> and !tf-workspace delete <name>, managing the
+// terraform workspaces the program of the current workspace is deployed into.
+func (g *ginie) tfWorkspace(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
 
-			if choice.ContentFilterResults.Error != nil {
-				fmt.Fprintf(os.Stderr, "  Error:%v\n", choice.ContentFilterResults.Error)
+	switch args[0] {
+	case "list":
+		workspaces, err := g.sess.TerraformWorkspaces(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		current := g.sess.TerraformWorkspace()
+		for _, name := range workspaces {
+			marker := " "
+			if name == current {
+				marker = "*"
 			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+	case "new", "select", "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: !tf-workspace %s <name>", args[0])
+		}
+		var err error
+		switch args[0] {
+		case "new":
+			err = g.sess.NewTerraformWorkspace(ctx, args[1], os.Stdout)
+		case "select":
+			err = g.sess.SelectTerraformWorkspace(ctx, args[1], os.Stdout)
+		case "delete":
+			err = g.sess.DeleteTerraformWorkspace(ctx, args[1], os.Stdout)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("terraform workspace is %s\n", g.sess.TerraformWorkspace())
+	default:
+		return fmt.Errorf("usage: !tf-workspace [list|new <name>|select <name>|delete <name>]")
+	}
+	return nil
+}
 
-			fmt.Fprintf(os.Stderr, "  Hate: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Hate.Severity, *choice.ContentFilterResults.Hate.Filtered)
-			fmt.Fprintf(os.Stderr, "  SelfHarm: sev: %v, filtered: %v\n", *choice.ContentFilterResults.SelfHarm.Severity, *choice.ContentFilterResults.SelfHarm.Filtered)
-			fmt.Fprintf(os.Stderr, "  Sexual: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Sexual.Severity, *choice.ContentFilterResults.Sexual.Filtered)
-			fmt.Fprintf(os.Stderr, "  Violence: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Violence.Severity, *choice.ContentFilterResults.Violence.Filtered)
+// engine handles !engine, showing the engine of the current workspace, and
+// !engine terraform|tofu, switching it.
+func (g *ginie) engine(args []string) error {
+	switch len(args) {
+	case 0:
+	case 1:
+		if err := g.sess.SetEngine(args[0]); err != nil {
+			return err
 		}
+	default:
+		return fmt.Errorf("usage: !engine [terraform|tofu]")
+	}
+	fmt.Printf("workspace %s runs %s\n", g.sess.Workspace().Name, terraform.EngineName(g.sess.Engine()))
+	return nil
+}
+
+// terraformCommand maps !validate, !fmt, !refresh, !import, !state,
+// !taint, !untaint, !graph and !providers to the session action they run.
+func terraformCommand(args []string) (string, []string, bool) {
+	if len(args) == 0 {
+		return "", nil, false
+	}
 
-		if choice.Message != nil && choice.Message.Content != nil {
-			completion = *choice.Message.Content
+	switch args[0] {
+	case "!validate", "!fmt", "!refresh", "!import", "!taint", "!untaint", "!graph":
+		return strings.TrimPrefix(args[0], "!"), args[1:], true
+	case "!state", "!providers":
+		if len(args) < 2 {
+			return "", nil, false
 		}
+		return strings.TrimPrefix(args[0], "!") + "-" + args[1], args[2:], true
+	}
+	return "", nil, false
+}
 
+// openWorkspaces returns the workspaces below work_dir. The program and state
+// an older version kept in work_dir itself are moved into the default
+// workspace first.
+func openWorkspaces() (*workspace.Manager, error) {
+	workspaces := workspace.NewManager(work_dir)
+	moved, err := workspaces.MigrateLegacy()
+	if err != nil {
+		return nil, fmt.Errorf("error migrating %s: %s", work_dir, err)
 	}
-	return completion, nil
+	if len(moved) > 0 {
+		fmt.Fprintf(os.Stderr, "Moved %s from %s into the %s workspace\n", strings.Join(moved, ", "), work_dir, workspace.DefaultName)
+	}
+	return workspaces, nil
 }
 
-func writeToFile(fileName, content string) {
-	f, err := os.Create(fmt.Sprintf("%s/%s", work_dir, fileName))
+// runTerraform runs a terraform action on the program of the default
+// workspace, e.g. ginie tf state-list.
+func runTerraform(args []string) error {
+	workspaces, err := openWorkspaces()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	ws, err := workspaces.Open(workspace.DefaultName)
+	if err != nil {
+		return err
+	}
+	stateBackend, err := backend.Start(filepath.Join(work_dir, state_dir))
+	if err != nil {
+		return err
+	}
+
+	rules, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	acceptWarnings, err := acceptPolicyWarnings()
+	if err != nil {
+		return err
+	}
+	pricing, err := loadPricing()
+	if err != nil {
+		return err
+	}
+
+	sess := session.New(session.NewID(), ws, nil)
+	sess.SetBackend(stateBackend)
+	if err := recordHistory(sess); err != nil {
+		return err
+	}
+	sess.SetPolicy(rules)
+	sess.SetAcceptPolicyWarnings(acceptWarnings)
+	sess.SetPricing(pricing)
 
-	var hcl string
-	strs := strings.SplitAfter(content, "```")
-	hcl = strings.ReplaceAll(strs[1], "```", "")
-	hcl = strings.ReplaceAll(hcl, "hcl", "")
+	ctx, stop := terraform.SetupSignalHandler(context.Background())
+	defer stop()
+	r, err := sess.Run(ctx, args[0], os.Stdout, args[1:]...)
+	if err == nil {
+		info := r.Info()
+		if info.Cost != nil {
+			fmt.Println(info.Cost.Summary())
+		}
+		// the warnings of the policy, errors fail the run
+		for _, v := range info.Violations {
+			fmt.Println(v)
+		}
+	}
+	return err
+}
 
-	_, err = f.WriteString(strings.TrimSpace(hcl))
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
 	if err != nil {
-		log.Fatal(err)
+		return false
 	}
-	f.Close()
+	return fi.Mode()&os.ModeCharDevice != 0
 }
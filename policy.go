@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/session"
+)
+
+// policyFixAttempts is how often !deploy asks the model to fix a program the
+// policy blocks before giving up.
+const policyFixAttempts = 2
+
+// loadPolicy reads the policy plans are checked against, from the file in
+// GINIE_POLICY_FILE or else gen-ai-tf/policy.json, nil if there is none.
+func loadPolicy() (*policy.Policy, error) {
+	path := os.Getenv("GINIE_POLICY_FILE")
+	if path == "" {
+		path = filepath.Join(work_dir, "policy.json")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	return policy.Load(path)
+}
+
+// acceptPolicyWarnings reports whether plans with violations of warn rules
+// are applied where no one is asked to confirm them, e.g. by scripts, as set
+// in GINIE_POLICY_ACCEPT_WARNINGS.
+func acceptPolicyWarnings() (bool, error) {
+	v := os.Getenv("GINIE_POLICY_ACCEPT_WARNINGS")
+	if v == "" {
+		return false, nil
+	}
+	accept, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid GINIE_POLICY_ACCEPT_WARNINGS: %s", v)
+	}
+	return accept, nil
+}
+
+// confirmWarnings asks on the terminal whether to apply a plan despite the
+// warnings of the policy.
+func confirmWarnings(input *bufio.Scanner) session.PolicyPrompt {
+	return func(warnings []policy.Violation) bool {
+		for _, v := range warnings {
+			fmt.Println(v)
+		}
+		fmt.Print("The plan violates the policy, apply anyway? [y/N] ")
+		if !input.Scan() {
+			return false
+		}
+		answer := strings.ToLower(strings.TrimSpace(input.Text()))
+		return answer == "y" || answer == "yes"
+	}
+}
+
+// deploy handles !deploy, generating the program and applying it. A program
+// the policy blocks is sent back to the model to be fixed and deployed again.
+func (g *ginie) deploy(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		if err := g.sess.Generate(ctx); err != nil {
+			return fmt.Errorf("ERROR: %s", err)
+		}
+
+		// deploy using terraform
+		fmt.Println("hold on ! publishing the infrastructure for you.")
+		r, err := g.sess.Run(ctx, session.ActionApply, os.Stdout)
+		if err == nil {
+			return nil
+		}
+		if r == nil || !r.Info().PolicyBlocked || attempt == policyFixAttempts || ctx.Err() != nil {
+			return fmt.Errorf("failed to publish infrastructure: %s", err)
+		}
+		info := r.Info()
+
+		for _, v := range info.Violations {
+			fmt.Println(v)
+		}
+		fmt.Println("the plan violates the policy, asking for a fix.")
+		response, err := g.sess.FixPolicyViolations(ctx, info.Violations)
+		if err != nil {
+			return fmt.Errorf("ERROR: %s", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", response)
+	}
+}
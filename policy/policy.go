@@ -0,0 +1,383 @@
+// Package policy checks terraform plans against rules declared in a local
+// policy file, such as "S3 buckets must not be public" or "instances must
+// have an owner tag", before they are applied.
+//
+// A policy file is JSON:
+//
+//	{
+//	  "rules": [
+//	    {
+//	      "name": "owner-tag",
+//	      "description": "instances must have an owner tag",
+//	      "severity": "error",
+//	      "resources": ["aws_instance"],
+//	      "conditions": [{"attribute": "tags.owner", "exists": true}]
+//	    },
+//	    {
+//	      "name": "eu-only",
+//	      "severity": "warn",
+//	      "conditions": [{"attribute": "region", "in": ["eu-west-1"]}]
+//	    }
+//	  ]
+//	}
+//
+// A rule applies to the resources of the types it names, * matching any part
+// of the type as in aws_s3_*, all of them by default, planned to be created,
+// updated or replaced unless it names other actions. Every condition must
+// hold for the values of a resource after the change, or the resource
+// violates the rule. Conditions on values only known once the change is
+// applied, e.g. computed attributes, are not checked. Violations of error
+// rules block the apply, those of warn rules need a confirmation.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+const (
+	SeverityError = "error"
+	SeverityWarn  = "warn"
+)
+
+// Policy is a set of rules plans are checked against.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is a requirement resources must meet.
+type Rule struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Severity is error, blocking the apply, or warn
+	Severity string `json:"severity"`
+	// Resources are the resource types the rule applies to, all by default
+	Resources []string `json:"resources,omitempty"`
+	// Actions are the planned actions the rule applies to, create, update
+	// and replace by default
+	Actions    []string    `json:"actions,omitempty"`
+	Conditions []Condition `json:"conditions"`
+}
+
+// Condition is a check of a single attribute of a resource. Only the checks
+// set apply.
+type Condition struct {
+	// Attribute is the dot separated path of the attribute, as in tags.owner
+	// or versioning.0.enabled. region is the region of the resource, that of
+	// its provider unless the resource sets its own.
+	Attribute string `json:"attribute"`
+	// Exists requires the attribute to be set, or not to be set if false
+	Exists *bool `json:"exists,omitempty"`
+	// Equals requires the attribute to have the value
+	Equals interface{} `json:"equals,omitempty"`
+	// In requires the attribute to have one of the values
+	In []interface{} `json:"in,omitempty"`
+	// NotIn requires the attribute to have none of the values
+	NotIn []interface{} `json:"notIn,omitempty"`
+	// Matches requires the attribute to be a string matching the regular
+	// expression
+	Matches string `json:"matches,omitempty"`
+
+	matches *regexp.Regexp
+}
+
+// Violation is a resource violating a rule.
+type Violation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Address  string `json:"address"`
+	Message  string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("[%s] %s: %s (%s)", v.Severity, v.Address, v.Message, v.Rule)
+}
+
+// Error is returned for a plan with violations that may not be applied.
+type Error struct {
+	Violations []Violation
+	// Unconfirmed is set if they are warnings no one was there to confirm
+	Unconfirmed bool
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		lines = append(lines, v.String())
+	}
+	if e.Unconfirmed {
+		lines = append(lines, "warnings need a confirmation that cannot be asked for here, accept them explicitly to apply anyway")
+	}
+	return fmt.Sprintf("plan violates the policy:\n%s", strings.Join(lines, "\n"))
+}
+
+// Load reads and validates the policy file at path.
+func Load(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) compile() error {
+	names := make(map[string]bool)
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Severity != SeverityError && rule.Severity != SeverityWarn {
+			return fmt.Errorf("rule %s: severity must be %s or %s", rule.Name, SeverityError, SeverityWarn)
+		}
+		if len(rule.Conditions) == 0 {
+			return fmt.Errorf("rule %s has no conditions", rule.Name)
+		}
+		for _, action := range rule.Actions {
+			switch action {
+			case terraform.ActionCreate, terraform.ActionUpdate, terraform.ActionReplace, terraform.ActionDelete:
+			default:
+				return fmt.Errorf("rule %s: unknown action %s", rule.Name, action)
+			}
+		}
+
+		for _, resource := range rule.Resources {
+			if _, err := path.Match(resource, ""); err != nil {
+				return fmt.Errorf("rule %s: invalid resource pattern %s", rule.Name, resource)
+			}
+		}
+		for j := range rule.Conditions {
+			condition := &rule.Conditions[j]
+			if condition.Attribute == "" {
+				return fmt.Errorf("rule %s: condition %d has no attribute", rule.Name, j+1)
+			}
+			if condition.Matches != "" {
+				re, err := regexp.Compile(condition.Matches)
+				if err != nil {
+					return fmt.Errorf("rule %s: %s", rule.Name, err)
+				}
+				condition.matches = re
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns the violations of the planned changes. The addresses of
+// changes of a stack are prefixed with it, STACK:ADDRESS.
+func (p *Policy) Check(stack string, plan *terraform.PlanResult) []Violation {
+	if p == nil || plan == nil {
+		return nil
+	}
+
+	var violations []Violation
+	for _, change := range plan.Changes() {
+		address := change.Address
+		if stack != "" {
+			address = stack + ":" + address
+		}
+		for _, rule := range p.Rules {
+			if !rule.appliesTo(change) {
+				continue
+			}
+			for _, condition := range rule.Conditions {
+				ok, reason := condition.check(change)
+				if ok {
+					continue
+				}
+				message := reason
+				if rule.Description != "" {
+					message = rule.Description + ": " + reason
+				}
+				violations = append(violations, Violation{
+					Rule:     rule.Name,
+					Severity: rule.Severity,
+					Address:  address,
+					Message:  message,
+				})
+				break
+			}
+		}
+	}
+	return violations
+}
+
+func (r *Rule) appliesTo(change terraform.ResourceChange) bool {
+	actions := r.Actions
+	if len(actions) == 0 {
+		actions = []string{terraform.ActionCreate, terraform.ActionUpdate, terraform.ActionReplace}
+	}
+	found := false
+	for _, action := range actions {
+		found = found || action == change.Action
+	}
+	if !found {
+		return false
+	}
+
+	if len(r.Resources) == 0 {
+		return true
+	}
+	for _, resource := range r.Resources {
+		if ok, _ := path.Match(resource, change.Type); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports whether the condition holds for the resource and why not.
+func (c *Condition) check(change terraform.ResourceChange) (bool, string) {
+	// a deleted resource has no values after the change, its values before
+	// are checked
+	values := change.After
+	if change.Action == terraform.ActionDelete {
+		values = change.Before
+	}
+	value, exists := lookup(values, c.Attribute)
+	if change.Action != terraform.ActionDelete && isUnknown(change.AfterUnknown, c.Attribute) {
+		// computed or interpolated, known once applied, so it cannot be
+		// checked before
+		if c.Attribute != "region" || change.Region == "" {
+			return true, ""
+		}
+		value, exists = nil, false
+	}
+	if !exists && c.Attribute == "region" && change.Region != "" {
+		value, exists = change.Region, true
+	}
+
+	if c.Exists != nil && *c.Exists != exists {
+		if exists {
+			return false, fmt.Sprintf("%s must not be set", c.Attribute)
+		}
+		return false, fmt.Sprintf("%s must be set", c.Attribute)
+	}
+	if c.Equals != nil && !equal(value, c.Equals) {
+		return false, fmt.Sprintf("%s must be %s, not %s", c.Attribute, format(c.Equals), format(value))
+	}
+	if c.In != nil && !contains(c.In, value) {
+		return false, fmt.Sprintf("%s must be one of %s, not %s", c.Attribute, format(c.In), format(value))
+	}
+	if c.NotIn != nil && contains(c.NotIn, value) {
+		return false, fmt.Sprintf("%s must not be %s", c.Attribute, format(value))
+	}
+	if c.matches != nil {
+		s, ok := value.(string)
+		if !ok || !c.matches.MatchString(s) {
+			return false, fmt.Sprintf("%s must match %s, not %s", c.Attribute, c.Matches, format(value))
+		}
+	}
+	return true, ""
+}
+
+// lookup returns the value at the dot separated path, whether it is set.
+func lookup(values interface{}, attribute string) (interface{}, bool) {
+	value := values
+	for _, key := range strings.Split(attribute, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, ok := index(key, len(v))
+			if !ok {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+	case string:
+		return v, v != ""
+	case []interface{}:
+		return v, len(v) > 0
+	case map[string]interface{}:
+		return v, len(v) > 0
+	}
+	return value, true
+}
+
+// index parses the key of an element of a list of n elements, only digits.
+func index(key string, n int) (int, bool) {
+	if key == "" || key[0] < '0' || key[0] > '9' {
+		return 0, false
+	}
+	i, err := strconv.Atoi(key)
+	return i, err == nil && i < n
+}
+
+// isUnknown reports whether the value at the dot separated path, or one
+// containing it, is only known once the change is applied, as terraform
+// marks them in after_unknown.
+func isUnknown(afterUnknown interface{}, attribute string) bool {
+	value := afterUnknown
+	for _, key := range strings.Split(attribute, ".") {
+		if unknown, _ := value.(bool); unknown {
+			return true
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, ok := index(key, len(v))
+			if !ok {
+				return false
+			}
+			value = v[i]
+		default:
+			return false
+		}
+	}
+	unknown, _ := value.(bool)
+	return unknown
+}
+
+// equal compares a value of the plan to one of the policy, both decoded from
+// JSON, so numbers are float64 on both sides.
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func contains(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func format(v interface{}) string {
+	if v == nil {
+		return "unset"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,374 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	p, err := Load(writePolicy(t, `{"rules": [
+		{"name": "owner-tag", "severity": "error", "resources": ["aws_*"], "actions": ["create", "delete"],
+		 "conditions": [{"attribute": "tags.owner", "exists": true}, {"attribute": "name", "matches": "^web-"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 1 || p.Rules[0].Conditions[1].matches == nil {
+		t.Fatalf("got %+v, want the rule with its expression compiled", p.Rules)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want a missing file", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{"json", `{"rules": [`, "unexpected end of JSON input"},
+		{"no name", `{"rules": [{"severity": "error", "conditions": [{"attribute": "a", "exists": true}]}]}`, "rule 1 has no name"},
+		{"duplicate", `{"rules": [
+			{"name": "a", "severity": "error", "conditions": [{"attribute": "a", "exists": true}]},
+			{"name": "a", "severity": "warn", "conditions": [{"attribute": "a", "exists": true}]}]}`, "duplicate rule a"},
+		{"severity", `{"rules": [{"name": "a", "severity": "fatal", "conditions": [{"attribute": "a", "exists": true}]}]}`, "rule a: severity must be error or warn"},
+		{"no severity", `{"rules": [{"name": "a", "conditions": [{"attribute": "a", "exists": true}]}]}`, "rule a: severity must be error or warn"},
+		{"no conditions", `{"rules": [{"name": "a", "severity": "error"}]}`, "rule a has no conditions"},
+		{"action", `{"rules": [{"name": "a", "severity": "error", "actions": ["read"], "conditions": [{"attribute": "a", "exists": true}]}]}`, "rule a: unknown action read"},
+		{"pattern", `{"rules": [{"name": "a", "severity": "error", "resources": ["aws_["], "conditions": [{"attribute": "a", "exists": true}]}]}`, "rule a: invalid resource pattern aws_["},
+		{"no attribute", `{"rules": [{"name": "a", "severity": "error", "conditions": [{"exists": true}]}]}`, "rule a: condition 1 has no attribute"},
+		{"regexp", `{"rules": [{"name": "a", "severity": "error", "conditions": [{"attribute": "a", "matches": "("}]}]}`, "rule a: error parsing regexp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writePolicy(t, tt.policy)
+			_, err := Load(path)
+			if err == nil {
+				t.Fatal("loaded an invalid policy")
+			}
+			if !strings.HasPrefix(err.Error(), "invalid policy "+path+": ") || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// compiled returns a policy of a single rule, loaded as from a file.
+func compiled(t *testing.T, rule Rule) *Policy {
+	t.Helper()
+	if rule.Name == "" {
+		rule.Name = "rule"
+	}
+	if rule.Severity == "" {
+		rule.Severity = SeverityError
+	}
+	p := &Policy{Rules: []Rule{rule}}
+	if err := p.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func plan(changes ...terraform.ResourceChange) *terraform.PlanResult {
+	p := &terraform.PlanResult{ResourceChanges: make(map[string][]terraform.ResourceChange)}
+	for _, change := range changes {
+		p.ResourceChanges[change.Action] = append(p.ResourceChanges[change.Action], change)
+	}
+	return p
+}
+
+func TestAppliesTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []string
+		actions   []string
+		change    terraform.ResourceChange
+		want      bool
+	}{
+		{"all types", nil, nil, terraform.ResourceChange{Type: "aws_instance", Action: terraform.ActionCreate}, true},
+		{"type", []string{"aws_instance"}, nil, terraform.ResourceChange{Type: "aws_instance", Action: terraform.ActionCreate}, true},
+		{"other type", []string{"aws_instance"}, nil, terraform.ResourceChange{Type: "aws_s3_bucket", Action: terraform.ActionCreate}, false},
+		{"glob", []string{"aws_s3_*"}, nil, terraform.ResourceChange{Type: "aws_s3_bucket", Action: terraform.ActionCreate}, true},
+		{"glob other type", []string{"aws_s3_*"}, nil, terraform.ResourceChange{Type: "aws_instance", Action: terraform.ActionCreate}, false},
+		{"second type", []string{"aws_instance", "google_*"}, nil, terraform.ResourceChange{Type: "google_compute_instance", Action: terraform.ActionCreate}, true},
+		{"update by default", nil, nil, terraform.ResourceChange{Action: terraform.ActionUpdate}, true},
+		{"replace by default", nil, nil, terraform.ResourceChange{Action: terraform.ActionReplace}, true},
+		{"not delete by default", nil, nil, terraform.ResourceChange{Action: terraform.ActionDelete}, false},
+		{"delete", nil, []string{terraform.ActionDelete}, terraform.ResourceChange{Action: terraform.ActionDelete}, true},
+		{"not other actions", nil, []string{terraform.ActionDelete}, terraform.ResourceChange{Action: terraform.ActionCreate}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := Rule{Resources: tt.resources, Actions: tt.actions}
+			if got := rule.appliesTo(tt.change); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditions(t *testing.T) {
+	yes, no := true, false
+	after := map[string]interface{}{
+		"name":   "web-1",
+		"count":  float64(2),
+		"empty":  "",
+		"tags":   map[string]interface{}{"owner": "ops"},
+		"ports":  []interface{}{float64(80), float64(443)},
+		"region": "eu-west-1",
+		"versioning": []interface{}{
+			map[string]interface{}{"enabled": true},
+		},
+	}
+	tests := []struct {
+		name      string
+		condition Condition
+		want      string
+	}{
+		{"exists", Condition{Attribute: "tags.owner", Exists: &yes}, ""},
+		{"exists unset", Condition{Attribute: "tags.team", Exists: &yes}, "tags.team must be set"},
+		{"exists empty", Condition{Attribute: "empty", Exists: &yes}, "empty must be set"},
+		{"not exists", Condition{Attribute: "tags.team", Exists: &no}, ""},
+		{"not exists set", Condition{Attribute: "tags.owner", Exists: &no}, "tags.owner must not be set"},
+		{"equals", Condition{Attribute: "name", Equals: "web-1"}, ""},
+		{"equals number", Condition{Attribute: "count", Equals: float64(2)}, ""},
+		{"equals other", Condition{Attribute: "name", Equals: "db-1"}, `name must be "db-1", not "web-1"`},
+		{"equals unset", Condition{Attribute: "tags.team", Equals: "ops"}, `tags.team must be "ops", not unset`},
+		{"in", Condition{Attribute: "tags.owner", In: []interface{}{"dev", "ops"}}, ""},
+		{"in other", Condition{Attribute: "tags.owner", In: []interface{}{"dev"}}, `tags.owner must be one of ["dev"], not "ops"`},
+		{"not in", Condition{Attribute: "count", NotIn: []interface{}{float64(0)}}, ""},
+		{"not in listed", Condition{Attribute: "count", NotIn: []interface{}{float64(1), float64(2)}}, "count must not be 2"},
+		{"matches", Condition{Attribute: "name", Matches: "^web-[0-9]+$"}, ""},
+		{"matches other", Condition{Attribute: "name", Matches: "^db-"}, `name must match ^db-, not "web-1"`},
+		{"matches number", Condition{Attribute: "count", Matches: "2"}, "count must match 2, not 2"},
+		{"list index", Condition{Attribute: "ports.1", Equals: float64(443)}, ""},
+		{"nested list index", Condition{Attribute: "versioning.0.enabled", Equals: true}, ""},
+		{"index out of range", Condition{Attribute: "ports.2", Exists: &yes}, "ports.2 must be set"},
+		{"index with suffix", Condition{Attribute: "ports.1abc", Exists: &yes}, "ports.1abc must be set"},
+		{"index with sign", Condition{Attribute: "ports.+1", Exists: &yes}, "ports.+1 must be set"},
+		{"negative index", Condition{Attribute: "ports.-1", Exists: &yes}, "ports.-1 must be set"},
+		{"key of a value", Condition{Attribute: "name.first", Exists: &yes}, "name.first must be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := compiled(t, Rule{Conditions: []Condition{tt.condition}})
+			violations := p.Check("", plan(terraform.ResourceChange{
+				Address: "aws_instance.web",
+				Type:    "aws_instance",
+				Action:  terraform.ActionCreate,
+				After:   after,
+			}))
+			if tt.want == "" {
+				if len(violations) != 0 {
+					t.Fatalf("got %v, want none", violations)
+				}
+				return
+			}
+			if len(violations) != 1 || violations[0].Message != tt.want {
+				t.Fatalf("got %v, want %q", violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegion(t *testing.T) {
+	eu := Condition{Attribute: "region", In: []interface{}{"eu-west-1"}}
+	tests := []struct {
+		name   string
+		change terraform.ResourceChange
+		want   string
+	}{
+		{"provider", terraform.ResourceChange{Region: "eu-west-1"}, ""},
+		{"provider elsewhere", terraform.ResourceChange{Region: "us-east-1"}, `region must be one of ["eu-west-1"], not "us-east-1"`},
+		{"own", terraform.ResourceChange{
+			Region: "us-east-1",
+			After:  map[string]interface{}{"region": "eu-west-1"},
+		}, ""},
+		{"own elsewhere", terraform.ResourceChange{
+			Region: "eu-west-1",
+			After:  map[string]interface{}{"region": "us-east-1"},
+		}, `region must be one of ["eu-west-1"], not "us-east-1"`},
+		{"unknown", terraform.ResourceChange{
+			AfterUnknown: map[string]interface{}{"region": true},
+		}, ""},
+		{"unknown with provider", terraform.ResourceChange{
+			Region:       "us-east-1",
+			AfterUnknown: map[string]interface{}{"region": true},
+		}, `region must be one of ["eu-west-1"], not "us-east-1"`},
+		{"none", terraform.ResourceChange{}, `region must be one of ["eu-west-1"], not unset`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := compiled(t, Rule{Conditions: []Condition{eu}})
+			tt.change.Address = "aws_instance.web"
+			tt.change.Action = terraform.ActionCreate
+			violations := p.Check("", plan(tt.change))
+			if tt.want == "" {
+				if len(violations) != 0 {
+					t.Fatalf("got %v, want none", violations)
+				}
+				return
+			}
+			if len(violations) != 1 || violations[0].Message != tt.want {
+				t.Fatalf("got %v, want %q", violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	yes := true
+	p := compiled(t, Rule{Conditions: []Condition{
+		{Attribute: "arn", Matches: "^arn:"},
+		{Attribute: "tags.owner", Exists: &yes},
+		{Attribute: "ingress.0.cidr", NotIn: []interface{}{"0.0.0.0/0"}},
+	}})
+	tests := []struct {
+		name         string
+		after        map[string]interface{}
+		afterUnknown interface{}
+		violates     bool
+	}{
+		{"known", map[string]interface{}{
+			"arn":     "arn:aws:s3:::logs",
+			"tags":    map[string]interface{}{"owner": "ops"},
+			"ingress": []interface{}{map[string]interface{}{"cidr": "10.0.0.0/8"}},
+		}, nil, false},
+		{"unknown values", nil, map[string]interface{}{
+			"arn":     true,
+			"tags":    map[string]interface{}{"owner": true},
+			"ingress": []interface{}{map[string]interface{}{"cidr": true}},
+		}, false},
+		{"unknown parents", nil, map[string]interface{}{
+			"arn":     true,
+			"tags":    true,
+			"ingress": true,
+		}, false},
+		{"unknown list element", map[string]interface{}{
+			"arn":  "arn:aws:s3:::logs",
+			"tags": map[string]interface{}{"owner": "ops"},
+		}, map[string]interface{}{
+			"ingress": []interface{}{true},
+		}, false},
+		{"known next to unknown", map[string]interface{}{
+			"tags": map[string]interface{}{"owner": "ops"},
+		}, map[string]interface{}{
+			"arn":     false,
+			"ingress": true,
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := p.Check("", plan(terraform.ResourceChange{
+				Address:      "aws_s3_bucket.logs",
+				Action:       terraform.ActionCreate,
+				After:        tt.after,
+				AfterUnknown: tt.afterUnknown,
+			}))
+			if got := len(violations) > 0; got != tt.violates {
+				t.Fatalf("got %v, want violations %v", violations, tt.violates)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	p := compiled(t, Rule{
+		Actions:    []string{terraform.ActionDelete},
+		Conditions: []Condition{{Attribute: "tags.env", NotIn: []interface{}{"prod"}}},
+	})
+	violations := p.Check("", plan(
+		terraform.ResourceChange{
+			Address: "aws_instance.prod",
+			Action:  terraform.ActionDelete,
+			Before:  map[string]interface{}{"tags": map[string]interface{}{"env": "prod"}},
+			// values of a delete are not known after it
+			AfterUnknown: map[string]interface{}{"tags": true},
+		},
+		terraform.ResourceChange{
+			Address: "aws_instance.dev",
+			Action:  terraform.ActionDelete,
+			Before:  map[string]interface{}{"tags": map[string]interface{}{"env": "dev"}},
+		},
+	))
+	if len(violations) != 1 || violations[0].Address != "aws_instance.prod" || violations[0].Message != `tags.env must not be "prod"` {
+		t.Fatalf("got %v, want a violation of aws_instance.prod", violations)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	yes := true
+	p := &Policy{Rules: []Rule{
+		{
+			Name:        "owner-tag",
+			Description: "instances must have an owner",
+			Severity:    SeverityError,
+			Resources:   []string{"aws_instance"},
+			Conditions: []Condition{
+				{Attribute: "tags.owner", Exists: &yes},
+				{Attribute: "tags.owner", Equals: "ops"},
+			},
+		},
+		{
+			Name:       "eu-only",
+			Severity:   SeverityWarn,
+			Conditions: []Condition{{Attribute: "region", In: []interface{}{"eu-west-1"}}},
+		},
+	}}
+	if err := p.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	violations := p.Check("network", plan(
+		terraform.ResourceChange{Address: "aws_instance.web", Type: "aws_instance", Action: terraform.ActionCreate, Region: "us-east-1"},
+		terraform.ResourceChange{Address: "aws_vpc.main", Type: "aws_vpc", Action: terraform.ActionUpdate, Region: "eu-west-1"},
+	))
+	want := []Violation{
+		// a rule is violated once, by its first failing condition
+		{Rule: "owner-tag", Severity: SeverityError, Address: "network:aws_instance.web", Message: "instances must have an owner: tags.owner must be set"},
+		{Rule: "eu-only", Severity: SeverityWarn, Address: "network:aws_instance.web", Message: `region must be one of ["eu-west-1"], not "us-east-1"`},
+	}
+	if len(violations) != len(want) {
+		t.Fatalf("got %v, want %v", violations, want)
+	}
+	for i := range want {
+		if violations[i] != want[i] {
+			t.Fatalf("got %v, want %v", violations[i], want[i])
+		}
+	}
+
+	if got := p.Check("", plan(terraform.ResourceChange{Address: "aws_instance.web", Type: "aws_instance", Action: terraform.ActionCreate, Region: "us-east-1"})); got[0].Address != "aws_instance.web" {
+		t.Fatalf("got %s, want the address without a stack", got[0].Address)
+	}
+	var none *Policy
+	if got := none.Check("", plan(terraform.ResourceChange{Address: "aws_instance.web", Action: terraform.ActionCreate})); got != nil {
+		t.Fatalf("got %v from no policy", got)
+	}
+}
+
+func TestError(t *testing.T) {
+	v := Violation{Rule: "eu-only", Severity: SeverityWarn, Address: "aws_instance.web", Message: "region must be eu-west-1"}
+	err := &Error{Violations: []Violation{v}}
+	if got, want := err.Error(), "plan violates the policy:\n[warn] aws_instance.web: region must be eu-west-1 (eu-only)"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	err.Unconfirmed = true
+	if !strings.HasSuffix(err.Error(), "accept them explicitly to apply anyway") {
+		t.Fatalf("got %q, want the warnings to be accepted explicitly", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/session"
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+const (
+	expectResource = "!expect-resource"
+)
+
+// runBatch runs the script non-interactively, returning the exit code of
+// ginie: 0 once every line succeeded or !quit, 1 if a line failed.
+func (g *ginie) runBatch(path string) int {
+	ctx, stop := terraform.SetupSignalHandler(context.Background())
+	defer stop()
+	if err := g.runScript(ctx, path); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runScript executes a prompt script line by line. Each line is either a
+// prompt for the model, a ! command or an assertion; blank lines and lines
+// starting with # are skipped. The first failing line aborts the script.
+// An empty path reads the script from stdin.
+func (g *ginie) runScript(ctx context.Context, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fmt.Printf(">>> %s\n", line)
+
+		var quit bool
+		var err error
+		if strings.HasPrefix(line, expectResource) {
+			err = assertResource(g.sess, strings.TrimSpace(strings.TrimPrefix(line, expectResource)))
+		} else {
+			quit, err = g.handle(ctx, line)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineNo, err)
+		}
+		if quit {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// assertResource checks that the given address is present in the state of
+// the last deployment.
+func assertResource(sess *session.Session, address string) error {
+	if address == "" {
+		return fmt.Errorf("%s requires a resource address", expectResource)
+	}
+
+	addresses, err := sess.StateResources()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(addresses, address) {
+		return fmt.Errorf("expected resource %s not found in state", address)
+	}
+	return nil
+}
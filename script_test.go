@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/session"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+const deployedState = `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "aws_s3_bucket", "name": "logs", "instances": [{}]},
+    {"mode": "managed", "type": "aws_instance", "name": "web", "instances": [{"index_key": 0}]}
+  ]
+}`
+
+// newScriptGinie returns a ginie without a model in a workspace of its own,
+// its state holding deployedState if deployed.
+func newScriptGinie(t *testing.T, deployed bool) *ginie {
+	t.Helper()
+	workspaces := workspace.NewManager(t.TempDir())
+	ws, err := workspaces.Open(workspace.DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployed {
+		if err := os.WriteFile(filepath.Join(ws.Dir, "terraform.tfstate"), []byte(deployedState), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &ginie{sess: session.New(session.NewID(), ws, nil), workspaces: workspaces}
+}
+
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.txt")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		deployed bool
+		script   string
+		err      string
+	}{
+		{"resources", true, "# the bucket and the instance\n\n!expect-resource aws_s3_bucket.logs\n  !expect-resource aws_instance.web[0]  \n", ""},
+		{"missing resource", true, "!expect-resource aws_s3_bucket.logs\n!expect-resource aws_s3_bucket.data\n",
+			"line 2: expected resource aws_s3_bucket.data not found in state"},
+		{"not deployed", false, "!expect-resource aws_s3_bucket.logs\n", "line 1: expected resource aws_s3_bucket.logs not found in state"},
+		{"no address", true, "# nothing to expect\n!expect-resource\n", "line 2: !expect-resource requires a resource address"},
+		{"first failure aborts", true, "!expect-resource aws_vpc.main\n!quit\n", "line 1: expected resource aws_vpc.main not found in state"},
+		{"quit", true, "!expect-resource aws_s3_bucket.logs\n!quit\n!expect-resource aws_vpc.main\n", ""},
+		{"prompt without a model", true, "a bucket for the logs\n", "line 1: ERROR: " + session.ErrNoProvider.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newScriptGinie(t, tt.deployed)
+			err := g.runScript(context.Background(), writeScript(t, tt.script))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		code   int
+	}{
+		{"assertions pass", "!expect-resource aws_s3_bucket.logs\n", 0},
+		{"assertion fails", "!expect-resource aws_vpc.main\n", 1},
+		{"quit", "!quit\n!expect-resource aws_vpc.main\n", 0},
+		{"empty", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newScriptGinie(t, true)
+			if code := g.runBatch(writeScript(t, tt.script)); code != tt.code {
+				t.Fatalf("got exit code %d, want %d", code, tt.code)
+			}
+		})
+	}
+
+	g := newScriptGinie(t, true)
+	if code := g.runBatch(filepath.Join(t.TempDir(), "missing.txt")); code != 1 {
+		t.Fatalf("got exit code %d for a missing script, want 1", code)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/session"
+)
+
+// ScheduleDrift detects the drift of every workspace once per interval until
+// the server's context is done. Workspaces with a run in progress are left
+// for the next round. The reports are kept in the workspaces and served from
+// /drift.
+func (s *Server) ScheduleDrift(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.detectDrift()
+			}
+		}
+	}()
+}
+
+func (s *Server) detectDrift() {
+	workspaces, err := s.workspaces.List()
+	if err != nil {
+		s.logger.Error("failed to list workspaces", "error", err)
+		return
+	}
+
+	for _, ws := range workspaces {
+		if s.ctx.Err() != nil {
+			return
+		}
+		sess := session.New(session.NewID(), ws, nil)
+		if s.backend != nil {
+			sess.SetBackend(s.backend)
+		}
+		s.mu.Lock()
+		if s.auditLog != nil {
+			sess.SetAuditLog(s.auditLog)
+		}
+		s.mu.Unlock()
+		sess.SetUser("scheduler")
+		report, err := sess.DetectDrift(s.ctx, io.Discard)
+		if errors.Is(err, session.ErrRunInProgress) {
+			s.logger.Info("skipping drift detection, run in progress", "workspace", ws.Name)
+			continue
+		}
+		if err != nil {
+			s.logger.Error("failed to detect drift", "workspace", ws.Name, "error", err)
+			continue
+		}
+		s.logger.Info("detected drift", "workspace", ws.Name, "drifted", report.Drifted, "resources", len(report.Drift))
+	}
+}
+
+// listDrift serves the latest drift report of every workspace.
+func (s *Server) listDrift(w http.ResponseWriter) {
+	workspaces, err := s.workspaces.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	latest := []*session.DriftReport{}
+	for _, ws := range workspaces {
+		reports, err := session.DriftReports(ws)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(reports) > 0 {
+			latest = append(latest, reports[0])
+		}
+	}
+	writeJSON(w, http.StatusOK, latest)
+}
+
+// getDrift serves the drift reports of a workspace, newest first.
+func (s *Server) getDrift(w http.ResponseWriter, name string) {
+	ws, err := s.workspaces.Get(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errors.New("workspace not found"))
+		return
+	}
+	reports, err := session.DriftReports(ws)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
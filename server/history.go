@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+	"github.com/niravparikh05/ginie-ai/session"
+)
+
+// SetAuditLog makes the sessions of the server record their runs in the
+// history, which is served from /history.
+func (s *Server) SetAuditLog(l *audit.Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditLog = l
+}
+
+// setRequestUser sets who a session is created for: the user named in the
+// request, the X-Ginie-User header or else the address of the client. The
+// server does not authenticate its clients, so a user they name is recorded
+// as unverified.
+func setRequestUser(sess *session.Session, r *http.Request, user string) {
+	if user == "" {
+		user = r.Header.Get("X-Ginie-User")
+	}
+	if user != "" {
+		sess.SetUnverifiedUser(user)
+		return
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		sess.SetUser(host)
+		return
+	}
+	sess.SetUser(r.RemoteAddr)
+}
+
+// listHistory serves the runs in the history, oldest first, filtered by the
+// query: workspace, action, user, session, resource, since, until and limit,
+// which keeps the latest runs only.
+func (s *Server) listHistory(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	auditLog := s.auditLog
+	s.mu.Unlock()
+
+	entries := []*audit.Entry{}
+	if auditLog == nil {
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Workspace: query.Get("workspace"),
+		Action:    query.Get("action"),
+		User:      query.Get("user"),
+		Session:   query.Get("session"),
+		Resource:  query.Get("resource"),
+	}
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = audit.ParseTime(since); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = audit.ParseTime(until); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	limit := 0
+	if l := query.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+			return
+		}
+	}
+
+	found, err := auditLog.Entries(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if limit > 0 && len(found) > limit {
+		found = found[len(found)-limit:]
+	}
+	writeJSON(w, http.StatusOK, append(entries, found...))
+}
@@ -0,0 +1,496 @@
+// Package server exposes Ginie sessions over an HTTP/JSON API.
+//
+//	POST   /sessions                         create a session, {"workspace": "...", "engine": "terraform|tofu", "stacks": [...], "user": "...", "budget": 100} optional
+//	GET    /sessions                         list sessions
+//	GET    /sessions/{id}                    get a session
+//	DELETE /sessions/{id}                    delete a session
+//	GET    /sessions/{id}/messages           conversation so far
+//	POST   /sessions/{id}/messages           send a prompt, {"content": "..."}
+//	POST   /sessions/{id}/generate           write the current program to the work dir
+//	GET    /sessions/{id}/files              list generated files
+//	GET    /sessions/{id}/files/{name}       fetch a generated file
+//	GET    /sessions/{id}/stacks             stacks the program is split into
+//	PUT    /sessions/{id}/stacks             set the stacks, [{"name": "...", "dependsOn": [...]}]
+//	GET    /sessions/{id}/runs               list runs
+//	POST   /sessions/{id}/runs               start a run, {"action": "plan|apply|...", "args": [...]}
+//	GET    /sessions/{id}/runs/{run}         run status, with the structured plan once planned
+//	GET    /sessions/{id}/runs/{run}/logs    run logs
+//	GET    /sessions/{id}/events             server-sent events of the session
+//	GET    /drift                            latest drift report of every workspace
+//	GET    /drift/{workspace}                drift reports of a workspace, newest first
+//	GET    /history                          runs of all sessions, filtered by ?workspace=&action=&user=&session=&resource=&since=&until=&limit=
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+	"github.com/niravparikh05/ginie-ai/backend"
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/llm"
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/session"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+type Server struct {
+	provider   llm.Provider
+	workspaces *workspace.Manager
+	backend    *backend.Local
+	logger     *slog.Logger
+
+	// runs outlive the requests that start them
+	ctx context.Context
+
+	mu       sync.Mutex
+	sessions map[string]*session.Session
+	auditLog *audit.Log
+	policy   *policy.Policy
+	// acceptWarnings applies plans despite violations of warn rules
+	acceptWarnings bool
+	pricing        *cost.Catalog
+}
+
+func New(ctx context.Context, provider llm.Provider, workspaces *workspace.Manager, stateBackend *backend.Local, logger *slog.Logger) *Server {
+	return &Server{
+		provider:   provider,
+		workspaces: workspaces,
+		backend:    stateBackend,
+		logger:     logger,
+		ctx:        ctx,
+		sessions:   make(map[string]*session.Session),
+	}
+}
+
+// SetPolicy makes the sessions of the server check their plans against the
+// policy. Violations of error rules block the apply, those of warn rules too
+// unless warnings are accepted.
+func (s *Server) SetPolicy(p *policy.Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// SetAcceptPolicyWarnings makes the sessions of the server apply plans with
+// violations of warn rules, unless a session is created with
+// acceptPolicyWarnings false. No one can be asked to confirm them.
+func (s *Server) SetAcceptPolicyWarnings(accept bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceptWarnings = accept
+}
+
+// SetPricing makes the sessions of the server estimate the cost of their
+// plans from the catalog, reported with the runs.
+func (s *Server) SetPricing(c *cost.Catalog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pricing = c
+}
+
+type sessionInfo struct {
+	ID                 string            `json:"id"`
+	Workspace          string            `json:"workspace"`
+	TerraformWorkspace string            `json:"terraformWorkspace"`
+	Engine             string            `json:"engine"`
+	Stacks             []terraform.Stack `json:"stacks,omitempty"`
+	User               string            `json:"user,omitempty"`
+	UserUnverified     bool              `json:"userUnverified,omitempty"`
+	Budget             float64           `json:"budget,omitempty"`
+	CreatedAt          time.Time         `json:"createdAt"`
+}
+
+type sessionRequest struct {
+	Workspace string `json:"workspace"`
+	// Engine switches the workspace to terraform or tofu
+	Engine string `json:"engine"`
+	// Stacks splits the program of the workspace into stacks
+	Stacks []terraform.Stack `json:"stacks"`
+	// User is who the runs of the session are recorded for, the
+	// X-Ginie-User header or the address of the client by default. The
+	// one named here or in the header is recorded as unverified
+	User string `json:"user"`
+	// Budget sets the monthly budget of the workspace, 0 for none
+	Budget *float64 `json:"budget"`
+	// AcceptPolicyWarnings applies plans despite violations of warn rules,
+	// the server's setting by default
+	AcceptPolicyWarnings *bool `json:"acceptPolicyWarnings"`
+}
+
+type messageRequest struct {
+	Content string `json:"content"`
+}
+
+type messageResponse struct {
+	Reply string `json:"reply"`
+}
+
+type runRequest struct {
+	Action string   `json:"action"`
+	Args   []string `json:"args,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// ServeHTTP routes /sessions/... requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "drift" && len(parts) <= 2 {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		if len(parts) == 1 {
+			s.listDrift(w)
+		} else {
+			s.getDrift(w, parts[1])
+		}
+		return
+	}
+	if parts[0] == "history" && len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		s.listHistory(w, r)
+		return
+	}
+	if parts[0] != "sessions" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.listSessions(w)
+		case http.MethodPost:
+			s.createSession(w, r)
+		default:
+			methodNotAllowed(w)
+		}
+		return
+	}
+
+	sess := s.getSession(parts[1])
+	if sess == nil {
+		writeError(w, http.StatusNotFound, errors.New("session not found"))
+		return
+	}
+
+	switch {
+	case len(parts) == 2:
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, toSessionInfo(sess))
+		case http.MethodDelete:
+			s.deleteSession(w, sess)
+		default:
+			methodNotAllowed(w)
+		}
+	case len(parts) == 3 && parts[2] == "messages":
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, sess.Messages())
+		case http.MethodPost:
+			s.postMessage(w, r, sess)
+		default:
+			methodNotAllowed(w)
+		}
+	case len(parts) == 3 && parts[2] == "events":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		s.streamEvents(w, r, sess)
+	case len(parts) == 3 && parts[2] == "generate":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w)
+			return
+		}
+		s.generate(w, r, sess)
+	case len(parts) == 3 && parts[2] == "files":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		s.listFiles(w, sess)
+	case len(parts) == 4 && parts[2] == "files":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		s.getFile(w, sess, parts[3])
+	case len(parts) == 3 && parts[2] == "stacks":
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, sess.Stacks())
+		case http.MethodPut:
+			s.setStacks(w, r, sess)
+		default:
+			methodNotAllowed(w)
+		}
+	case len(parts) == 3 && parts[2] == "runs":
+		switch r.Method {
+		case http.MethodGet:
+			s.listRuns(w, sess)
+		case http.MethodPost:
+			s.startRun(w, r, sess)
+		default:
+			methodNotAllowed(w)
+		}
+	case len(parts) >= 4 && len(parts) <= 5 && parts[2] == "runs":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		run := sess.GetRun(parts[3])
+		if run == nil {
+			writeError(w, http.StatusNotFound, errors.New("run not found"))
+			return
+		}
+		if len(parts) == 4 {
+			writeJSON(w, http.StatusOK, run.Info())
+			return
+		}
+		if parts[4] != "logs" {
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(run.Logs()))
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (s *Server) getSession(id string) *session.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+func (s *Server) listSessions(w http.ResponseWriter) {
+	s.mu.Lock()
+	sessions := make([]sessionInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, toSessionInfo(sess))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var req sessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// every session gets a workspace of its own unless it asks to join one
+	id := session.NewID()
+	if req.Workspace == "" {
+		req.Workspace = id
+	}
+	ws, err := s.workspaces.Open(req.Workspace)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sess := session.New(id, ws, s.provider)
+	if s.backend != nil {
+		sess.SetBackend(s.backend)
+	}
+	setRequestUser(sess, r, req.User)
+	if req.Engine != "" {
+		if err := sess.SetEngine(req.Engine); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if req.Stacks != nil {
+		if err := sess.SetStacks(req.Stacks); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if req.Budget != nil {
+		if err := sess.SetBudget(*req.Budget); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	if s.auditLog != nil {
+		sess.SetAuditLog(s.auditLog)
+	}
+	sess.SetPolicy(s.policy)
+	acceptWarnings := s.acceptWarnings
+	if req.AcceptPolicyWarnings != nil {
+		acceptWarnings = *req.AcceptPolicyWarnings
+	}
+	sess.SetAcceptPolicyWarnings(acceptWarnings)
+	sess.SetPricing(s.pricing)
+	s.sessions[sess.ID] = sess
+	s.mu.Unlock()
+
+	s.logger.Info("created session", "session", sess.ID, "workspace", ws.Name)
+	writeJSON(w, http.StatusCreated, toSessionInfo(sess))
+}
+
+func (s *Server) deleteSession(w http.ResponseWriter, sess *session.Session) {
+	s.mu.Lock()
+	delete(s.sessions, sess.ID)
+	s.mu.Unlock()
+
+	s.logger.Info("deleted session", "session", sess.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request, sess *session.Session) {
+	var req messageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Content == "" {
+		writeError(w, http.StatusBadRequest, errors.New("content is required"))
+		return
+	}
+
+	reply, err := sess.Send(r.Context(), req.Content)
+	if err != nil {
+		s.logger.Error("failed to call model", "session", sess.ID, "error", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, messageResponse{Reply: reply})
+}
+
+func (s *Server) generate(w http.ResponseWriter, r *http.Request, sess *session.Session) {
+	if err := sess.Generate(r.Context()); err != nil {
+		s.logger.Error("failed to generate program", "session", sess.ID, "error", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	s.listFiles(w, sess)
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, sess *session.Session) {
+	files, err := sess.Files()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if files == nil {
+		files = []string{}
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (s *Server) getFile(w http.ResponseWriter, sess *session.Session, name string) {
+	b, err := sess.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, errors.New("file not found"))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(b)
+}
+
+func (s *Server) setStacks(w http.ResponseWriter, r *http.Request, sess *session.Session) {
+	var stacks []terraform.Stack
+	if err := json.NewDecoder(r.Body).Decode(&stacks); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := sess.SetStacks(stacks); err != nil {
+		if errors.Is(err, session.ErrRunInProgress) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sess.Stacks())
+}
+
+func (s *Server) listRuns(w http.ResponseWriter, sess *session.Session) {
+	runs := []session.RunInfo{}
+	for _, run := range sess.Runs() {
+		runs = append(runs, run.Info())
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *Server) startRun(w http.ResponseWriter, r *http.Request, sess *session.Session) {
+	var req runRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("action is required"))
+		return
+	}
+
+	run, err := sess.Start(s.ctx, req.Action, req.Args...)
+	if err != nil {
+		if errors.Is(err, session.ErrRunInProgress) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.logger.Info("started run", "session", sess.ID, "run", run.ID, "action", run.Action)
+	writeJSON(w, http.StatusAccepted, run.Info())
+}
+
+func toSessionInfo(sess *session.Session) sessionInfo {
+	return sessionInfo{
+		ID:                 sess.ID,
+		Workspace:          sess.Workspace().Name,
+		TerraformWorkspace: sess.TerraformWorkspace(),
+		Engine:             sess.Engine(),
+		Stacks:             sess.Stacks(),
+		User:               sess.User(),
+		UserUnverified:     sess.UserUnverified(),
+		Budget:             sess.Budget(),
+		CreatedAt:          sess.CreatedAt,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+	"github.com/niravparikh05/ginie-ai/llm"
+	"github.com/niravparikh05/ginie-ai/session"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+const program = "Here it is:\n```hcl\nresource \"null_resource\" \"a\" {}\n```"
+
+// fakeProvider replies with reply, or fails with err. Its prompts are kept.
+type fakeProvider struct {
+	mu      sync.Mutex
+	reply   string
+	err     error
+	prompts []string
+}
+
+func (p *fakeProvider) Complete(_ context.Context, messages []llm.Message) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prompts = append(p.prompts, messages[len(messages)-1].Content)
+	return p.reply, p.err
+}
+
+// streamingProvider hands out its reply word by word.
+type streamingProvider struct {
+	fakeProvider
+}
+
+func (p *streamingProvider) Stream(ctx context.Context, messages []llm.Message, onToken func(string)) (string, error) {
+	reply, err := p.Complete(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	for _, token := range strings.SplitAfter(reply, " ") {
+		onToken(token)
+	}
+	return reply, nil
+}
+
+// fakeTerraform plans a single resource to create.
+const fakeTerraform = `#!/bin/sh
+case "$1" in
+  version)
+    echo '{"terraform_version":"1.6.0","platform":"linux_amd64","provider_selections":{},"terraform_outdated":false}';;
+  plan)
+    for arg in "$@"; do
+      case "$arg" in -out=*) touch "${arg#-out=}";; esac
+    done
+    exit 2;;
+  show)
+    echo '{"format_version":"1.2","terraform_version":"1.6.0","resource_changes":[{"address":"null_resource.a","mode":"managed","type":"null_resource","name":"a","provider_name":"registry.terraform.io/hashicorp/null","change":{"actions":["create"],"before":null,"after":{}}}]}';;
+esac
+`
+
+func newTestServer(t *testing.T, provider llm.Provider) (*Server, *httptest.Server) {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "terraform")
+	if err := os.WriteFile(binary, []byte(fakeTerraform), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(terraform.BinaryPathEnv, binary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := New(ctx, provider, workspace.NewManager(t.TempDir()), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+// do sends a request with the body as JSON, decoding the response into out
+// unless it is nil. It returns the status.
+func do(t *testing.T, method, url string, body, out interface{}) int {
+	t.Helper()
+	var r io.Reader
+	if s, ok := body.(string); ok {
+		r = strings.NewReader(s)
+	} else if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = strings.NewReader(string(b))
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %s", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func createSession(t *testing.T, srv *httptest.Server) sessionInfo {
+	t.Helper()
+	var info sessionInfo
+	if status := do(t, http.MethodPost, srv.URL+"/sessions", nil, &info); status != http.StatusCreated {
+		t.Fatalf("create session: got %d", status)
+	}
+	return info
+}
+
+func TestSessions(t *testing.T) {
+	_, srv := newTestServer(t, &fakeProvider{})
+
+	info := createSession(t, srv)
+	if info.ID == "" || info.Workspace != info.ID {
+		t.Errorf("got session %+v, want a workspace of its own", info)
+	}
+	var joined sessionInfo
+	if status := do(t, http.MethodPost, srv.URL+"/sessions", sessionRequest{Workspace: info.Workspace}, &joined); status != http.StatusCreated || joined.Workspace != info.Workspace {
+		t.Errorf("join workspace: got %d %+v", status, joined)
+	}
+
+	var sessions []sessionInfo
+	if status := do(t, http.MethodGet, srv.URL+"/sessions", nil, &sessions); status != http.StatusOK || len(sessions) != 2 {
+		t.Errorf("list sessions: got %d %+v", status, sessions)
+	}
+	var got sessionInfo
+	if status := do(t, http.MethodGet, srv.URL+"/sessions/"+info.ID, nil, &got); status != http.StatusOK || got.ID != info.ID {
+		t.Errorf("get session: got %d %+v", status, got)
+	}
+
+	if status := do(t, http.MethodDelete, srv.URL+"/sessions/"+info.ID, nil, nil); status != http.StatusNoContent {
+		t.Errorf("delete session: got %d", status)
+	}
+	if status := do(t, http.MethodGet, srv.URL+"/sessions/"+info.ID, nil, nil); status != http.StatusNotFound {
+		t.Errorf("get deleted session: got %d", status)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, srv := newTestServer(t, &fakeProvider{})
+	id := createSession(t, srv).ID
+
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+		status       int
+	}{
+		{http.MethodGet, "/unknown", nil, http.StatusNotFound},
+		{http.MethodGet, "/state/" + id, nil, http.StatusNotFound},
+		{http.MethodPut, "/sessions", nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/sessions", "{", http.StatusBadRequest},
+		{http.MethodPost, "/sessions", sessionRequest{Workspace: "../up"}, http.StatusBadRequest},
+		{http.MethodPost, "/sessions", sessionRequest{Engine: "pulumi"}, http.StatusBadRequest},
+		{http.MethodPost, "/sessions", map[string]float64{"budget": -1}, http.StatusBadRequest},
+		{http.MethodGet, "/sessions/unknown", nil, http.StatusNotFound},
+		{http.MethodPut, "/sessions/" + id, nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/sessions/" + id + "/messages", messageRequest{}, http.StatusBadRequest},
+		{http.MethodGet, "/sessions/" + id + "/files/missing.tf", nil, http.StatusNotFound},
+		{http.MethodGet, "/sessions/" + id + "/files/.ginie", nil, http.StatusBadRequest},
+		{http.MethodPost, "/sessions/" + id + "/files", nil, http.StatusMethodNotAllowed},
+		{http.MethodPut, "/sessions/" + id + "/stacks", "[", http.StatusBadRequest},
+		{http.MethodPost, "/sessions/" + id + "/runs", "{", http.StatusBadRequest},
+		{http.MethodPost, "/sessions/" + id + "/runs", runRequest{Action: "destroy-everything"}, http.StatusBadRequest},
+		{http.MethodGet, "/sessions/" + id + "/runs/unknown", nil, http.StatusNotFound},
+		{http.MethodPost, "/sessions/" + id + "/events", nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/drift", nil, http.StatusMethodNotAllowed},
+	} {
+		var resp errorResponse
+		status := do(t, tc.method, srv.URL+tc.path, tc.body, &resp)
+		if status != tc.status {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, status, tc.status)
+		}
+		if status >= 400 && resp.Error == "" {
+			t.Errorf("%s %s: no error message", tc.method, tc.path)
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	provider := &fakeProvider{reply: program}
+	_, srv := newTestServer(t, provider)
+	id := createSession(t, srv).ID
+
+	var reply messageResponse
+	if status := do(t, http.MethodPost, srv.URL+"/sessions/"+id+"/messages", messageRequest{Content: "a null resource"}, &reply); status != http.StatusOK || reply.Reply != program {
+		t.Fatalf("send message: got %d %+v", status, reply)
+	}
+	var messages []llm.Message
+	do(t, http.MethodGet, srv.URL+"/sessions/"+id+"/messages", nil, &messages)
+	if n := len(messages); n < 2 || messages[n-2].Content != "a null resource" || messages[n-1].Content != program {
+		t.Errorf("got messages %+v", messages)
+	}
+
+	provider.mu.Lock()
+	provider.err = errors.New("model unavailable")
+	provider.mu.Unlock()
+	var resp errorResponse
+	if status := do(t, http.MethodPost, srv.URL+"/sessions/"+id+"/messages", messageRequest{Content: "again"}, &resp); status != http.StatusBadGateway || !strings.Contains(resp.Error, "model unavailable") {
+		t.Errorf("failing model: got %d %+v", status, resp)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	_, srv := newTestServer(t, &fakeProvider{reply: program})
+	id := createSession(t, srv).ID
+
+	var files []string
+	if status := do(t, http.MethodPost, srv.URL+"/sessions/"+id+"/generate", nil, &files); status != http.StatusOK || len(files) != 1 {
+		t.Fatalf("generate: got %d %v", status, files)
+	}
+	resp, err := http.Get(srv.URL + "/sessions/" + id + "/files/" + files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(b) != `resource "null_resource" "a" {}` {
+		t.Errorf("get file: got %d %q", resp.StatusCode, b)
+	}
+}
+
+func TestGenerateWithoutProgram(t *testing.T) {
+	_, srv := newTestServer(t, &fakeProvider{reply: "I cannot help with that."})
+	id := createSession(t, srv).ID
+
+	if status := do(t, http.MethodPost, srv.URL+"/sessions/"+id+"/generate", nil, nil); status != http.StatusBadGateway {
+		t.Errorf("generate: got %d, want %d", status, http.StatusBadGateway)
+	}
+}
+
+func TestRun(t *testing.T) {
+	_, srv := newTestServer(t, &fakeProvider{reply: program})
+	id := createSession(t, srv).ID
+	do(t, http.MethodPost, srv.URL+"/sessions/"+id+"/generate", nil, nil)
+
+	run := runPlan(t, srv, id)
+	if run.Status != session.StatusSucceeded || run.Plan == nil || len(run.Plan.Changes()) != 1 {
+		t.Errorf("got run %+v", run)
+	}
+
+	var runs []session.RunInfo
+	if do(t, http.MethodGet, srv.URL+"/sessions/"+id+"/runs", nil, &runs); len(runs) != 1 {
+		t.Errorf("got runs %+v", runs)
+	}
+	resp, err := http.Get(srv.URL + "/sessions/" + id + "/runs/" + run.ID + "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("run logs: got %d", resp.StatusCode)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	provider := &streamingProvider{fakeProvider{reply: "a null resource it is"}}
+	_, srv := newTestServer(t, provider)
+	id := createSession(t, srv).ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/sessions/"+id+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("events: got %d %s", resp.StatusCode, ct)
+	}
+
+	// subscribed once the headers are sent
+	go func() {
+		resp, err := http.Post(srv.URL+"/sessions/"+id+"/messages", "application/json", strings.NewReader(`{"content": "something"}`))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	var tokens []string
+	scanner := bufio.NewScanner(resp.Body)
+	var name string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var e session.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Type != name || e.Session != id {
+				t.Errorf("event %s: got %+v", name, e)
+			}
+			switch e.Type {
+			case session.EventToken:
+				tokens = append(tokens, e.Token)
+			case session.EventMessage:
+				if got := strings.Join(tokens, ""); got != e.Message || e.Message != provider.reply || len(tokens) < 2 {
+					t.Errorf("got tokens %q and message %q", tokens, e.Message)
+				}
+				return
+			}
+		}
+	}
+	t.Fatalf("stream ended without the message: %v", scanner.Err())
+}
+
+// runPlan plans the program of the session and waits for the run to finish.
+func runPlan(t *testing.T, srv *httptest.Server, id string) session.RunInfo {
+	t.Helper()
+	var run session.RunInfo
+	if status := do(t, http.MethodPost, srv.URL+"/sessions/"+id+"/runs", runRequest{Action: session.ActionPlan}, &run); status != http.StatusAccepted {
+		t.Fatalf("start run: got %d", status)
+	}
+	for deadline := time.Now().Add(10 * time.Second); run.Status == session.StatusRunning; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("run did not finish")
+		}
+		do(t, http.MethodGet, srv.URL+"/sessions/"+id+"/runs/"+run.ID, nil, &run)
+	}
+	return run
+}
+
+func TestHistoryUnverifiedUser(t *testing.T) {
+	s, srv := newTestServer(t, &fakeProvider{reply: program})
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAuditLog(auditLog)
+
+	var named sessionInfo
+	do(t, http.MethodPost, srv.URL+"/sessions", sessionRequest{Workspace: "named", User: "alice"}, &named)
+	if named.User != "alice" || !named.UserUnverified {
+		t.Errorf("got session %+v, want an unverified alice", named)
+	}
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/sessions", strings.NewReader(`{"workspace":"header"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Ginie-User", "bob")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header sessionInfo
+	err = json.NewDecoder(resp.Body).Decode(&header)
+	resp.Body.Close()
+	if err != nil || header.User != "bob" || !header.UserUnverified {
+		t.Errorf("got session %+v, %v, want an unverified bob", header, err)
+	}
+	if client := createSession(t, srv); client.User != "127.0.0.1" || client.UserUnverified {
+		t.Errorf("got session %+v, want the client address", client)
+	}
+
+	do(t, http.MethodPost, srv.URL+"/sessions/"+named.ID+"/generate", nil, nil)
+	runPlan(t, srv, named.ID)
+	var entries []audit.Entry
+	do(t, http.MethodGet, srv.URL+"/history", nil, &entries)
+	if len(entries) != 1 || entries[0].User != "alice" || !entries[0].UserUnverified {
+		t.Errorf("got history %+v, want a run of an unverified alice", entries)
+	}
+}
+
+func TestHistoryWithoutAuditLog(t *testing.T) {
+	_, srv := newTestServer(t, &fakeProvider{})
+	var entries []json.RawMessage
+	if status := do(t, http.MethodGet, srv.URL+"/history", nil, &entries); status != http.StatusOK || len(entries) != 0 {
+		t.Errorf("got %d %s", status, fmt.Sprint(entries))
+	}
+}
+
+// TestWorkspaceLocked holds the run lock of a workspace, as a run of another
+// ginie process would. Runs of its sessions and drift detection wait.
+func TestWorkspaceLocked(t *testing.T) {
+	s, srv := newTestServer(t, &fakeProvider{reply: program})
+	info := createSession(t, srv)
+	do(t, http.MethodPost, srv.URL+"/sessions/"+info.ID+"/generate", nil, nil)
+
+	ws, err := s.workspaces.Get(info.Workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := ws.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	var resp errorResponse
+	if status := do(t, http.MethodPost, srv.URL+"/sessions/"+info.ID+"/runs", runRequest{Action: session.ActionPlan}, &resp); status != http.StatusConflict {
+		t.Errorf("run in locked workspace: got %d %+v, want %d", status, resp, http.StatusConflict)
+	}
+
+	s.detectDrift()
+	if reports, err := session.DriftReports(ws); err != nil || len(reports) != 0 {
+		t.Errorf("drift detected in locked workspace: %v, %v", reports, err)
+	}
+}
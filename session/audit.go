@@ -0,0 +1,202 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+	"github.com/niravparikh05/ginie-ai/backend"
+	"github.com/niravparikh05/ginie-ai/llm"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// SetAuditLog makes the session record every run in the history.
+func (s *Session) SetAuditLog(l *audit.Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditLog = l
+}
+
+// SetUser sets who the runs of the session are recorded for.
+func (s *Session) SetUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user, s.userUnverified = user, false
+}
+
+// SetUnverifiedUser sets who the runs of the session are recorded for, as
+// the client named itself. The history marks them unverified.
+func (s *Session) SetUnverifiedUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user, s.userUnverified = user, true
+}
+
+// User returns who the runs of the session are recorded for.
+func (s *Session) User() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.user
+}
+
+// UserUnverified reports whether the user was named by the client.
+func (s *Session) UserUnverified() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.userUnverified
+}
+
+// lastPrompt returns the last prompt of the conversation, leaving out the
+// ones Ginie sends on its own to generate the program.
+func (s *Session) lastPrompt() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the first messages set up the conversation
+	for i := len(s.messages) - 1; i >= 3; i-- {
+		m := s.messages[i]
+		if m.Role == llm.RoleUser && m.Content != generatePrompt && m.Content != stackGeneratePrompt {
+			return m.Content
+		}
+	}
+	return ""
+}
+
+// addApplied records a resource the run changed, as terraform reports it.
+func (r *Run) addApplied(stack string, e terraform.Event) {
+	if e.Type != terraform.EventApplyComplete || e.Resource == "" {
+		return
+	}
+	address := e.Resource
+	if stack != "" {
+		address = stack + ":" + address
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.applied = append(r.applied, audit.Change{Action: e.Operation, Address: address})
+}
+
+// audit records the finished run in the history, if the session keeps one.
+func (s *Session) audit(r *Run, ws *workspace.Workspace, tfWorkspace string) error {
+	s.mu.Lock()
+	auditLog, user, unverified := s.auditLog, s.user, s.userUnverified
+	s.mu.Unlock()
+	if auditLog == nil {
+		return nil
+	}
+
+	info := r.Info()
+	entry := &audit.Entry{
+		Run:                info.ID,
+		Time:               info.StartedAt,
+		User:               user,
+		UserUnverified:     unverified,
+		Session:            s.ID,
+		Workspace:          ws.Name,
+		TerraformWorkspace: tfWorkspace,
+		Action:             info.Action,
+		Args:               info.Args,
+		Prompt:             s.lastPrompt(),
+		Engine:             ws.Engine,
+		TerraformVersion:   ws.TerraformVersion,
+		Result:             info.Status,
+		Error:              info.Error,
+	}
+	if entry.Engine == "" {
+		entry.Engine = terraform.EngineTerraform
+	}
+	if info.FinishedAt != nil {
+		entry.Duration = info.FinishedAt.Sub(info.StartedAt).Milliseconds()
+	}
+
+	hash, err := programHash(ws)
+	if err != nil {
+		return err
+	}
+	entry.ProgramHash = hash
+
+	// what the run changed, or what it planned to change if it did not apply
+	r.mu.Lock()
+	entry.Changes = append(entry.Changes, r.applied...)
+	r.mu.Unlock()
+	applied := len(entry.Changes) > 0
+
+	var summaries []string
+	addPlan := func(stack string, plan *terraform.PlanResult) {
+		if plan == nil {
+			return
+		}
+		summary := fmt.Sprintf("%d to add, %d to change, %d to destroy", plan.Counts.Add, plan.Counts.Change, plan.Counts.Destroy)
+		if plan.Counts.Drift > 0 {
+			summary += fmt.Sprintf(", %d drifted", plan.Counts.Drift)
+		}
+		if stack != "" {
+			summary = stack + ": " + summary
+		}
+		summaries = append(summaries, summary)
+
+		if applied {
+			return
+		}
+		for _, change := range plan.Changes() {
+			address := change.Address
+			if stack != "" {
+				address = stack + ":" + address
+			}
+			entry.Changes = append(entry.Changes, audit.Change{Action: change.Action, Address: address})
+		}
+	}
+	addPlan("", info.Plan)
+	for _, stack := range info.Stacks {
+		addPlan(stack.Stack, stack.Plan)
+	}
+	entry.PlanSummary = strings.Join(summaries, "; ")
+
+	return auditLog.Append(entry)
+}
+
+// programHash returns the sha256 of the program of the workspace, all of its
+// .tf files but the backend configuration Ginie adds, those of stacks
+// included.
+func programHash(ws *workspace.Workspace) (string, error) {
+	dirs := map[string]string{"": ws.Dir}
+	for _, stack := range ws.Stacks {
+		dirs[stack.Name] = ws.StackDir(stack.Name)
+	}
+
+	var files []string
+	for prefix, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+		if err != nil {
+			return "", err
+		}
+		for _, match := range matches {
+			if filepath.Base(match) == backend.OverrideFile {
+				continue
+			}
+			files = append(files, filepath.Join(prefix, filepath.Base(match))+"\x00"+match)
+		}
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, file := range files {
+		name, path, _ := strings.Cut(file, "\x00")
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
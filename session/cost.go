@@ -0,0 +1,92 @@
+package session
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+// SetPricing makes the session estimate the monthly cost of every plan from
+// the catalog.
+func (s *Session) SetPricing(c *cost.Catalog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pricing = c
+}
+
+// Budget returns the monthly cost the resources of the session's workspace
+// may add up to, 0 for none.
+func (s *Session) Budget() float64 {
+	return s.Workspace().Budget
+}
+
+// SetBudget sets the monthly cost the resources of the session's workspace
+// may add up to, 0 for none. Applies estimated to exceed it are blocked.
+func (s *Session) SetBudget(budget float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		return ErrRunInProgress
+	}
+	return s.workspace.SetBudget(budget)
+}
+
+// checkCost estimates the cost of the plan of the run, or of a stack of it,
+// returning an error if applying it would exceed the budget of the
+// workspace. Changes that lower the cost are applied even over budget.
+func (s *Session) checkCost(r *Run, stack string, plan *terraform.PlanResult) error {
+	s.mu.Lock()
+	pricing := s.pricing
+	s.mu.Unlock()
+	// another session may have changed the budget since the run started
+	budget := s.Workspace().Budget
+
+	if r.Action == ActionDrift {
+		return nil
+	}
+	estimate := pricing.Estimate(stack, plan)
+	if estimate == nil {
+		return nil
+	}
+	// the stacks planned so far
+	total := r.addCost(estimate)
+
+	if r.Action != ActionApply || budget <= 0 || total.After <= budget || total.Delta <= 0 {
+		return nil
+	}
+	r.setBudgetExceeded()
+	return fmt.Errorf("the estimated cost of %.2f %s/month exceeds the budget of %.2f %s/month", total.After, total.Currency, budget, total.Currency)
+}
+
+// addCost adds the estimate of a plan of the run, returning the estimate of
+// all of its plans so far.
+func (r *Run) addCost(estimate *cost.Estimate) cost.Estimate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cost == nil {
+		r.cost = &cost.Estimate{}
+	}
+	r.cost.Add(estimate)
+	return *r.cost
+}
+
+func (r *Run) setBudgetExceeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.budgetExceeded = true
+}
+
+// costInfo copies the estimate of the run, stacks still add to it.
+func (r *Run) costInfo() *cost.Estimate {
+	if r.cost == nil {
+		return nil
+	}
+	c := *r.cost
+	c.Changes = slices.Clone(c.Changes)
+	c.Unpriced = slices.Clone(c.Unpriced)
+	return &c
+}
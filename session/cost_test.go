@@ -0,0 +1,97 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+func TestCheckCost(t *testing.T) {
+	pricing := &cost.Catalog{Currency: "USD", Prices: []cost.Price{{Resource: "aws_nat_gateway", Monthly: 30}}}
+	gateway := func(name, action string) terraform.ResourceChange {
+		change := terraform.ResourceChange{Address: "aws_nat_gateway." + name, Type: "aws_nat_gateway", Action: action}
+		if action != terraform.ActionCreate {
+			change.Before = map[string]interface{}{}
+		}
+		if action != terraform.ActionDelete {
+			change.After = map[string]interface{}{}
+		}
+		return change
+	}
+	plan := func(changes ...terraform.ResourceChange) *terraform.PlanResult {
+		p := &terraform.PlanResult{ResourceChanges: make(map[string][]terraform.ResourceChange)}
+		for _, change := range changes {
+			if change.Action == terraform.ActionNoop {
+				p.Unchanged = append(p.Unchanged, change)
+				continue
+			}
+			p.ResourceChanges[change.Action] = append(p.ResourceChanges[change.Action], change)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name   string
+		action string
+		budget float64
+		plans  []*terraform.PlanResult
+		// exceeded is whether the last plan is over budget
+		exceeded bool
+	}{
+		{"under budget", ActionApply, 50, []*terraform.PlanResult{plan(gateway("a", terraform.ActionCreate))}, false},
+		{"over budget", ActionApply, 50, []*terraform.PlanResult{plan(gateway("a", terraform.ActionCreate), gateway("b", terraform.ActionCreate))}, true},
+		{"no budget", ActionApply, 0, []*terraform.PlanResult{plan(gateway("a", terraform.ActionCreate), gateway("b", terraform.ActionCreate))}, false},
+		{"lower cost over budget", ActionApply, 50, []*terraform.PlanResult{plan(
+			gateway("a", terraform.ActionNoop), gateway("b", terraform.ActionNoop), gateway("c", terraform.ActionDelete),
+		)}, false},
+		{"same cost over budget", ActionApply, 50, []*terraform.PlanResult{plan(
+			gateway("a", terraform.ActionNoop), gateway("b", terraform.ActionNoop),
+		)}, false},
+		{"higher cost from over budget", ActionApply, 50, []*terraform.PlanResult{plan(
+			gateway("a", terraform.ActionNoop), gateway("b", terraform.ActionNoop), gateway("c", terraform.ActionCreate),
+		)}, true},
+		{"stacks over budget together", ActionApply, 50, []*terraform.PlanResult{
+			plan(gateway("a", terraform.ActionCreate)),
+			plan(gateway("b", terraform.ActionCreate)),
+		}, true},
+		{"plan over budget", ActionPlan, 50, []*terraform.PlanResult{plan(gateway("a", terraform.ActionCreate), gateway("b", terraform.ActionCreate))}, false},
+		{"drift", ActionDrift, 50, []*terraform.PlanResult{plan(gateway("a", terraform.ActionCreate), gateway("b", terraform.ActionCreate))}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, err := workspace.NewManager(t.TempDir()).Create("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess := New(NewID(), ws, nil)
+			sess.SetPricing(pricing)
+			if err := sess.SetBudget(tt.budget); err != nil {
+				t.Fatal(err)
+			}
+
+			r := &Run{Action: tt.action}
+			for i, p := range tt.plans {
+				err = sess.checkCost(r, "", p)
+				if i < len(tt.plans)-1 && err != nil {
+					t.Fatalf("plan %d: %s", i+1, err)
+				}
+			}
+			if exceeded := err != nil; exceeded != tt.exceeded {
+				t.Fatalf("got %v, want exceeded %t", err, tt.exceeded)
+			}
+			if tt.exceeded && !strings.Contains(err.Error(), "exceeds the budget of 50.00 USD/month") {
+				t.Errorf("got %q", err)
+			}
+			info := r.Info()
+			if info.BudgetExceeded != tt.exceeded {
+				t.Errorf("got budget exceeded %t", info.BudgetExceeded)
+			}
+			if (info.Cost == nil) != (tt.action == ActionDrift) {
+				t.Errorf("got cost %+v", info.Cost)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// driftReportRetention is the number of drift reports kept per workspace.
+const driftReportRetention = 100
+
+const driftPrompt = `This is the program currently deployed:
+%s
+These resources were changed outside of terraform since the program was last deployed:
+%s
+Propose how to resolve the drift: either update the program so that it accepts the changes, replying with the complete updated program, or revert them by applying the program as it is. Say which one you recommend and why.`
+
+const invalidProgramPrompt = `The program in your reply is not valid HCL:
+%s
+Reply again with the complete program, corrected.`
+
+// driftFixAttempts is how many replies with an invalid program the model
+// gets to correct.
+const driftFixAttempts = 2
+
+// DriftReport is the result of a drift detection run in a workspace.
+type DriftReport struct {
+	Workspace          string    `json:"workspace"`
+	TerraformWorkspace string    `json:"terraformWorkspace"`
+	Run                string    `json:"run"`
+	Time               time.Time `json:"time"`
+	Drifted            bool      `json:"drifted"`
+	// Drift holds the resources changed outside of terraform, those of
+	// stacks with their addresses prefixed by the stack, STACK:ADDRESS
+	Drift []terraform.ResourceChange `json:"drift,omitempty"`
+	Error string                     `json:"error,omitempty"`
+}
+
+// Summary renders the report as a short listing of the drifted resources.
+func (d *DriftReport) Summary() string {
+	if d.Error != "" {
+		return fmt.Sprintf("drift detection failed: %s", d.Error)
+	}
+	if !d.Drifted {
+		return "No drift."
+	}
+
+	var sb strings.Builder
+	for _, change := range d.Drift {
+		fmt.Fprintf(&sb, "  ! %s (%s outside of terraform)\n", change.Address, driftVerb(change.Action))
+	}
+	fmt.Fprintf(&sb, "Drift: %d resources changed outside of terraform.", len(d.Drift))
+	return sb.String()
+}
+
+func driftVerb(action string) string {
+	switch action {
+	case terraform.ActionDelete:
+		return "deleted"
+	case terraform.ActionCreate:
+		return "created"
+	default:
+		return "changed"
+	}
+}
+
+// newDriftReport builds the report of a finished drift run.
+func newDriftReport(ws *workspace.Workspace, tfWorkspace string, r *Run) *DriftReport {
+	info := r.Info()
+	report := &DriftReport{
+		Workspace:          ws.Name,
+		TerraformWorkspace: tfWorkspace,
+		Run:                info.ID,
+		Time:               info.StartedAt,
+		Error:              info.Error,
+	}
+
+	addPlan := func(stack string, plan *terraform.PlanResult) {
+		if plan == nil {
+			return
+		}
+		report.Drifted = report.Drifted || plan.HasChanges || len(plan.Drift) > 0
+		for _, change := range plan.Drift {
+			if stack != "" {
+				change.Address = stack + ":" + change.Address
+			}
+			report.Drift = append(report.Drift, change)
+		}
+	}
+	addPlan("", info.Plan)
+	for _, stack := range info.Stacks {
+		addPlan(stack.Stack, stack.Plan)
+	}
+	return report
+}
+
+// saveDriftReport keeps the report in the workspace, dropping the oldest
+// reports beyond the retention.
+func saveDriftReport(ws *workspace.Workspace, report *DriftReport) error {
+	dir := ws.DriftDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := report.Time.UTC().Format("20060102T150405") + "-" + report.Run + ".json"
+	if err := os.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+		return err
+	}
+
+	files, err := driftReportFiles(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > driftReportRetention {
+		if err := os.Remove(files[len(files)-1]); err != nil {
+			return err
+		}
+		files = files[:len(files)-1]
+	}
+	return nil
+}
+
+// driftReportFiles lists the reports in dir, newest first.
+func driftReportFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	// the names start with the time of the run
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files, nil
+}
+
+// DriftReports returns the drift reports of the workspace, newest first.
+func DriftReports(ws *workspace.Workspace) ([]*DriftReport, error) {
+	files, err := driftReportFiles(ws.DriftDir())
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*DriftReport, 0, len(files))
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var report DriftReport
+		if err := json.Unmarshal(b, &report); err != nil {
+			return nil, fmt.Errorf("invalid drift report %s: %s", filepath.Base(file), err)
+		}
+		reports = append(reports, &report)
+	}
+	return reports, nil
+}
+
+// DetectDrift runs a refresh-only plan and returns the report of the drift
+// it found, which is kept in the workspace as well.
+func (s *Session) DetectDrift(ctx context.Context, out io.Writer) (*DriftReport, error) {
+	r, err := s.Run(ctx, ActionDrift, out)
+	if r == nil {
+		return nil, err
+	}
+	return newDriftReport(s.Workspace(), s.TerraformWorkspace(), r), err
+}
+
+// ProposeDriftFix asks the model how to resolve the drift of the report,
+// accepting it into the program or reverting it. Its reply is part of the
+// conversation, a program it proposes is deployed by the next apply. A
+// program that is not valid HCL is sent back to the model to correct.
+func (s *Session) ProposeDriftFix(ctx context.Context, report *DriftReport) (string, error) {
+	if !report.Drifted {
+		return "", fmt.Errorf("no drift to resolve")
+	}
+
+	program, err := currentProgram(s.Workspace())
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, change := range report.Drift {
+		fmt.Fprintf(&sb, "- %s was %s", change.Address, driftVerb(change.Action))
+		if diff := changedAttributes(change.Before, change.After); len(diff) > 0 {
+			b, _ := json.Marshal(diff)
+			fmt.Fprintf(&sb, ", attributes as [before, after]: %s", b)
+		}
+		sb.WriteString("\n")
+	}
+
+	reply, err := s.Send(ctx, fmt.Sprintf(driftPrompt, program, sb.String()))
+	for attempt := 0; err == nil; attempt++ {
+		invalid := validateProgram(reply)
+		if invalid == nil {
+			return reply, nil
+		}
+		if attempt == driftFixAttempts {
+			return "", fmt.Errorf("the proposed program is not valid HCL: %s", invalid)
+		}
+		reply, err = s.Send(ctx, fmt.Sprintf(invalidProgramPrompt, invalid))
+	}
+	return "", err
+}
+
+// currentProgram renders the program of the workspace as code blocks, one
+// per file, those of stacks marked as when the model generates them.
+func currentProgram(ws *workspace.Workspace) (string, error) {
+	dirs := []string{ws.Dir}
+	for _, stack := range ws.Stacks {
+		dirs = append(dirs, ws.StackDir(stack.Name))
+	}
+
+	var sb strings.Builder
+	for i, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			// written for the run, not part of the program
+			if strings.HasSuffix(file, "_override.tf") {
+				continue
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("```hcl\n")
+			if i > 0 {
+				fmt.Fprintf(&sb, "%s %s\n", stackMarker, ws.Stacks[i-1].Name)
+			}
+			fmt.Fprintf(&sb, "# %s\n%s\n```\n", filepath.Base(file), strings.TrimSpace(string(b)))
+		}
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("no program deployed in workspace %s", ws.Name)
+	}
+	return sb.String(), nil
+}
+
+// validateProgram parses the terraform code blocks of a reply, returning the
+// syntax errors of the first invalid one.
+func validateProgram(reply string) error {
+	blocks := strings.Split(reply, "```")
+	for i := 1; i < len(blocks); i += 2 {
+		language, code, _ := strings.Cut(blocks[i], "\n")
+		switch strings.ToLower(strings.TrimSpace(language)) {
+		case "", "hcl", "terraform", "tf":
+		default:
+			// e.g. the commands to run
+			continue
+		}
+		if _, diags := hclsyntax.ParseConfig([]byte(code), fmt.Sprintf("block%d.tf", i/2+1), hcl.InitialPos); diags.HasErrors() {
+			return diags
+		}
+	}
+	return nil
+}
+
+// changedAttributes returns the top level attributes that differ between the
+// two values of a resource.
+func changedAttributes(before, after interface{}) map[string][2]interface{} {
+	b, _ := before.(map[string]interface{})
+	a, _ := after.(map[string]interface{})
+
+	diff := make(map[string][2]interface{})
+	for key, value := range b {
+		if !reflect.DeepEqual(value, a[key]) {
+			diff[key] = [2]interface{}{value, a[key]}
+		}
+	}
+	for key, value := range a {
+		if _, ok := b[key]; !ok && value != nil {
+			diff[key] = [2]interface{}{nil, value}
+		}
+	}
+	return diff
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/llm"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// scriptedProvider replies with its replies in turn, the last one over and
+// over. Its prompts are kept.
+type scriptedProvider struct {
+	mu      sync.Mutex
+	replies []string
+	prompts []string
+}
+
+func (p *scriptedProvider) Complete(_ context.Context, messages []llm.Message) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prompts = append(p.prompts, messages[len(messages)-1].Content)
+	reply := p.replies[0]
+	if len(p.replies) > 1 {
+		p.replies = p.replies[1:]
+	}
+	return reply, nil
+}
+
+const deployedProgram = `resource "aws_s3_bucket" "logs" {
+  bucket = "logs"
+}`
+
+func newDriftSession(t *testing.T, replies ...string) (*Session, *scriptedProvider) {
+	t.Helper()
+	ws, err := workspace.NewManager(t.TempDir()).Create("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws.Dir, programFile), []byte(deployedProgram), 0644); err != nil {
+		t.Fatal(err)
+	}
+	provider := &scriptedProvider{replies: replies}
+	return New(NewID(), ws, provider), provider
+}
+
+var driftedBucket = &DriftReport{
+	Drifted: true,
+	Drift: []terraform.ResourceChange{{
+		Address: "aws_s3_bucket.logs",
+		Type:    "aws_s3_bucket",
+		Action:  terraform.ActionUpdate,
+		Before:  map[string]interface{}{"tags": nil},
+		After:   map[string]interface{}{"tags": map[string]interface{}{"team": "web"}},
+	}},
+}
+
+func TestProposeDriftFix(t *testing.T) {
+	fix := "Accept it:\n```hcl\nresource \"aws_s3_bucket\" \"logs\" {\n  bucket = \"logs\"\n  tags = { team = \"web\" }\n}\n```"
+	sess, provider := newDriftSession(t, fix)
+
+	reply, err := sess.ProposeDriftFix(context.Background(), driftedBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != fix {
+		t.Errorf("got reply %q", reply)
+	}
+	if len(provider.prompts) != 1 {
+		t.Fatalf("got %d prompts, want 1", len(provider.prompts))
+	}
+	prompt := provider.prompts[0]
+	if !strings.Contains(prompt, deployedProgram) || !strings.Contains(prompt, "# main.tf") {
+		t.Errorf("prompt misses the deployed program: %s", prompt)
+	}
+	if !strings.Contains(prompt, "aws_s3_bucket.logs was changed") {
+		t.Errorf("prompt misses the drift: %s", prompt)
+	}
+}
+
+func TestProposeDriftFixRevert(t *testing.T) {
+	sess, _ := newDriftSession(t, "Revert it by applying the program as it is.")
+	if _, err := sess.ProposeDriftFix(context.Background(), driftedBucket); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProposeDriftFixInvalidProgram(t *testing.T) {
+	invalid := "```hcl\nresource \"aws_s3_bucket\" \"logs\" {\n  bucket = \n```"
+	valid := "```hcl\nresource \"aws_s3_bucket\" \"logs\" {\n  bucket = \"logs\"\n}\n```"
+	sess, provider := newDriftSession(t, invalid, valid)
+
+	reply, err := sess.ProposeDriftFix(context.Background(), driftedBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != valid {
+		t.Errorf("got reply %q, want the corrected one", reply)
+	}
+	if len(provider.prompts) != 2 || !strings.Contains(provider.prompts[1], "not valid HCL") {
+		t.Errorf("invalid program not sent back: %q", provider.prompts)
+	}
+}
+
+func TestProposeDriftFixNeverValid(t *testing.T) {
+	sess, provider := newDriftSession(t, "```hcl\nresource {\n```")
+
+	if _, err := sess.ProposeDriftFix(context.Background(), driftedBucket); err == nil || !strings.Contains(err.Error(), "not valid HCL") {
+		t.Errorf("got %v, want an invalid HCL error", err)
+	}
+	if len(provider.prompts) != driftFixAttempts+1 {
+		t.Errorf("got %d prompts, want %d", len(provider.prompts), driftFixAttempts+1)
+	}
+}
+
+func TestValidateProgramSkipsOtherLanguages(t *testing.T) {
+	reply := "Run:\n```sh\nterraform apply -auto-approve {\n```\nwith\n```\nvariable \"x\" {}\n```"
+	if err := validateProgram(reply); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+const (
+	EventToken       = "token"
+	EventMessage     = "message"
+	EventRunStarted  = "run_started"
+	EventRunFinished = "run_finished"
+	EventTerraform   = "terraform"
+	EventPlan        = "plan"
+)
+
+// subscriberBuffer is the number of events a subscriber may lag behind before
+// events are dropped for it.
+const subscriberBuffer = 256
+
+// Event is published to the subscribers of a session as the conversation and
+// its runs progress.
+type Event struct {
+	Type      string           `json:"type"`
+	Session   string           `json:"session"`
+	Time      time.Time        `json:"time"`
+	Token     string           `json:"token,omitempty"`
+	Message   string           `json:"message,omitempty"`
+	Run       *RunInfo         `json:"run,omitempty"`
+	Stack     string           `json:"stack,omitempty"`
+	Terraform *terraform.Event `json:"terraform,omitempty"`
+}
+
+// Subscribe returns a channel receiving the events of the session and a
+// function to stop receiving them. Slow subscribers miss events rather than
+// blocking the session.
+func (s *Session) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (s *Session) publish(e Event) {
+	e.Session = s.ID
+	e.Time = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
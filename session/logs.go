@@ -0,0 +1,84 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+// LoggedRun is a run whose logs are kept in the workspace, possibly of an
+// earlier session.
+type LoggedRun struct {
+	ID   string
+	Dir  string
+	Time time.Time
+}
+
+// LoggedRuns returns the runs with logs in the session's workspace, newest
+// first.
+func (s *Session) LoggedRuns() ([]LoggedRun, error) {
+	logDir := s.Workspace().LogDir()
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var runs []LoggedRun
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		runs = append(runs, LoggedRun{ID: e.Name(), Dir: filepath.Join(logDir, e.Name()), Time: info.ModTime()})
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Time.After(runs[j].Time)
+	})
+	return runs, nil
+}
+
+// RunLogFiles returns the log files of a run, the latest one if id is empty:
+// its output, ginie's structured log and terraform's own log.
+func (s *Session) RunLogFiles(id string) ([]string, error) {
+	runs, err := s.LoggedRuns()
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no logs in workspace %s", s.Workspace().Name)
+	}
+
+	dir := runs[0].Dir
+	if id != "" {
+		if id != filepath.Base(id) {
+			return nil, fmt.Errorf("invalid run: %s", id)
+		}
+		dir = filepath.Join(s.Workspace().LogDir(), id)
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("no logs for run %s", id)
+		}
+	}
+
+	var files []string
+	for _, name := range []string{terraform.OutputLogFile, terraform.GinieLogFile, terraform.TerraformLogFile} {
+		if path := filepath.Join(dir, name); fileExists(path) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// newLogsSession returns a session whose workspace has the logs of runs,
+// an hour apart, the last one the latest.
+func newLogsSession(t *testing.T, runs map[string][]string, order ...string) *Session {
+	t.Helper()
+	ws, err := workspace.NewManager(t.TempDir()).Create("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, id := range order {
+		dir := filepath.Join(ws.LogDir(), id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range runs[id] {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(id), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		modTime := now.Add(-time.Duration(len(order)-i) * time.Hour)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(NewID(), ws, nil)
+}
+
+func TestLoggedRuns(t *testing.T) {
+	sess := newLogsSession(t, nil)
+	if runs, err := sess.LoggedRuns(); err != nil || len(runs) != 0 {
+		t.Fatalf("got %v, %v without logs", runs, err)
+	}
+
+	sess = newLogsSession(t, nil, "b", "c", "a")
+	if err := os.WriteFile(filepath.Join(sess.Workspace().LogDir(), "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := sess.LoggedRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range runs {
+		ids = append(ids, r.ID)
+		if r.Dir != filepath.Join(sess.Workspace().LogDir(), r.ID) {
+			t.Errorf("got dir %s for %s", r.Dir, r.ID)
+		}
+	}
+	if want := []string{"a", "c", "b"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want the latest first %v", ids, want)
+	}
+}
+
+func TestRunLogFiles(t *testing.T) {
+	all := []string{terraform.OutputLogFile, terraform.GinieLogFile, terraform.TerraformLogFile}
+	sess := newLogsSession(t, map[string][]string{
+		"first":  all,
+		"latest": {terraform.GinieLogFile, terraform.OutputLogFile, "other.log"},
+	}, "first", "latest")
+
+	tests := []struct {
+		id   string
+		want []string
+		err  string
+	}{
+		{id: "", want: []string{"latest/" + terraform.OutputLogFile, "latest/" + terraform.GinieLogFile}},
+		{id: "first", want: []string{"first/" + terraform.OutputLogFile, "first/" + terraform.GinieLogFile, "first/" + terraform.TerraformLogFile}},
+		{id: "missing", err: "no logs for run missing"},
+		{id: "../first", err: "invalid run: ../first"},
+	}
+	for _, tt := range tests {
+		files, err := sess.RunLogFiles(tt.id)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got %v, want %s", tt.id, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, file := range files {
+			got = append(got, strings.TrimPrefix(file, sess.Workspace().LogDir()+"/"))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.id, got, tt.want)
+		}
+	}
+
+	if _, err := newLogsSession(t, nil).RunLogFiles(""); err == nil || err.Error() != "no logs in workspace web" {
+		t.Errorf("got %v without logs", err)
+	}
+}
@@ -0,0 +1,145 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+const policyPrompt = `The program violates these policies:
+%s
+Fix the program so that it meets them and reply with the complete updated program.`
+
+// PolicyPrompt asks whether to apply a plan despite the warnings of the
+// policy.
+type PolicyPrompt func(warnings []policy.Violation) bool
+
+// SetPolicy makes the session check every plan against the policy. Applies
+// with violations of error rules are blocked.
+func (s *Session) SetPolicy(p *policy.Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// SetPolicyPrompt sets who confirms applies with violations of warn rules.
+// Without a prompt they are blocked, unless warnings are accepted.
+func (s *Session) SetPolicyPrompt(prompt PolicyPrompt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policyPrompt = prompt
+}
+
+// SetAcceptPolicyWarnings makes the session apply plans with violations of
+// warn rules without a prompt to confirm them. The warnings are reported
+// with the run.
+func (s *Session) SetAcceptPolicyWarnings(accept bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceptWarnings = accept
+}
+
+// checkPolicy checks the plan of the run, or of a stack of it, against the
+// policy, returning a *policy.Error if it may not be applied.
+func (s *Session) checkPolicy(r *Run, stack string, plan *terraform.PlanResult) error {
+	s.mu.Lock()
+	p, prompt, accept := s.policy, s.policyPrompt, s.acceptWarnings
+	s.mu.Unlock()
+
+	violations := p.Check(stack, plan)
+	if len(violations) == 0 {
+		return nil
+	}
+	r.addViolations(violations)
+	if r.Action != ActionApply {
+		return nil
+	}
+
+	var errs, warnings []policy.Violation
+	for _, v := range violations {
+		if v.Severity == policy.SeverityError {
+			errs = append(errs, v)
+		} else {
+			warnings = append(warnings, v)
+		}
+	}
+	if len(errs) > 0 {
+		r.setPolicyBlocked()
+		return &policy.Error{Violations: errs}
+	}
+	if prompt == nil {
+		if accept {
+			return nil
+		}
+		r.setPolicyBlocked()
+		return &policy.Error{Violations: warnings, Unconfirmed: true}
+	}
+
+	// stacks plan side by side, one question at a time
+	s.prompting.Lock()
+	defer s.prompting.Unlock()
+	// the plans of an apply across stacks are checked twice
+	if r.confirmed(warnings) {
+		return nil
+	}
+	if !prompt(warnings) {
+		r.setPolicyBlocked()
+		return &policy.Error{Violations: warnings}
+	}
+	r.confirm(warnings)
+	return nil
+}
+
+// confirmed reports whether the warnings were all confirmed earlier in the
+// run.
+func (r *Run) confirmed(warnings []policy.Violation) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range warnings {
+		if !r.confirmedWarnings[v] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Run) confirm(warnings []policy.Violation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.confirmedWarnings == nil {
+		r.confirmedWarnings = make(map[policy.Violation]bool)
+	}
+	for _, v := range warnings {
+		r.confirmedWarnings[v] = true
+	}
+}
+
+func (r *Run) addViolations(violations []policy.Violation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.violations = append(r.violations, violations...)
+}
+
+func (r *Run) setPolicyBlocked() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policyBlocked = true
+}
+
+// FixPolicyViolations tells the model which policies the program violates,
+// asking for a program that meets them. Its reply is part of the
+// conversation, the next apply deploys the fixed program.
+func (s *Session) FixPolicyViolations(ctx context.Context, violations []policy.Violation) (string, error) {
+	if len(violations) == 0 {
+		return "", fmt.Errorf("no policy violations to fix")
+	}
+
+	var sb strings.Builder
+	for _, v := range violations {
+		fmt.Fprintf(&sb, "- %s: %s (%s, rule %s)\n", v.Address, v.Message, v.Severity, v.Rule)
+	}
+	return s.Send(ctx, fmt.Sprintf(policyPrompt, sb.String()))
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+func TestCheckPolicyWarnings(t *testing.T) {
+	exists := true
+	rules := &policy.Policy{Rules: []policy.Rule{{
+		Name:       "owner-tag",
+		Severity:   policy.SeverityWarn,
+		Conditions: []policy.Condition{{Attribute: "tags.owner", Exists: &exists}},
+	}}}
+	plan := &terraform.PlanResult{ResourceChanges: map[string][]terraform.ResourceChange{
+		terraform.ActionCreate: {{Address: "aws_instance.a", Type: "aws_instance", Action: terraform.ActionCreate, After: map[string]interface{}{}}},
+	}}
+
+	tests := []struct {
+		name    string
+		prompt  PolicyPrompt
+		accept  bool
+		blocked bool
+	}{
+		{"no prompt", nil, false, true},
+		{"accepted", nil, true, false},
+		{"confirmed", func([]policy.Violation) bool { return true }, false, false},
+		{"declined", func([]policy.Violation) bool { return false }, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, err := workspace.NewManager(t.TempDir()).Create("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess := New(NewID(), ws, nil)
+			sess.SetPolicy(rules)
+			sess.SetPolicyPrompt(tt.prompt)
+			sess.SetAcceptPolicyWarnings(tt.accept)
+
+			r := &Run{Action: ActionApply}
+			err = sess.checkPolicy(r, "", plan)
+			var policyErr *policy.Error
+			if blocked := errors.As(err, &policyErr); blocked != tt.blocked {
+				t.Fatalf("got %v, want blocked %t", err, tt.blocked)
+			}
+			if r.Info().PolicyBlocked != tt.blocked || len(r.Info().Violations) != 1 {
+				t.Errorf("got run %+v", r.Info())
+			}
+			if tt.blocked && policyErr.Unconfirmed != (tt.prompt == nil) {
+				t.Errorf("got unconfirmed %t", policyErr.Unconfirmed)
+			}
+		})
+	}
+}
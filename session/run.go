@@ -0,0 +1,523 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+const (
+	ActionPlan            = "plan"
+	ActionApply           = "apply"
+	ActionDestroy         = "destroy"
+	ActionValidate        = "validate"
+	ActionFmt             = "fmt"
+	ActionRefresh         = "refresh"
+	ActionImport          = "import"
+	ActionStateList       = "state-list"
+	ActionStateShow       = "state-show"
+	ActionStateRm         = "state-rm"
+	ActionStateMv         = "state-mv"
+	ActionTaint           = "taint"
+	ActionUntaint         = "untaint"
+	ActionGraph           = "graph"
+	ActionProvidersSchema = "providers-schema"
+	ActionProvidersLock   = "providers-lock"
+	ActionDrift           = "drift"
+)
+
+const localStateFile = "terraform.tfstate"
+
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// runAction is what a session action runs: the terraform actions and how
+// the action's arguments configure them.
+type runAction struct {
+	actions   []string
+	args      string
+	configure func(config *terraform.DriverConfig, args []string)
+	// nargs is the number of arguments, -1 for any
+	nargs int
+}
+
+var runActions = map[string]runAction{
+	ActionPlan:     {actions: []string{terraform.Init, terraform.Plan}},
+	ActionApply:    {actions: []string{terraform.Init, terraform.Plan, terraform.Apply}},
+	ActionDestroy:  {actions: []string{terraform.Init, terraform.Destroy}},
+	ActionValidate: {actions: []string{terraform.Init, terraform.Validate}},
+	ActionFmt:      {actions: []string{terraform.Fmt}},
+	ActionRefresh:  {actions: []string{terraform.Init, terraform.Refresh}},
+	ActionImport: {
+		actions: []string{terraform.Init, terraform.Import},
+		args:    "ADDRESS ID",
+		nargs:   2,
+		configure: func(config *terraform.DriverConfig, args []string) {
+			config.Address, config.ImportID = args[0], args[1]
+		},
+	},
+	ActionStateList: {actions: []string{terraform.Init, terraform.StateList}},
+	ActionStateShow: {
+		actions:   []string{terraform.Init, terraform.StateShow},
+		args:      "ADDRESS",
+		nargs:     1,
+		configure: setAddress,
+	},
+	ActionStateRm: {
+		actions:   []string{terraform.Init, terraform.StateRm},
+		args:      "ADDRESS",
+		nargs:     1,
+		configure: setAddress,
+	},
+	ActionStateMv: {
+		actions: []string{terraform.Init, terraform.StateMv},
+		args:    "SOURCE DESTINATION",
+		nargs:   2,
+		configure: func(config *terraform.DriverConfig, args []string) {
+			config.Address, config.Destination = args[0], args[1]
+		},
+	},
+	ActionTaint: {
+		actions:   []string{terraform.Init, terraform.Taint},
+		args:      "ADDRESS",
+		nargs:     1,
+		configure: setAddress,
+	},
+	ActionUntaint: {
+		actions:   []string{terraform.Init, terraform.Untaint},
+		args:      "ADDRESS",
+		nargs:     1,
+		configure: setAddress,
+	},
+	ActionDrift: {
+		actions: []string{terraform.Init, terraform.Plan},
+		configure: func(config *terraform.DriverConfig, args []string) {
+			config.RefreshOnly = true
+		},
+	},
+	ActionGraph:           {actions: []string{terraform.Init, terraform.Graph}},
+	ActionProvidersSchema: {actions: []string{terraform.Init, terraform.ProvidersSchema}},
+	ActionProvidersLock: {
+		actions: []string{terraform.Init, terraform.ProvidersLock},
+		args:    "[PLATFORM...]",
+		nargs:   -1,
+		configure: func(config *terraform.DriverConfig, args []string) {
+			config.Platform = args
+		},
+	},
+}
+
+func setAddress(config *terraform.DriverConfig, args []string) {
+	config.Address = args[0]
+}
+
+// Actions returns the names of the actions a session can run.
+func Actions() []string {
+	actions := make([]string, 0, len(runActions))
+	for action := range runActions {
+		actions = append(actions, action)
+	}
+	slices.Sort(actions)
+	return actions
+}
+
+// ActionUsage returns the arguments the action takes, e.g. "ADDRESS ID".
+func ActionUsage(action string) string {
+	return runActions[action].args
+}
+
+// ErrRunInProgress is returned when a run is started while another one of the
+// same session, or of another session or process working on the same
+// workspace, has not finished yet.
+var ErrRunInProgress = fmt.Errorf("a run is already in progress")
+
+// lockWorkspace locks the workspace for a run until the returned func is
+// called. A run of another session or process in it makes it fail with
+// ErrRunInProgress.
+func lockWorkspace(ws *workspace.Workspace) (func(), error) {
+	unlock, err := ws.Lock()
+	if errors.Is(err, workspace.ErrLocked) {
+		return nil, fmt.Errorf("%w in workspace %s", ErrRunInProgress, ws.Name)
+	}
+	return unlock, err
+}
+
+// Run is a single plan, apply, destroy or other terraform action on the
+// session's program.
+type Run struct {
+	ID     string
+	Action string
+	Args   []string
+
+	// unlock releases the lock of the workspace once the run finished
+	unlock func()
+
+	mu         sync.Mutex
+	status     string
+	err        string
+	startedAt  time.Time
+	finishedAt time.Time
+	plan       *terraform.PlanResult
+	stacks     []StackRun
+	// applied holds the resources the run changed
+	applied []audit.Change
+	// violations holds the policy violations of its plans
+	violations    []policy.Violation
+	policyBlocked bool
+	// confirmedWarnings holds the warnings confirmed to apply anyway
+	confirmedWarnings map[policy.Violation]bool
+	// cost is the estimate of its plans
+	cost           *cost.Estimate
+	budgetExceeded bool
+	logs           bytes.Buffer
+}
+
+// RunInfo is a point in time view of a Run.
+type RunInfo struct {
+	ID         string                `json:"id"`
+	Action     string                `json:"action"`
+	Args       []string              `json:"args,omitempty"`
+	Status     string                `json:"status"`
+	Error      string                `json:"error,omitempty"`
+	StartedAt  time.Time             `json:"startedAt"`
+	FinishedAt *time.Time            `json:"finishedAt,omitempty"`
+	Plan       *terraform.PlanResult `json:"plan,omitempty"`
+	Stacks     []StackRun            `json:"stacks,omitempty"`
+	Violations []policy.Violation    `json:"violations,omitempty"`
+	// PolicyBlocked is set if the policy kept the plan from being applied
+	PolicyBlocked bool           `json:"policyBlocked,omitempty"`
+	Cost          *cost.Estimate `json:"cost,omitempty"`
+	// BudgetExceeded is set if the budget kept the plan from being applied
+	BudgetExceeded bool `json:"budgetExceeded,omitempty"`
+}
+
+func (r *Run) Info() RunInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info := RunInfo{
+		ID:        r.ID,
+		Action:    r.Action,
+		Args:      r.Args,
+		Status:    r.status,
+		Error:     r.err,
+		StartedAt: r.startedAt,
+		Plan:      r.plan,
+		Stacks:    slices.Clone(r.stacks),
+
+		Violations:    slices.Clone(r.violations),
+		PolicyBlocked: r.policyBlocked,
+
+		Cost:           r.costInfo(),
+		BudgetExceeded: r.budgetExceeded,
+	}
+	if !r.finishedAt.IsZero() {
+		finishedAt := r.finishedAt
+		info.FinishedAt = &finishedAt
+	}
+	return info
+}
+
+// Plan returns the structured plan of the run, nil until it was planned.
+func (r *Run) Plan() *terraform.PlanResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.plan
+}
+
+func (r *Run) setPlan(plan *terraform.PlanResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.plan = plan
+}
+
+// setStack records the progress of a stack of the run, keeping its plan once
+// it was planned.
+func (r *Run) setStack(stack, status string, err error, plan *terraform.PlanResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.stacks, func(s StackRun) bool { return s.Stack == stack })
+	if i < 0 {
+		r.stacks = append(r.stacks, StackRun{Stack: stack})
+		i = len(r.stacks) - 1
+	}
+	r.stacks[i].Status = status
+	if err != nil {
+		r.stacks[i].Error = err.Error()
+	}
+	if plan != nil {
+		r.stacks[i].Plan = plan
+	}
+}
+
+// resetChecks drops the violations and the cost estimate of the plans of the
+// run so far, for plans checked again.
+func (r *Run) resetChecks() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.violations = nil
+	r.cost = nil
+}
+
+// Logs returns the output of the run so far.
+func (r *Run) Logs() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.logs.String()
+}
+
+func (r *Run) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.logs.Write(p)
+}
+
+func (r *Run) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.finishedAt = time.Now()
+	r.status = StatusSucceeded
+	if err != nil {
+		r.status = StatusFailed
+		r.err = err.Error()
+	}
+}
+
+// Run executes the action synchronously, streaming terraform's output to out
+// as well as to the run's logs.
+func (s *Session) Run(ctx context.Context, action string, out io.Writer, args ...string) (*Run, error) {
+	r, err := s.newRun(action, args)
+	if err != nil {
+		return nil, err
+	}
+	err = s.execute(ctx, r, io.MultiWriter(r, out))
+	return r, err
+}
+
+// Start executes the action in the background. Its progress can be followed
+// through the returned Run.
+func (s *Session) Start(ctx context.Context, action string, args ...string) (*Run, error) {
+	r, err := s.newRun(action, args)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = s.execute(ctx, r, r)
+	}()
+	return r, nil
+}
+
+// Runs returns all runs of the session, oldest first.
+func (s *Session) Runs() []*Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Run(nil), s.runs...)
+}
+
+// Running reports whether a run of the session is in progress.
+func (s *Session) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active != nil
+}
+
+// GetRun returns the run with the given id or nil.
+func (s *Session) GetRun(id string) *Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.runs {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *Session) newRun(action string, args []string) (*Run, error) {
+	a, ok := runActions[action]
+	if !ok {
+		return nil, fmt.Errorf("invalid action: %s", action)
+	}
+	if a.nargs >= 0 && len(args) != a.nargs {
+		return nil, fmt.Errorf("usage: %s %s", action, a.args)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		return nil, ErrRunInProgress
+	}
+	unlock, err := lockWorkspace(s.workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Run{
+		ID:        NewID(),
+		Action:    action,
+		Args:      args,
+		unlock:    unlock,
+		status:    StatusRunning,
+		startedAt: time.Now(),
+	}
+	s.runs = append(s.runs, r)
+	s.active = r
+	return r, nil
+}
+
+func (s *Session) execute(ctx context.Context, r *Run, out io.Writer) error {
+	info := r.Info()
+	s.publish(Event{Type: EventRunStarted, Run: &info})
+
+	ws, tfWorkspace := s.Workspace(), s.TerraformWorkspace()
+	err := s.terraform(ctx, r, out)
+	r.finish(err)
+
+	if r.Action == ActionDrift {
+		if saveErr := saveDriftReport(ws, newDriftReport(ws, tfWorkspace, r)); saveErr != nil {
+			slog.Warn("error saving drift report", "workspace", ws.Name, "error", saveErr)
+		}
+	}
+	if auditErr := s.audit(r, ws, tfWorkspace); auditErr != nil {
+		slog.Warn("error recording run in the history", "workspace", ws.Name, "error", auditErr)
+	}
+
+	s.mu.Lock()
+	s.active = nil
+	s.mu.Unlock()
+	r.unlock()
+
+	info = r.Info()
+	s.publish(Event{Type: EventRunFinished, Run: &info})
+	return err
+}
+
+func (s *Session) terraform(ctx context.Context, r *Run, out io.Writer) error {
+	action := r.Action
+	ws := s.Workspace()
+
+	// plan and apply need a program, ask the model for one if none was
+	// generated yet
+	if action == ActionPlan || action == ActionApply {
+		if !hasProgram(ws) {
+			if err := s.Generate(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(ws.Stacks) > 0 {
+		return s.terraformStacks(ctx, r, out)
+	}
+
+	a := runActions[action]
+	tfRunner, err := s.newRunner(ws, s.TerraformWorkspace(), "", a.actions, out, func(config *terraform.DriverConfig) {
+		// the logs of the run are kept below its id
+		config.RunID = r.ID
+		if a.configure != nil {
+			a.configure(config, r.Args)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	tfRunner.SetEventHandler(func(e terraform.Event) {
+		r.addApplied("", e)
+		info := r.Info()
+		s.publish(Event{Type: EventTerraform, Run: &info, Terraform: &e})
+	})
+	tfRunner.SetPlanHandler(func(plan *terraform.PlanResult) error {
+		r.setPlan(plan)
+		// over budget there is nothing to confirm
+		err := s.checkCost(r, "", plan)
+		if err == nil {
+			err = s.checkPolicy(r, "", plan)
+		}
+		info := r.Info()
+		s.publish(Event{Type: EventPlan, Run: &info})
+		return err
+	})
+	err = tfRunner.Execute(ctx)
+	if recordErr := recordTerraformVersion(ws, tfRunner.Version); recordErr != nil {
+		slog.Warn("error recording terraform version", "workspace", ws.Name, "error", recordErr)
+	}
+	return err
+}
+
+// newRunner prepares a runner executing the actions in the workspace, or in a
+// stack of it if stack is set. Runs in a terraform workspace other than the
+// default one select it first.
+func (s *Session) newRunner(ws *workspace.Workspace, tfWorkspace, stack string, actions []string, out io.Writer, configure ...func(*terraform.DriverConfig)) (*terraform.TerraformRunner, error) {
+	workDir := ws.Dir
+	if stack != "" {
+		workDir = ws.StackDir(stack)
+	}
+	config := terraform.NewDriverConfig(actions, terraformVersion(ws, workDir), workDir)
+	config.RunsDir = ws.LogDir()
+	if ws.Engine != "" {
+		config.Engine = ws.Engine
+	}
+	if tfWorkspace != defaultTerraformWorkspace {
+		config.Workspace = tfWorkspace
+	}
+	for _, f := range configure {
+		f(config)
+	}
+
+	usesBackend, err := s.configureBackend(config, stackStateName(ws, tfWorkspace, stack))
+	if err != nil {
+		return nil, err
+	}
+	if i := slices.Index(actions, terraform.Init); i >= 0 && !usesBackend && config.Workspace != "" && !slices.ContainsFunc(actions, isWorkspaceAction) {
+		config.Actions = slices.Insert(slices.Clone(actions), i+1, terraform.WorkspaceSelect)
+	}
+
+	tfRunner := terraform.NewTerraformRunner(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: config.SlogLevel()})), config)
+	tfRunner.SetStdout(out)
+	tfRunner.SetStderr(out)
+	return tfRunner, nil
+}
+
+// configureBackend points the run to the state of the given name in the local
+// http backend, if the session has one, and reports whether it does.
+func (s *Session) configureBackend(config *terraform.DriverConfig, name string) (bool, error) {
+	b := s.stateBackend()
+	if b == nil {
+		return false, nil
+	}
+
+	backendConfig, err := b.Configure(config.WorkDir, name)
+	if err != nil {
+		return false, err
+	}
+	config.BackendConfig = backendConfig
+
+	// the backend listens on a new port every time, a local state left from
+	// before the backend was used is migrated into it once
+	if _, err := os.Stat(filepath.Join(config.WorkDir, localStateFile)); err == nil && !b.Exists(name) {
+		config.ForceCopy = true
+	} else {
+		config.Reconfigure = true
+	}
+	return true, nil
+}
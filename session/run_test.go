@@ -0,0 +1,42 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+func TestRunLockedPerWorkspace(t *testing.T) {
+	m := workspace.NewManager(t.TempDir())
+	ws, err := m.Create("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err := m.Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := New(NewID(), ws, nil), New(NewID(), joined, nil)
+
+	r, err := first.newRun(ActionPlan, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.newRun(ActionApply, nil); !errors.Is(err, ErrRunInProgress) {
+		t.Fatalf("run of another session in the workspace: got %v, want %v", err, ErrRunInProgress)
+	}
+	if _, err := first.newRun(ActionApply, nil); !errors.Is(err, ErrRunInProgress) {
+		t.Fatalf("second run of the session: got %v, want %v", err, ErrRunInProgress)
+	}
+
+	r.unlock()
+	first.mu.Lock()
+	first.active = nil
+	first.mu.Unlock()
+	r, err = second.newRun(ActionApply, nil)
+	if err != nil {
+		t.Fatalf("run after the first finished: %s", err)
+	}
+	r.unlock()
+}
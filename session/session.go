@@ -0,0 +1,306 @@
+// Package session holds a conversation with the model together with the
+// Terraform program it produced, shared by the REPL, batch mode and the API
+// server.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/audit"
+	"github.com/niravparikh05/ginie-ai/backend"
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/llm"
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+const (
+	programFile    = "main.tf"
+	generatePrompt = "respond with only terraform hcl code"
+)
+
+const systemPrompt = `You are Ginie, an AI conversation assistant that builds and deploys Cloud Infrastructure written in Terraform.
+		Generate a description of the Terraform program you will define, followed by a single Terraform program which includes default values in response to each of my Instructions.
+		I will then deploy that program for you and let you know if there were errors.
+		You should modify the current program based on my instructions.
+		You should not start from scratch unless asked.`
+
+type Session struct {
+	ID        string
+	CreatedAt time.Time
+
+	provider llm.Provider
+	// chat serializes conversations with the model
+	chat sync.Mutex
+	// prompting serializes policy prompts
+	prompting sync.Mutex
+
+	mu        sync.Mutex
+	workspace *workspace.Workspace
+	backend   *backend.Local
+	auditLog  *audit.Log
+	policy    *policy.Policy
+	pricing   *cost.Catalog
+	// policyPrompt confirms applies despite policy warnings
+	policyPrompt PolicyPrompt
+	// acceptWarnings applies despite policy warnings without a prompt
+	acceptWarnings bool
+	// user is who the runs are recorded for in the history
+	user string
+	// userUnverified marks a user the client named itself
+	userUnverified bool
+	// tfWorkspace is the terraform workspace runs deploy into
+	tfWorkspace string
+	messages    []llm.Message
+	runs        []*Run
+	active      *Run
+
+	subscribers map[chan Event]struct{}
+}
+
+// ErrNoProvider is returned when a session without a model is asked for a
+// reply.
+var ErrNoProvider = fmt.Errorf("no model configured")
+
+// New returns a session working in ws. The provider may be nil for sessions
+// that only run terraform on existing programs.
+func New(id string, ws *workspace.Workspace, provider llm.Provider) *Session {
+	return &Session{
+		ID:        id,
+		CreatedAt: time.Now(),
+		provider:  provider,
+		workspace: ws,
+
+		subscribers: make(map[chan Event]struct{}),
+		/// This is a conversation in progress.
+		// NOTE: all messages, regardless of role, count against token usage for this API.
+		messages: []llm.Message{
+			// You set the tone and rules of the conversation with a prompt as the system role.
+			{Role: llm.RoleSystem, Content: systemMessage(ws)},
+			// The user asks a question
+			{Role: llm.RoleUser, Content: "Can you help create a working terraform template with default values and credentials section which I will update later if needed?"},
+			// The reply would come back from the ChatGPT. You'd add it to the conversation so we can maintain context.
+			{Role: llm.RoleAssistant, Content: "Of course! Which resource would you like to create?"},
+		},
+	}
+}
+
+// NewID returns a random identifier for a session or a run.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Workspace returns the workspace the session generates and deploys into, as
+// it is recorded now. Other sessions and processes may have changed its
+// budget, engine or stacks since the session opened it.
+func (s *Session) Workspace() *workspace.Workspace {
+	s.mu.Lock()
+	ws := s.workspace
+	s.mu.Unlock()
+	if current, err := ws.Reload(); err == nil {
+		return current
+	}
+	return ws
+}
+
+// WorkDir returns the directory of the session's workspace.
+func (s *Session) WorkDir() string {
+	return s.Workspace().Dir
+}
+
+// SetWorkspace switches the session to another workspace, in its default
+// terraform workspace. The conversation is kept.
+func (s *Session) SetWorkspace(ws *workspace.Workspace) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		return ErrRunInProgress
+	}
+	s.workspace = ws
+	s.tfWorkspace = ""
+	return nil
+}
+
+// Messages returns a copy of the conversation so far.
+func (s *Session) Messages() []llm.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]llm.Message(nil), s.messages...)
+}
+
+// Send adds the prompt to the conversation and returns the reply of the model.
+func (s *Session) Send(ctx context.Context, prompt string) (string, error) {
+	if s.provider == nil {
+		return "", ErrNoProvider
+	}
+
+	s.chat.Lock()
+	defer s.chat.Unlock()
+
+	// the engine and its version may have changed since the last prompt
+	system := systemMessage(s.Workspace())
+	s.mu.Lock()
+	s.messages[0].Content = system
+	messages := append(s.messages, llm.Message{Role: llm.RoleUser, Content: prompt})
+	s.mu.Unlock()
+
+	var reply string
+	var err error
+	if streamer, ok := s.provider.(llm.Streamer); ok {
+		reply, err = streamer.Stream(ctx, messages, func(token string) {
+			s.publish(Event{Type: EventToken, Token: token})
+		})
+	} else {
+		reply, err = s.provider.Complete(ctx, messages)
+	}
+	if err != nil {
+		return "", err
+	}
+	s.publish(Event{Type: EventMessage, Message: reply})
+
+	s.mu.Lock()
+	s.messages = append(messages, llm.Message{Role: llm.RoleAssistant, Content: reply})
+	s.mu.Unlock()
+	return reply, nil
+}
+
+// Generate asks the model for the current Terraform program and writes it to
+// the work dir.
+func (s *Session) Generate(ctx context.Context) error {
+	prompt := generatePrompt
+	if len(s.Workspace().Stacks) > 0 {
+		prompt = stackGeneratePrompt
+	}
+	response, err := s.Send(ctx, prompt)
+	if err != nil {
+		return err
+	}
+	return s.writeProgram(response)
+}
+
+func (s *Session) writeProgram(content string) error {
+	if ws := s.Workspace(); len(ws.Stacks) > 0 {
+		return s.writeStackPrograms(ws, content)
+	}
+
+	strs := strings.SplitAfter(content, "```")
+	if len(strs) < 2 {
+		return fmt.Errorf("no terraform program found in response")
+	}
+
+	var hcl string
+	hcl = strings.ReplaceAll(strs[1], "```", "")
+	hcl = strings.ReplaceAll(hcl, "hcl", "")
+
+	ws := s.Workspace()
+	program := []byte(strings.TrimSpace(hcl))
+
+	// keep every revision of the program around
+	if err := os.MkdirAll(ws.RevisionDir(), 0755); err != nil {
+		return err
+	}
+	revision := filepath.Join(ws.RevisionDir(), time.Now().UTC().Format("20060102T150405.000Z")+".tf")
+	if err := os.WriteFile(revision, program, 0644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(ws.Dir, programFile), program, 0644)
+}
+
+// Files lists the files generated in the work dir, ignoring terraform's own
+// directories.
+func (s *Session) Files() ([]string, error) {
+	entries, err := os.ReadDir(s.WorkDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			files = append(files, e.Name())
+		}
+	}
+	return files, nil
+}
+
+// ReadFile returns the content of a generated file.
+func (s *Session) ReadFile(name string) ([]byte, error) {
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid file name: %s", name)
+	}
+	return os.ReadFile(filepath.Join(s.WorkDir(), name))
+}
+
+// SetBackend makes the runs of the session keep their state in the local
+// http backend, under the name of the workspace.
+func (s *Session) SetBackend(b *backend.Local) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backend = b
+}
+
+func (s *Session) stateBackend() *backend.Local {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend
+}
+
+// StateResources returns the addresses of the resources in the state of the
+// workspace and terraform workspace. The addresses of resources of stacks
+// are prefixed with the stack, STACK:ADDRESS.
+func (s *Session) StateResources() ([]string, error) {
+	ws := s.Workspace()
+	if len(ws.Stacks) == 0 {
+		return s.stateResources(ws, "")
+	}
+
+	var addresses []string
+	for _, stack := range ws.Stacks {
+		stackAddresses, err := s.stateResources(ws, stack.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range stackAddresses {
+			addresses = append(addresses, stack.Name+":"+address)
+		}
+	}
+	return addresses, nil
+}
+
+func (s *Session) stateResources(ws *workspace.Workspace, stack string) ([]string, error) {
+	b := s.stateBackend()
+	if b == nil {
+		dir := ws.Dir
+		if stack != "" {
+			dir = ws.StackDir(stack)
+		}
+		return terraform.StateResources(dir)
+	}
+
+	state, err := b.State(stackStateName(ws, s.TerraformWorkspace(), stack))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return terraform.StateAddresses(state)
+}
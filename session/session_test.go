@@ -0,0 +1,58 @@
+package session
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// TestSessionsShareWorkspace changes a workspace from two sessions at once,
+// each sees the changes of the other.
+func TestSessionsShareWorkspace(t *testing.T) {
+	m := workspace.NewManager(t.TempDir())
+	first, err := m.Open("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Open("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := New(NewID(), first, nil), New(NewID(), second, nil)
+
+	var wg sync.WaitGroup
+	for _, change := range []func() error{
+		func() error { return a.SetBudget(10) },
+		func() error { return b.SetEngine(terraform.EngineOpenTofu) },
+		func() error { return b.SetStacks([]terraform.Stack{{Name: "network"}}) },
+		func() error { a.Budget(); b.Engine(); a.Stacks(); return nil },
+	} {
+		wg.Add(1)
+		go func(change func() error) {
+			defer wg.Done()
+			if err := change(); err != nil {
+				t.Error(err)
+			}
+		}(change)
+	}
+	wg.Wait()
+
+	for _, sess := range []*Session{a, b} {
+		if sess.Budget() != 10 || sess.Engine() != terraform.EngineOpenTofu || len(sess.Stacks()) != 1 {
+			t.Errorf("session sees budget %g, engine %s and stacks %v, want the changes of both", sess.Budget(), sess.Engine(), sess.Stacks())
+		}
+	}
+
+	// the budget set by the other session holds for the applies of this one
+	b.SetPricing(&cost.Catalog{Currency: "USD", Prices: []cost.Price{{Resource: "aws_nat_gateway", Monthly: 32.85}}})
+	plan := &terraform.PlanResult{ResourceChanges: map[string][]terraform.ResourceChange{
+		terraform.ActionCreate: {{Address: "aws_nat_gateway.a", Type: "aws_nat_gateway", Action: terraform.ActionCreate, After: map[string]interface{}{}}},
+	}}
+	if err := b.checkCost(&Run{Action: ActionApply}, "", plan); err == nil || !strings.Contains(err.Error(), "exceeds the budget of 10.00") {
+		t.Errorf("got %v, want the budget of the other session exceeded", err)
+	}
+}
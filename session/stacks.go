@@ -0,0 +1,510 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// stackMarker starts the code block of a stack in the replies of the model.
+const stackMarker = "# stack:"
+
+const stackGeneratePrompt = "respond with only terraform hcl code, one code block per stack"
+
+// StackRun is the part of a run that deployed a single stack.
+type StackRun struct {
+	Stack  string                `json:"stack"`
+	Status string                `json:"status"`
+	Error  string                `json:"error,omitempty"`
+	Plan   *terraform.PlanResult `json:"plan,omitempty"`
+}
+
+// Stacks returns the stacks the program of the session's workspace is split
+// into, in the order they are deployed.
+func (s *Session) Stacks() []terraform.Stack {
+	stacks, err := terraform.OrderStacks(s.Workspace().Stacks)
+	if err != nil {
+		return s.Workspace().Stacks
+	}
+	return stacks
+}
+
+// SetStacks splits the program of the session's workspace into stacks, or
+// joins it again if there are none.
+func (s *Session) SetStacks(stacks []terraform.Stack) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		return ErrRunInProgress
+	}
+	return s.workspace.SetStacks(stacks)
+}
+
+// stackStateName is the name the state of a stack is kept under in the local
+// http backend. It always names the terraform workspace, to not clash with
+// the states of terraform workspaces.
+func stackStateName(ws *workspace.Workspace, tfWorkspace, stack string) string {
+	if stack == "" {
+		return stateName(ws, tfWorkspace)
+	}
+	return ws.Name + "." + tfWorkspace + "." + stack
+}
+
+// stackLayout describes the stacks to the model.
+func stackLayout(stacks []terraform.Stack) string {
+	if len(stacks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\t\tThe program is split into stacks, each deployed on its own after the stacks it depends on:")
+	for _, stack := range stacks {
+		fmt.Fprintf(&b, "\n\t\t- %s", stack.Name)
+		if len(stack.DependsOn) > 0 {
+			fmt.Fprintf(&b, ", depends on %s", strings.Join(stack.DependsOn, ", "))
+		}
+	}
+	fmt.Fprintf(&b, "\n\t\tPut every resource into the stack it belongs to and write one code block per stack, starting with the line %q followed by the name of the stack.", stackMarker)
+	b.WriteString("\n\t\tA stack uses a value of a stack it depends on through a variable named like an output of that stack, the output is passed to it when deployed.")
+	return b.String()
+}
+
+// writeStackPrograms writes the code blocks of the reply into the work dirs
+// of the stacks they are marked with.
+func (s *Session) writeStackPrograms(ws *workspace.Workspace, content string) error {
+	programs := make(map[string][]byte)
+	blocks := strings.Split(content, "```")
+	for i := 1; i < len(blocks); i += 2 {
+		// drop the language of the block, e.g. hcl
+		_, code, _ := strings.Cut(blocks[i], "\n")
+		code = strings.TrimSpace(code)
+
+		first, _, _ := strings.Cut(code, "\n")
+		name, ok := strings.CutPrefix(strings.TrimSpace(first), stackMarker)
+		if !ok {
+			return fmt.Errorf("code block without %q line in response", stackMarker)
+		}
+		name = strings.TrimSpace(name)
+		if !slices.ContainsFunc(ws.Stacks, func(stack terraform.Stack) bool { return stack.Name == name }) {
+			return fmt.Errorf("unknown stack %s in response", name)
+		}
+		programs[name] = append(programs[name], []byte(code+"\n")...)
+	}
+	if len(programs) == 0 {
+		return fmt.Errorf("no terraform program found in response")
+	}
+
+	if err := os.MkdirAll(ws.RevisionDir(), 0755); err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format("20060102T150405.000Z")
+	for name, program := range programs {
+		// keep every revision of the program around
+		revision := filepath.Join(ws.RevisionDir(), now+"."+name+".tf")
+		if err := os.WriteFile(revision, program, 0644); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(ws.StackDir(name), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(ws.StackDir(name), programFile), program, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasProgram reports whether a program was generated into the workspace, or
+// into any of its stacks.
+func hasProgram(ws *workspace.Workspace) bool {
+	dirs := []string{ws.Dir}
+	for _, stack := range ws.Stacks {
+		dirs = append(dirs, ws.StackDir(stack.Name))
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, programFile)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// stackTarget splits STACK:ADDRESS arguments of actions on a single resource
+// into the stack and the address within it.
+func stackTarget(ws *workspace.Workspace, args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("the address has to name the stack, STACK:ADDRESS")
+	}
+	stack, address, ok := strings.Cut(args[0], ":")
+	if !ok || !slices.ContainsFunc(ws.Stacks, func(s terraform.Stack) bool { return s.Name == stack }) {
+		return "", nil, fmt.Errorf("the address has to name one of the stacks, STACK:ADDRESS")
+	}
+
+	args = append([]string{address}, args[1:]...)
+	// the destination of a move stays within the stack
+	for i := 1; i < len(args); i++ {
+		if other, address, ok := strings.Cut(args[i], ":"); ok && other == stack {
+			args[i] = address
+		}
+	}
+	return stack, args, nil
+}
+
+// withDependencies returns the stacks named and those they depend on,
+// directly or not.
+func withDependencies(stacks []terraform.Stack, names []string) []terraform.Stack {
+	byName := make(map[string]terraform.Stack, len(stacks))
+	for _, stack := range stacks {
+		byName[stack.Name] = stack
+	}
+
+	keep := make(map[string]bool)
+	var add func(name string)
+	add = func(name string) {
+		if keep[name] {
+			return
+		}
+		keep[name] = true
+		for _, dep := range byName[name].DependsOn {
+			add(dep)
+		}
+	}
+	for _, name := range names {
+		add(name)
+	}
+
+	var selected []terraform.Stack
+	for _, stack := range stacks {
+		if keep[stack.Name] {
+			selected = append(selected, stack)
+		}
+	}
+	return selected
+}
+
+// stackOutputs collects the outputs of the stacks of a run as they finish.
+type stackOutputs struct {
+	mu      sync.Mutex
+	outputs map[string]map[string]tfexec.OutputMeta
+}
+
+func (o *stackOutputs) set(stack string, outputs map[string]tfexec.OutputMeta) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.outputs == nil {
+		o.outputs = make(map[string]map[string]tfexec.OutputMeta)
+	}
+	o.outputs[stack] = outputs
+}
+
+// of merges the outputs of the given stacks.
+func (o *stackOutputs) of(stacks []string) map[string]tfexec.OutputMeta {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	merged := make(map[string]tfexec.OutputMeta)
+	for _, stack := range stacks {
+		for name, output := range o.outputs[stack] {
+			merged[name] = output
+		}
+	}
+	return merged
+}
+
+// terraformStacks runs the action across the stacks of the workspace,
+// concurrently where the dependencies between them allow. Plan and apply
+// pass the outputs of every stack on to the stacks depending on it, other
+// actions read the outputs of the stacks they depend on first. Destroy
+// removes the stacks depending on others first. Actions on a single resource
+// run in the stack the STACK:ADDRESS argument names.
+//
+// Apply plans every stack before applying any, so that the policy and the
+// budget hold for all of them together. A stack depending on the changes of
+// another one can only be planned once those are applied, it is checked
+// then, and if it fails the error names the stacks already applied.
+func (s *Session) terraformStacks(ctx context.Context, r *Run, out io.Writer) error {
+	ws := s.Workspace()
+	tfWorkspace := s.TerraformWorkspace()
+	a := runActions[r.Action]
+
+	// the stacks each stack gets the outputs of
+	deps := make(map[string][]string, len(ws.Stacks))
+	for _, stack := range ws.Stacks {
+		deps[stack.Name] = stack.DependsOn
+	}
+
+	targets := ws.Stacks
+	args := r.Args
+	if a.nargs > 0 {
+		stack, stackArgs, err := stackTarget(ws, args)
+		if err != nil {
+			return err
+		}
+		targets = []terraform.Stack{{Name: stack}}
+		args = stackArgs
+	}
+
+	// the lines of the stacks running side by side are kept apart
+	var outMu sync.Mutex
+	var mu sync.Mutex
+	var resolved string
+	runStack := func(ctx context.Context, stack terraform.Stack, actions []string, id string, vars []string, configure func(*terraform.DriverConfig, []string)) (*terraform.TerraformRunner, error) {
+		stackOut := newPrefixWriter(out, &outMu, stack.Name)
+		defer stackOut.Flush()
+
+		tfRunner, err := s.newRunner(ws, tfWorkspace, stack.Name, actions, stackOut, func(config *terraform.DriverConfig) {
+			config.RunID = id
+			config.Var = append(config.Var, vars...)
+			if configure != nil {
+				configure(config, args)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		tfRunner.SetEventHandler(func(e terraform.Event) {
+			r.addApplied(stack.Name, e)
+			info := r.Info()
+			s.publish(Event{Type: EventTerraform, Run: &info, Stack: stack.Name, Terraform: &e})
+		})
+		tfRunner.SetPlanHandler(func(plan *terraform.PlanResult) error {
+			r.setStack(stack.Name, StatusRunning, nil, plan)
+			err := s.checkCost(r, stack.Name, plan)
+			if err == nil {
+				err = s.checkPolicy(r, stack.Name, plan)
+			}
+			info := r.Info()
+			s.publish(Event{Type: EventPlan, Run: &info, Stack: stack.Name})
+			return err
+		})
+		err = tfRunner.Execute(ctx)
+
+		mu.Lock()
+		if resolved == "" {
+			resolved = tfRunner.Version
+		}
+		mu.Unlock()
+		return tfRunner, err
+	}
+	defer func() {
+		if err := recordTerraformVersion(ws, resolved); err != nil {
+			slog.Warn("error recording terraform version", "workspace", ws.Name, "error", err)
+		}
+	}()
+
+	var outputs stackOutputs
+	producesOutputs := r.Action == ActionPlan || r.Action == ActionApply
+	if !producesOutputs {
+		var names []string
+		for _, target := range targets {
+			names = append(names, deps[target.Name]...)
+		}
+		if upstream := withDependencies(ws.Stacks, names); len(upstream) > 0 {
+			errs, err := terraform.RunStacks(ctx, upstream, false, func(ctx context.Context, stack terraform.Stack) error {
+				tfRunner, err := runStack(ctx, stack, []string{terraform.Init, terraform.Output}, r.ID+".outputs."+stack.Name, nil, nil)
+				if err != nil {
+					return err
+				}
+				outputs.set(stack.Name, tfRunner.Outputs())
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := stacksError(errs); err != nil {
+				return fmt.Errorf("error reading the outputs of the stacks: %s", err)
+			}
+		}
+	}
+
+	actions := a.actions
+	if producesOutputs {
+		actions = append(slices.Clone(actions), terraform.Output)
+	}
+	for _, target := range targets {
+		r.setStack(target.Name, StatusRunning, nil, nil)
+	}
+	if r.Action == ActionApply && len(targets) > 1 {
+		deferred, err := s.checkStacks(ctx, r, targets, deps, &outputs, func(ctx context.Context, stack terraform.Stack, vars []string) (*terraform.TerraformRunner, error) {
+			return runStack(ctx, stack, []string{terraform.Init, terraform.Plan, terraform.Output}, r.ID+".check."+stack.Name, vars, a.configure)
+		})
+		if err != nil {
+			return err
+		}
+		if len(deferred) > 0 {
+			fmt.Fprintf(out, "Stacks %s depend on changes of other stacks, they are checked once those are applied.\n", strings.Join(deferred, ", "))
+		}
+	}
+	errs, err := terraform.RunStacks(ctx, targets, r.Action == ActionDestroy, func(ctx context.Context, stack terraform.Stack) error {
+		dir := ws.StackDir(stack.Name)
+		vars, err := terraform.StackVars(dir, outputs.of(deps[stack.Name]))
+		if err != nil {
+			return err
+		}
+
+		tfRunner, err := runStack(ctx, stack, actions, r.ID+"."+stack.Name, vars, a.configure)
+		if tfRunner != nil {
+			outputs.set(stack.Name, tfRunner.Outputs())
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var applied []string
+	for name, err := range errs {
+		status := StatusSucceeded
+		if err != nil {
+			status = StatusFailed
+		} else {
+			applied = append(applied, name)
+		}
+		r.setStack(name, status, err, nil)
+	}
+	err = stacksError(errs)
+	if err != nil && r.Action == ActionApply && len(applied) > 0 {
+		slices.Sort(applied)
+		return fmt.Errorf("%s\nstacks already applied: %s", err, strings.Join(applied, ", "))
+	}
+	return err
+}
+
+// checkStacks plans the stacks of an apply with the outputs the stacks they
+// depend on have now, checking the plans against the policy and the budget
+// before any stack is applied. Stacks depending on stacks with changes are
+// not planned, their inputs change on apply, they are returned. If a plan
+// fails or does not pass the checks no stack is applied. The estimates and
+// violations of the plans are dropped once they passed, the plans of the
+// apply check them again.
+func (s *Session) checkStacks(ctx context.Context, r *Run, targets []terraform.Stack, deps map[string][]string, outputs *stackOutputs, plan func(ctx context.Context, stack terraform.Stack, vars []string) (*terraform.TerraformRunner, error)) ([]string, error) {
+	ws := s.Workspace()
+
+	var mu sync.Mutex
+	// changed holds the stacks whose outputs may change on apply
+	changed := make(map[string]bool)
+	var deferred []string
+	errs, err := terraform.RunStacks(ctx, targets, false, func(ctx context.Context, stack terraform.Stack) error {
+		mu.Lock()
+		wait := slices.ContainsFunc(deps[stack.Name], func(dep string) bool { return changed[dep] })
+		if wait {
+			changed[stack.Name] = true
+			deferred = append(deferred, stack.Name)
+		}
+		mu.Unlock()
+		if wait {
+			return nil
+		}
+
+		vars, err := terraform.StackVars(ws.StackDir(stack.Name), outputs.of(deps[stack.Name]))
+		if err != nil {
+			return err
+		}
+		tfRunner, err := plan(ctx, stack, vars)
+		if tfRunner != nil {
+			outputs.set(stack.Name, tfRunner.Outputs())
+			if p := tfRunner.PlanResult(); p != nil && p.HasChanges {
+				mu.Lock()
+				changed[stack.Name] = true
+				mu.Unlock()
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := stacksError(errs); err != nil {
+		for name, stackErr := range errs {
+			if stackErr == nil {
+				stackErr = errNotApplied
+			}
+			r.setStack(name, StatusFailed, stackErr, nil)
+		}
+		return nil, fmt.Errorf("error checking the plans of the stacks, none was applied: %s", err)
+	}
+
+	r.resetChecks()
+	slices.Sort(deferred)
+	return deferred, nil
+}
+
+// errNotApplied marks the stacks of an apply that passed the checks while
+// others did not.
+var errNotApplied = errors.New("not applied, the plans of other stacks failed")
+
+// stacksError joins the errors of the stacks, naming them.
+func stacksError(errs map[string]error) error {
+	var joined []error
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := errs[name]; err != nil && !errors.Is(err, terraform.ErrStackSkipped) {
+			joined = append(joined, fmt.Errorf("stack %s: %s", name, err))
+		}
+	}
+	return errors.Join(joined...)
+}
+
+// prefixWriter prefixes the lines written to it with the name of a stack, to
+// tell apart the output of stacks running side by side. Terraform's stdout
+// and stderr are copied to the same writer from goroutines of their own.
+type prefixWriter struct {
+	out io.Writer
+	// mu is shared by the writers of the same output, it guards buf as well
+	mu     *sync.Mutex
+	prefix []byte
+	buf    bytes.Buffer
+}
+
+func newPrefixWriter(out io.Writer, mu *sync.Mutex, stack string) *prefixWriter {
+	return &prefixWriter{out: out, mu: mu, prefix: []byte("[" + stack + "] ")}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := w.writeLine(w.buf.Next(i + 1)); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes what is left of an unterminated line.
+func (w *prefixWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		_ = w.writeLine(append(w.buf.Bytes(), '\n'))
+		w.buf.Reset()
+	}
+}
+
+// writeLine writes a line with the prefix, the caller must hold w.mu.
+func (w *prefixWriter) writeLine(line []byte) error {
+	_, err := w.out.Write(append(append([]byte(nil), w.prefix...), line...))
+	return err
+}
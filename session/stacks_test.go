@@ -0,0 +1,224 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/cost"
+	"github.com/niravparikh05/ginie-ai/policy"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// TestPrefixWriter writes to the writers of two stacks from two goroutines
+// each, as terraform's stdout and stderr are copied, and checks no line is
+// torn or mixed up.
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	stacks := []string{"network", "app"}
+
+	var wg sync.WaitGroup
+	for _, stack := range stacks {
+		w := newPrefixWriter(&out, &mu, stack)
+		for _, stream := range []string{"stdout", "stderr"} {
+			wg.Add(1)
+			go func(stack, stream string) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					// lines split across writes
+					fmt.Fprintf(w, "%s %s ", stack, stream)
+					fmt.Fprintf(w, "%d\n", i)
+				}
+			}(stack, stream)
+		}
+		defer w.Flush()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(stacks)*2*200 {
+		t.Fatalf("got %d lines, want %d", len(lines), len(stacks)*2*200)
+	}
+	for _, line := range lines {
+		var stack, text, stream string
+		var i int
+		if _, err := fmt.Sscanf(line, "[%s %s %s %d", &stack, &text, &stream, &i); err != nil {
+			t.Fatalf("torn line %q: %s", line, err)
+		}
+		if stack != text+"]" {
+			t.Errorf("line of %s prefixed with %s: %q", text, stack, line)
+		}
+	}
+}
+
+func TestPrefixWriterFlush(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, &sync.Mutex{}, "app")
+	fmt.Fprint(w, "done\nno newline")
+	if got := out.String(); got != "[app] done\n" {
+		t.Errorf("before flush: got %q", got)
+	}
+	w.Flush()
+	if got := out.String(); got != "[app] done\n[app] no newline\n" {
+		t.Errorf("after flush: got %q", got)
+	}
+}
+
+// stackTerraform stands in for terraform in the dirs of stacks. show prints
+// the plan.json of the stack, plan reports changes if it has any. Plan and
+// apply record the stack in calls.log next to it.
+const stackTerraform = `#!/bin/sh
+dir=$(dirname "$0")
+stack=$(basename "$PWD")
+case "$1" in
+  version)
+    echo '{"terraform_version":"1.6.0","platform":"linux_amd64","provider_selections":{},"terraform_outdated":false}';;
+  plan)
+    for arg in "$@"; do
+      case "$arg" in -out=*) touch "${arg#-out=}";; esac
+    done
+    echo "plan $stack" >> "$dir/calls.log"
+    grep -q resource_changes plan.json && exit 2
+    exit 0;;
+  show)
+    cat plan.json;;
+  apply)
+    echo "apply $stack" >> "$dir/calls.log";;
+  output)
+    echo '{}';;
+esac
+`
+
+const (
+	noChanges = `{"format_version":"1.2","terraform_version":"1.6.0"}`
+	gateway   = `{"format_version":"1.2","terraform_version":"1.6.0","resource_changes":[{"address":"aws_nat_gateway.gw","mode":"managed","type":"aws_nat_gateway","name":"gw","change":{"actions":["create"],"before":null,"after":{}}}]}`
+	untagged  = `{"format_version":"1.2","terraform_version":"1.6.0","resource_changes":[{"address":"aws_instance.app","mode":"managed","type":"aws_instance","name":"app","change":{"actions":["create"],"before":null,"after":{}}}]}`
+)
+
+func TestApplyStacks(t *testing.T) {
+	ownerTag := func(severity string) *policy.Policy {
+		exists := true
+		return &policy.Policy{Rules: []policy.Rule{{
+			Name:       "owner-tag",
+			Severity:   severity,
+			Resources:  []string{"aws_instance"},
+			Conditions: []policy.Condition{{Attribute: "tags.owner", Exists: &exists}},
+		}}}
+	}
+
+	tests := []struct {
+		name string
+		// app depends on network if set
+		dependent bool
+		network   string
+		app       string
+		budget    float64
+		policy    *policy.Policy
+		// err holds what the error of the run has to contain, none if empty
+		err     []string
+		applied []string
+		prompts int
+	}{
+		{"all pass", false, gateway, gateway, 100, nil, nil, []string{"app", "network"}, 0},
+		{"over budget together", false, gateway, gateway, 50, nil,
+			[]string{"none was applied", "exceeds the budget of 50.00 USD/month"}, nil, 0},
+		{"policy of another stack", false, gateway, untagged, 0, ownerTag(policy.SeverityError),
+			[]string{"none was applied", "stack app: plan violates the policy"}, nil, 0},
+		{"warnings confirmed once", false, gateway, untagged, 0, ownerTag(policy.SeverityWarn),
+			nil, []string{"app", "network"}, 1},
+		{"dependent checked before apply", true, noChanges, untagged, 0, ownerTag(policy.SeverityError),
+			[]string{"none was applied", "stack app: plan violates the policy"}, nil, 0},
+		{"dependent checked after apply", true, gateway, untagged, 0, ownerTag(policy.SeverityError),
+			[]string{"stack app: plan violates the policy", "stacks already applied: network"}, []string{"network"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary := filepath.Join(t.TempDir(), "terraform")
+			if err := os.WriteFile(binary, []byte(stackTerraform), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(terraform.BinaryPathEnv, binary)
+
+			ws, err := workspace.NewManager(t.TempDir()).Create("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			stacks := []terraform.Stack{{Name: "network"}, {Name: "app"}}
+			if tt.dependent {
+				stacks[1].DependsOn = []string{"network"}
+			}
+			if err := ws.SetStacks(stacks); err != nil {
+				t.Fatal(err)
+			}
+			for stack, plan := range map[string]string{"network": tt.network, "app": tt.app} {
+				if err := os.MkdirAll(ws.StackDir(stack), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(ws.StackDir(stack), programFile), []byte("# stack: "+stack+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(ws.StackDir(stack), "plan.json"), []byte(plan), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sess := New(NewID(), ws, nil)
+			sess.SetPricing(&cost.Catalog{Currency: "USD", Prices: []cost.Price{{Resource: "aws_nat_gateway", Monthly: 30}}})
+			if err := sess.SetBudget(tt.budget); err != nil {
+				t.Fatal(err)
+			}
+			sess.SetPolicy(tt.policy)
+			var prompts int
+			sess.SetPolicyPrompt(func([]policy.Violation) bool {
+				prompts++
+				return true
+			})
+
+			r, err := sess.newRun(ActionApply, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runErr := sess.execute(context.Background(), r, r)
+			if len(tt.err) == 0 && runErr != nil {
+				t.Fatalf("%s\n%s", runErr, r.Logs())
+			}
+			for _, want := range tt.err {
+				if runErr == nil || !strings.Contains(runErr.Error(), want) {
+					t.Fatalf("got %v, want %q", runErr, want)
+				}
+			}
+
+			b, err := os.ReadFile(filepath.Join(filepath.Dir(binary), "calls.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var applied []string
+			for _, call := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+				if stack, ok := strings.CutPrefix(call, "apply "); ok {
+					applied = append(applied, stack)
+				}
+			}
+			slices.Sort(applied)
+			if !slices.Equal(applied, tt.applied) {
+				t.Fatalf("applied %v, want %v", applied, tt.applied)
+			}
+			if prompts != tt.prompts {
+				t.Errorf("got %d prompts, want %d", prompts, tt.prompts)
+			}
+			// the plans checked before the apply do not add to its cost
+			if info := r.Info(); runErr == nil && info.Cost.After != float64(30*strings.Count(tt.network+tt.app, "aws_nat_gateway.gw")) {
+				t.Errorf("got cost %+v, want that of the gateways", info.Cost)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+const defaultTerraformWorkspace = "default"
+
+// validTerraformWorkspace leaves out dots, they separate the workspace, the
+// terraform workspace and the stack in the names of states.
+var validTerraformWorkspace = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+func checkTerraformWorkspace(name string) error {
+	if !validTerraformWorkspace.MatchString(name) {
+		return fmt.Errorf("invalid terraform workspace name: %s", name)
+	}
+	return nil
+}
+
+// stateName is the name the state of a terraform workspace is kept under in
+// the local http backend. The http backend has no notion of terraform
+// workspaces, every one of them but the default gets a state of its own
+// instead.
+func stateName(ws *workspace.Workspace, tfWorkspace string) string {
+	if tfWorkspace == defaultTerraformWorkspace {
+		return ws.Name
+	}
+	return ws.Name + "." + tfWorkspace
+}
+
+func isWorkspaceAction(action string) bool {
+	return strings.HasPrefix(action, "workspace-")
+}
+
+// TerraformWorkspace returns the terraform workspace the runs of the session
+// deploy into, so that the same program can be deployed side by side as dev,
+// staging and prod.
+func (s *Session) TerraformWorkspace() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tfWorkspace == "" {
+		return defaultTerraformWorkspace
+	}
+	return s.tfWorkspace
+}
+
+func (s *Session) setTerraformWorkspace(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		return ErrRunInProgress
+	}
+	s.tfWorkspace = name
+	return nil
+}
+
+// TerraformWorkspaces lists the terraform workspaces of the session's
+// workspace.
+func (s *Session) TerraformWorkspaces(ctx context.Context, out io.Writer) ([]string, error) {
+	ws := s.Workspace()
+	if b := s.stateBackend(); b != nil {
+		names, err := b.List()
+		if err != nil {
+			return nil, err
+		}
+		workspaces := []string{defaultTerraformWorkspace}
+		for _, name := range names {
+			// the states of stacks are named after the terraform workspace
+			// and the stack
+			if tfWorkspace, ok := strings.CutPrefix(name, ws.Name+"."); ok && !strings.Contains(tfWorkspace, ".") {
+				workspaces = append(workspaces, tfWorkspace)
+			}
+		}
+		return workspaces, nil
+	}
+
+	unlock, err := lockWorkspace(ws)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	tfRunner, err := s.newRunner(ws, "", "", []string{terraform.Init, terraform.WorkspaceList}, out)
+	if err != nil {
+		return nil, err
+	}
+	if err := tfRunner.Execute(ctx); err != nil {
+		return nil, err
+	}
+	workspaces, _ := tfRunner.Workspaces()
+	return workspaces, nil
+}
+
+// NewTerraformWorkspace creates a terraform workspace and selects it.
+func (s *Session) NewTerraformWorkspace(ctx context.Context, name string, out io.Writer) error {
+	if err := checkTerraformWorkspace(name); err != nil {
+		return err
+	}
+	ws := s.Workspace()
+	if b := s.stateBackend(); b != nil {
+		workspaces, err := s.TerraformWorkspaces(ctx, out)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(workspaces, name) {
+			return fmt.Errorf("terraform workspace %s already exists", name)
+		}
+		if err := b.Create(stateName(ws, name)); err != nil {
+			return err
+		}
+		return s.setTerraformWorkspace(name)
+	}
+
+	if err := s.runWorkspaceAction(ctx, ws, name, terraform.WorkspaceNew, out); err != nil {
+		return err
+	}
+	return s.setTerraformWorkspace(name)
+}
+
+// SelectTerraformWorkspace selects an existing terraform workspace.
+func (s *Session) SelectTerraformWorkspace(ctx context.Context, name string, out io.Writer) error {
+	if err := checkTerraformWorkspace(name); err != nil {
+		return err
+	}
+	ws := s.Workspace()
+	if b := s.stateBackend(); b != nil {
+		workspaces, err := s.TerraformWorkspaces(ctx, out)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(workspaces, name) {
+			return fmt.Errorf("terraform workspace %s does not exist", name)
+		}
+		return s.setTerraformWorkspace(name)
+	}
+
+	if err := s.runWorkspaceAction(ctx, ws, name, terraform.WorkspaceSelect, out); err != nil {
+		return err
+	}
+	return s.setTerraformWorkspace(name)
+}
+
+// DeleteTerraformWorkspace deletes a terraform workspace that manages no
+// resources anymore. Neither the default nor the selected workspace can be
+// deleted, nor one while a run is in progress in the workspace.
+func (s *Session) DeleteTerraformWorkspace(ctx context.Context, name string, out io.Writer) error {
+	if err := checkTerraformWorkspace(name); err != nil {
+		return err
+	}
+	if name == defaultTerraformWorkspace || name == s.TerraformWorkspace() {
+		return fmt.Errorf("cannot delete terraform workspace %s", name)
+	}
+
+	ws := s.Workspace()
+	if b := s.stateBackend(); b != nil {
+		unlock, err := lockWorkspace(ws)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		workspaces, err := s.TerraformWorkspaces(ctx, out)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(workspaces, name) {
+			return fmt.Errorf("terraform workspace %s does not exist", name)
+		}
+
+		names := []string{stateName(ws, name)}
+		for _, stack := range ws.Stacks {
+			names = append(names, stackStateName(ws, name, stack.Name))
+		}
+		for _, stateName := range names {
+			state, err := b.State(stateName)
+			if err != nil {
+				continue
+			}
+			addresses, err := terraform.StateAddresses(state)
+			if err != nil {
+				return err
+			}
+			if len(addresses) > 0 {
+				return fmt.Errorf("terraform workspace %s still manages %d resources, destroy them first", name, len(addresses))
+			}
+		}
+		for _, stateName := range names {
+			if err := b.Remove(stateName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return s.runWorkspaceAction(ctx, ws, name, terraform.WorkspaceDelete, out)
+}
+
+func (s *Session) runWorkspaceAction(ctx context.Context, ws *workspace.Workspace, name, action string, out io.Writer) error {
+	unlock, err := lockWorkspace(ws)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tfRunner, err := s.newRunner(ws, name, "", []string{terraform.Init, action}, out)
+	if err != nil {
+		return err
+	}
+	return tfRunner.Execute(ctx)
+}
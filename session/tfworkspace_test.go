@@ -0,0 +1,82 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/backend"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+func newBackendSession(t *testing.T) (*Session, *backend.Local) {
+	t.Helper()
+	ws, err := workspace.NewManager(t.TempDir()).Create("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &backend.Local{Server: backend.NewServer(t.TempDir())}
+	sess := New(NewID(), ws, nil)
+	sess.SetBackend(b)
+	return sess, b
+}
+
+func TestTerraformWorkspaceNames(t *testing.T) {
+	sess, _ := newBackendSession(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"dev.api", "", ".", "-dev", "dev/api"} {
+		if err := sess.NewTerraformWorkspace(ctx, name, io.Discard); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("new %q: got %v, want an invalid name error", name, err)
+		}
+		if err := sess.SelectTerraformWorkspace(ctx, name, io.Discard); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("select %q: got %v, want an invalid name error", name, err)
+		}
+		if err := sess.DeleteTerraformWorkspace(ctx, name, io.Discard); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("delete %q: got %v, want an invalid name error", name, err)
+		}
+	}
+
+	if err := sess.NewTerraformWorkspace(ctx, "dev", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	// created, but no state stored yet
+	if err := sess.NewTerraformWorkspace(ctx, "dev", io.Discard); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("got %v, want an already exists error", err)
+	}
+}
+
+func TestDeleteTerraformWorkspace(t *testing.T) {
+	sess, b := newBackendSession(t)
+	ctx := context.Background()
+
+	if err := sess.DeleteTerraformWorkspace(ctx, "staging", io.Discard); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("got %v, want a does not exist error", err)
+	}
+
+	if err := sess.NewTerraformWorkspace(ctx, "staging", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.SelectTerraformWorkspace(ctx, defaultTerraformWorkspace, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// a run of another session in the workspace
+	unlock, err := sess.Workspace().Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.DeleteTerraformWorkspace(ctx, "staging", io.Discard); !errors.Is(err, ErrRunInProgress) {
+		t.Errorf("delete during a run: got %v, want %v", err, ErrRunInProgress)
+	}
+	unlock()
+
+	if err := sess.DeleteTerraformWorkspace(ctx, "staging", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := b.List(); len(names) != 0 {
+		t.Errorf("states left after the delete: %v", names)
+	}
+}
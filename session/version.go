@@ -0,0 +1,83 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-version"
+	"github.com/niravparikh05/ginie-ai/terraform"
+	"github.com/niravparikh05/ginie-ai/workspace"
+)
+
+// terraformVersion returns the version runs in dir, the workspace or one of
+// its stacks, use. A version set in the environment or pinned by a
+// .terraform-version file wins, otherwise the version recorded by the first
+// run is kept for as long as the program's required_version accepts it.
+func terraformVersion(ws *workspace.Workspace, dir string) string {
+	if v := os.Getenv(terraform.VersionEnv); v != "" {
+		return v
+	}
+	if _, err := os.Stat(filepath.Join(dir, terraform.VersionFile)); err == nil {
+		return ""
+	}
+	if ws.TerraformVersion == "" {
+		return ""
+	}
+
+	v, err := version.NewVersion(ws.TerraformVersion)
+	if err != nil {
+		return ""
+	}
+	constraints, err := terraform.RequiredVersion(dir)
+	if err != nil || !constraints.Check(v) {
+		return ""
+	}
+	return ws.TerraformVersion
+}
+
+// recordTerraformVersion records the version a run resolved to, once it was
+// resolved to an exact one.
+func recordTerraformVersion(ws *workspace.Workspace, resolved string) error {
+	if resolved == ws.TerraformVersion {
+		return nil
+	}
+	if _, err := version.NewVersion(resolved); err != nil {
+		return nil
+	}
+	return ws.SetTerraformVersion(resolved)
+}
+
+// systemMessage tells the model which engine, and which version of it, the
+// program it writes is deployed with, and which stacks it is split into.
+func systemMessage(ws *workspace.Workspace) string {
+	target := "the latest version"
+	if v, err := version.NewVersion(terraformVersion(ws, ws.Dir)); err == nil {
+		target = "version " + v.String()
+	}
+	return fmt.Sprintf("%s\n\t\tThe program is deployed with %s, %s, only use features it supports.%s", systemPrompt, terraform.EngineName(ws.Engine), target, stackLayout(ws.Stacks))
+}
+
+// Engine returns the engine runs of the session's workspace use.
+func (s *Session) Engine() string {
+	if engine := s.Workspace().Engine; engine != "" {
+		return engine
+	}
+	return terraform.EngineTerraform
+}
+
+// SetEngine switches the session's workspace to terraform or tofu for
+// OpenTofu.
+func (s *Session) SetEngine(engine string) error {
+	if err := terraform.ValidateEngine(engine); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		return ErrRunInProgress
+	}
+	return s.workspace.SetEngine(engine)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/niravparikh05/ginie-ai/session"
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+// stacks handles !stacks, listing the stacks of the current workspace,
+// !stacks add <name> [dependency...], !stacks rm <name> and !stacks clear,
+// which joins the program into a single one again.
+func (g *ginie) stacks(args []string) error {
+	stacks := g.sess.Stacks()
+	if len(args) == 0 {
+		if len(stacks) == 0 {
+			fmt.Printf("workspace %s has no stacks\n", g.sess.Workspace().Name)
+		}
+		for _, stack := range stacks {
+			if len(stack.DependsOn) == 0 {
+				fmt.Println(stack.Name)
+				continue
+			}
+			fmt.Printf("%s <- %s\n", stack.Name, strings.Join(stack.DependsOn, ", "))
+		}
+		return nil
+	}
+
+	switch {
+	case args[0] == "add" && len(args) >= 2:
+		if slices.ContainsFunc(stacks, func(s terraform.Stack) bool { return s.Name == args[1] }) {
+			return fmt.Errorf("stack %s already exists", args[1])
+		}
+		stacks = append(stacks, terraform.Stack{Name: args[1], DependsOn: args[2:]})
+	case args[0] == "rm" && len(args) == 2:
+		i := slices.IndexFunc(stacks, func(s terraform.Stack) bool { return s.Name == args[1] })
+		if i < 0 {
+			return fmt.Errorf("stack %s does not exist", args[1])
+		}
+		stacks = slices.Delete(stacks, i, i+1)
+	case args[0] == "clear" && len(args) == 1:
+		stacks = nil
+	default:
+		return fmt.Errorf("usage: !stacks [add <name> [dependency...]|rm <name>|clear]")
+	}
+	return g.sess.SetStacks(stacks)
+}
+
+// printPlan prints the summary of the plan of a run, of every stack it
+// planned.
+func printPlan(r *session.Run) {
+	if plan := r.Plan(); plan != nil {
+		fmt.Println(plan.Summary())
+	}
+	for _, stack := range r.Info().Stacks {
+		if stack.Plan != nil {
+			fmt.Printf("%s: %s\n", stack.Stack, stack.Plan.Summary())
+		}
+	}
+	info := r.Info()
+	if info.Cost != nil {
+		fmt.Println(info.Cost.Summary())
+	}
+	for _, v := range info.Violations {
+		fmt.Println(v)
+	}
+}
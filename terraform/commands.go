@@ -0,0 +1,171 @@
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// validate checks the configuration, reporting its diagnostics like those
+// of plan and apply.
+func (t *TerraformRunner) validate(ctx context.Context, tf *tfexec.Terraform) error {
+	out, err := tf.Validate(ctx)
+	if err != nil {
+		return fmt.Errorf("error running Validate: %s", err)
+	}
+
+	w := newEventWriter(Validate, t.onEvent, t.stdout)
+	for _, d := range out.Diagnostics {
+		e := Event{
+			Type:   EventDiagnostic,
+			Action: Validate,
+			Diagnostic: &Diagnostic{
+				Severity: string(d.Severity),
+				Summary:  d.Summary,
+				Detail:   d.Detail,
+			},
+		}
+		if d.Range != nil {
+			e.Diagnostic.Filename = d.Range.Filename
+			e.Diagnostic.Line = d.Range.Start.Line
+		}
+		w.render(e)
+		t.emit(e)
+	}
+
+	if !out.Valid {
+		return fmt.Errorf("configuration is invalid: %d errors", out.ErrorCount)
+	}
+	fmt.Fprintln(t.stdout, "The configuration is valid.")
+	return nil
+}
+
+// format rewrites the configuration files in the canonical format, listing
+// the files it changed.
+func (t *TerraformRunner) format(ctx context.Context, tf *tfexec.Terraform) error {
+	_, files, err := tf.FormatCheck(ctx, t.GetFormatOptions()...)
+	if err != nil {
+		return fmt.Errorf("error running FormatCheck: %s", err)
+	}
+	if err := tf.FormatWrite(ctx, t.GetFormatOptions()...); err != nil {
+		return fmt.Errorf("error running FormatWrite: %s", err)
+	}
+	for _, f := range files {
+		fmt.Fprintln(t.stdout, f)
+	}
+	return nil
+}
+
+// stateList lists the addresses of the resources in the state.
+func (t *TerraformRunner) stateList(ctx context.Context, tf *tfexec.Terraform) error {
+	state, err := t.showState(ctx, tf)
+	if err != nil {
+		return err
+	}
+	for _, r := range stateResources(state) {
+		fmt.Fprintln(t.stdout, r.Address)
+	}
+	return nil
+}
+
+// stateShow prints the attributes of the resource at t.Address.
+func (t *TerraformRunner) stateShow(ctx context.Context, tf *tfexec.Terraform) error {
+	state, err := t.showState(ctx, tf)
+	if err != nil {
+		return err
+	}
+	for _, r := range stateResources(state) {
+		if r.Address != t.Address {
+			continue
+		}
+		b, err := json.MarshalIndent(r.AttributeValues, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(t.stdout, "# %s:\n%s\n", r.Address, b)
+		return nil
+	}
+	return fmt.Errorf("no resource %s in state", t.Address)
+}
+
+func (t *TerraformRunner) showState(ctx context.Context, tf *tfexec.Terraform) (*tfjson.State, error) {
+	tf.SetStdout(io.Discard)
+	defer tf.SetStdout(t.stdout)
+
+	state, err := tf.Show(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error running Show: %s", err)
+	}
+	return state, nil
+}
+
+// stateResources flattens the resources of all modules of the state.
+func stateResources(state *tfjson.State) []*tfjson.StateResource {
+	if state.Values == nil || state.Values.RootModule == nil {
+		return nil
+	}
+
+	var resources []*tfjson.StateResource
+	modules := []*tfjson.StateModule{state.Values.RootModule}
+	for len(modules) > 0 {
+		m := modules[0]
+		modules = append(modules[1:], m.ChildModules...)
+		resources = append(resources, m.Resources...)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Address < resources[j].Address
+	})
+	return resources
+}
+
+// graph prints the dependency graph in the DOT format.
+func (t *TerraformRunner) graph(ctx context.Context, tf *tfexec.Terraform) error {
+	tf.SetStdout(io.Discard)
+	graph, err := tf.Graph(ctx, t.GetGraphOptions()...)
+	tf.SetStdout(t.stdout)
+	if err != nil {
+		return fmt.Errorf("error running Graph: %s", err)
+	}
+	fmt.Fprintln(t.stdout, graph)
+	return nil
+}
+
+// providersSchema reads the schemas of the providers in use. The full
+// schemas are kept on the runner, only a summary is printed.
+func (t *TerraformRunner) providersSchema(ctx context.Context, tf *tfexec.Terraform) error {
+	tf.SetStdout(io.Discard)
+	schemas, err := tf.ProvidersSchema(ctx)
+	tf.SetStdout(t.stdout)
+	if err != nil {
+		return fmt.Errorf("error running ProvidersSchema: %s", err)
+	}
+	t.providerSchemas = schemas
+
+	var names []string
+	for name := range schemas.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := schemas.Schemas[name]
+		fmt.Fprintf(t.stdout, "%s: %d resources, %d data sources\n", name, len(s.ResourceSchemas), len(s.DataSourceSchemas))
+	}
+	return nil
+}
+
+// ProviderSchemas returns the schemas read by the providers-schema action,
+// nil if there was none.
+func (t *TerraformRunner) ProviderSchemas() *tfjson.ProviderSchemas {
+	return t.providerSchemas
+}
+
+// Outputs returns the outputs read by the output action, nil if there was
+// none.
+func (t *TerraformRunner) Outputs() map[string]tfexec.OutputMeta {
+	return t.outputs
+}
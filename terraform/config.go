@@ -2,8 +2,9 @@ package terraform
 
 import (
 	"fmt"
-	"log/slog"
+	"os"
 	"strings"
+	"time"
 )
 
 const (
@@ -22,9 +23,28 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
+// DriverConfig configures a TerraformRunner.
+//
+// Version is an exact version, "latest", a constraint such as "~> 1.5",
+// "path" for the binary on PATH or empty to resolve it from the
+// .terraform-version file or the program's required_version. Engine is
+// terraform or tofu for OpenTofu.
+//
+// LogLevel is the level of Ginie's own logs, TerraformLogLevel that of
+// terraform core, one of TF_LOG's levels or JSON, and ProviderLogLevel that
+// of the providers. The logs of a run are written to RunsDir/RunID,
+// terraform's own log to LogPath if set. LogRetention is how many runs keep
+// their logs and LogMaxAge how long.
+//
+// OverrideDir holds files layered onto every program, e.g. *_override.tf
+// files, and ContextDataDir files made available to it below contextdata/,
+// e.g. mounted credentials.
 type DriverConfig struct {
 	Actions                    arrayFlags
 	Version                    string
+	Engine                     string
+	BinaryPath                 string
+	InstallDir                 string
 	WorkDir                    string
 	PlanFile                   string
 	Backend                    bool
@@ -43,6 +63,7 @@ type DriverConfig struct {
 	Target                     arrayFlags
 	Replace                    arrayFlags
 	Refresh                    bool
+	RefreshOnly                bool
 	Destroy                    bool
 	Parallelism                int
 	Backup                     string
@@ -52,26 +73,69 @@ type DriverConfig struct {
 	UploadUrl                  string
 	UploadToken                string
 	Debug                      bool
+	LogLevel                   string
+	TerraformLogLevel          string
+	ProviderLogLevel           string
+	LogPath                    string
+	RunID                      string
+	RunsDir                    string
+	LogRetention               int
+	LogMaxAge                  time.Duration
+	OverrideDir                string
+	ContextDataDir             string
+	InterruptGracePeriod       time.Duration
+	TerminateGracePeriod       time.Duration
 	LockID                     string
+	Workspace                  string
+	Force                      bool
+	Address                    string
+	ImportID                   string
+	Destination                string
+	AllowMissing               bool
+	DryRun                     bool
+	BackupOut                  string
+	Recursive                  bool
+	DrawCycles                 bool
+	GraphType                  string
+	FSMirror                   string
+	NetMirror                  string
+	Platform                   arrayFlags
+	Provider                   arrayFlags
 	OverrideTfDownloadEndpoint string
 	SkipTLSVerify              bool
-
-	logLvl *slog.LevelVar
 }
 
 func NewDriverConfig(actions arrayFlags, version, workDir string) *DriverConfig {
-	logLevel := new(slog.LevelVar)
-	logLevel.Set(slog.LevelDebug)
+	// defaults match terraform's own
 	return &DriverConfig{
-		Actions: actions,
-		Version: version,
-		WorkDir: workDir,
-		logLvl:  logLevel,
+		Actions:              actions,
+		Version:              version,
+		Engine:               EngineTerraform,
+		BinaryPath:           os.Getenv(BinaryPathEnv),
+		InstallDir:           envOr(InstallDirEnv, defaultInstallDir),
+		LogLevel:             envOr(LogLevelEnv, defaultLogLevel),
+		TerraformLogLevel:    envOr(TerraformLogEnv, defaultTerraformLogLevel),
+		ProviderLogLevel:     envOr(ProviderLogEnv, defaultProviderLogLevel),
+		LogRetention:         defaultLogRetention,
+		OverrideDir:          defaultOverrideDir,
+		ContextDataDir:       secretMountPath,
+		WorkDir:              workDir,
+		Backend:              true,
+		Get:                  true,
+		Lock:                 true,
+		LockTimeout:          defaultLockTimeout,
+		Refresh:              true,
+		Parallelism:          defaultParallelism,
+		InterruptGracePeriod: defaultInterruptGracePeriod,
+		TerminateGracePeriod: defaultTerminateGracePeriod,
 	}
 }
 
-func (d *DriverConfig) GetLogLvl() string {
-	return d.logLvl.Level().String()
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
 
 func (d *DriverConfig) Validate() error {
@@ -79,6 +143,14 @@ func (d *DriverConfig) Validate() error {
 		return fmt.Errorf("-action flag is required")
 	}
 
+	if err := ValidateEngine(d.Engine); err != nil {
+		return err
+	}
+
+	if err := d.validateLogLevels(); err != nil {
+		return err
+	}
+
 	for _, action := range d.Actions {
 		if !AvailableActions[action] {
 			return fmt.Errorf("invalid action: %s", action)
@@ -87,10 +159,22 @@ func (d *DriverConfig) Validate() error {
 		if action == ForceUnlock && d.LockID == "" {
 			return fmt.Errorf("-lock-id flag is required when -force-unlock is used")
 		}
-	}
 
-	if d.DownloadUrl == "" {
-		return fmt.Errorf("-download-url flag is required")
+		if (action == WorkspaceNew || action == WorkspaceSelect || action == WorkspaceDelete) && d.Workspace == "" {
+			return fmt.Errorf("-workspace flag is required when -%s is used", action)
+		}
+
+		if (action == Import || action == StateShow || action == StateRm || action == StateMv || action == Taint || action == Untaint) && d.Address == "" {
+			return fmt.Errorf("-address flag is required when -%s is used", action)
+		}
+
+		if action == Import && d.ImportID == "" {
+			return fmt.Errorf("-import-id flag is required when -%s is used", action)
+		}
+
+		if action == StateMv && d.Destination == "" {
+			return fmt.Errorf("-destination flag is required when -%s is used", action)
+		}
 	}
 
 	return nil
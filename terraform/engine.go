@@ -0,0 +1,282 @@
+package terraform
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hc-install/product"
+	"github.com/hashicorp/hc-install/releases"
+)
+
+const (
+	EngineTerraform = "terraform"
+	EngineOpenTofu  = "tofu"
+)
+
+const (
+	terraformReleasesURL = "https://releases.hashicorp.com/terraform/index.json"
+	tofuReleasesURL      = "https://get.opentofu.org/tofu/api.json"
+)
+
+var (
+	tofuDownloadURL = "https://github.com/opentofu/opentofu/releases/download"
+	// tofuKeyURL serves the key OpenTofu signs the checksums of its releases
+	// with, it is only trusted with the fingerprint of tofuKeyFingerprint
+	tofuKeyURL         = "https://get.opentofu.org/opentofu.asc"
+	tofuKeyFingerprint = "E3E6E43D84CB852EADB0051D0C0AF313E5FD9F80"
+)
+
+// engine is a binary speaking terraform's CLI, either terraform itself or
+// OpenTofu, and where its releases come from.
+type engine struct {
+	name    string
+	binary  string
+	product product.Product
+	// releases lists the released versions, newest first
+	releases func(ctx context.Context) ([]*version.Version, error)
+	// download installs a version into dir and returns the binary's path
+	download func(ctx context.Context, v *version.Version, dir string) (string, error)
+}
+
+var engines = map[string]*engine{
+	EngineTerraform: {
+		name:     "Terraform",
+		binary:   "terraform",
+		product:  product.Terraform,
+		releases: terraformReleases,
+		download: downloadTerraform,
+	},
+	EngineOpenTofu: {
+		name:   "OpenTofu",
+		binary: "tofu",
+		product: product.Product{
+			Name:       "tofu",
+			BinaryName: func() string { return "tofu" },
+			GetVersion: binaryVersion,
+		},
+		releases: tofuReleases,
+		download: downloadTofu,
+	},
+}
+
+func getEngine(name string) (*engine, error) {
+	if name == "" {
+		name = EngineTerraform
+	}
+	e, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid engine: %s", name)
+	}
+	return e, nil
+}
+
+// ValidateEngine checks that name is an engine runs can use.
+func ValidateEngine(name string) error {
+	_, err := getEngine(name)
+	return err
+}
+
+// EngineName returns the display name of the engine, e.g. OpenTofu for tofu.
+func EngineName(name string) string {
+	e, err := getEngine(name)
+	if err != nil {
+		return name
+	}
+	return e.name
+}
+
+func terraformReleases(ctx context.Context) ([]*version.Version, error) {
+	var index struct {
+		Versions map[string]json.RawMessage `json:"versions"`
+	}
+	if err := getJSON(ctx, terraformReleasesURL, &index); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for s := range index.Versions {
+		names = append(names, s)
+	}
+	return sortedVersions(names), nil
+}
+
+func tofuReleases(ctx context.Context) ([]*version.Version, error) {
+	var index struct {
+		Versions []struct {
+			ID string `json:"id"`
+		} `json:"versions"`
+	}
+	if err := getJSON(ctx, tofuReleasesURL, &index); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, v := range index.Versions {
+		names = append(names, v.ID)
+	}
+	return sortedVersions(names), nil
+}
+
+func downloadTerraform(ctx context.Context, v *version.Version, dir string) (string, error) {
+	installer := &releases.ExactVersion{
+		Product:    product.Terraform,
+		Version:    v,
+		InstallDir: dir,
+	}
+	return installer.Install(ctx)
+}
+
+// downloadTofu fetches the release archive of OpenTofu from GitHub, verifies
+// it against the release's checksums, signed by OpenTofu, and unpacks the
+// binary into dir.
+func downloadTofu(ctx context.Context, v *version.Version, dir string) (string, error) {
+	base := fmt.Sprintf("%s/v%s", tofuDownloadURL, v)
+	archiveName := fmt.Sprintf("tofu_%s_%s_%s.zip", v, runtime.GOOS, runtime.GOARCH)
+
+	sumsURL := fmt.Sprintf("%s/tofu_%s_SHA256SUMS", base, v)
+	sums, err := httpGet(ctx, sumsURL)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyTofuSignature(ctx, sums, sumsURL+".gpgsig"); err != nil {
+		return "", fmt.Errorf("error verifying the checksums of OpenTofu %s: %s", v, err)
+	}
+	expected := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(sums)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == archiveName {
+			expected = fields[0]
+		}
+	}
+	if expected == "" {
+		return "", fmt.Errorf("no checksum for %s", archiveName)
+	}
+
+	archive, err := httpGet(ctx, base+"/"+archiveName)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(archive)
+	if hex.EncodeToString(sum[:]) != expected {
+		return "", fmt.Errorf("checksum mismatch for %s", archiveName)
+	}
+
+	archivePath := filepath.Join(dir, archiveName)
+	if err := os.WriteFile(archivePath, archive, 0o600); err != nil {
+		return "", err
+	}
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.Name != "tofu" {
+			continue
+		}
+		src, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer src.Close()
+
+		execPath := filepath.Join(dir, "tofu")
+		dst, err := os.OpenFile(execPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+		if err != nil {
+			return "", err
+		}
+		defer dst.Close()
+		if _, err := io.Copy(dst, src); err != nil {
+			return "", err
+		}
+		return execPath, nil
+	}
+	return "", fmt.Errorf("no tofu binary in %s", archiveName)
+}
+
+// verifyTofuSignature checks the detached signature at sigURL of the
+// checksums against OpenTofu's signing key, as hc-install does for terraform
+// with HashiCorp's.
+func verifyTofuSignature(ctx context.Context, sums []byte, sigURL string) error {
+	armoredKey, err := httpGet(ctx, tofuKeyURL)
+	if err != nil {
+		return err
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return err
+	}
+	var trusted openpgp.EntityList
+	for _, e := range keyring {
+		if strings.EqualFold(hex.EncodeToString(e.PrimaryKey.Fingerprint), tofuKeyFingerprint) {
+			trusted = append(trusted, e)
+		}
+	}
+	if len(trusted) == 0 {
+		return fmt.Errorf("no key with fingerprint %s at %s", tofuKeyFingerprint, tofuKeyURL)
+	}
+
+	signature, err := httpGet(ctx, sigURL)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(trusted, bytes.NewReader(sums), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(trusted, bytes.NewReader(sums), bytes.NewReader(signature), nil)
+	}
+	return err
+}
+
+func httpGet(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func getJSON(ctx context.Context, url string, v any) error {
+	b, err := httpGet(ctx, url)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// sortedVersions parses the versions, newest first, skipping invalid ones.
+func sortedVersions(names []string) []*version.Version {
+	versions := make([]*version.Version, 0, len(names))
+	for _, s := range names {
+		if v, err := version.NewVersion(s); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Sort(sort.Reverse(version.Collection(versions)))
+	return versions
+}
@@ -0,0 +1,152 @@
+package terraform
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/hashicorp/go-version"
+)
+
+// tofuRelease serves a release of a fake tofu, its checksums signed with a
+// key of its own, and that key in place of OpenTofu's.
+type tofuRelease struct {
+	files map[string][]byte
+}
+
+func newTofuRelease(t *testing.T, v string) *tofuRelease {
+	t.Helper()
+	signer, err := openpgp.NewEntity("OpenTofu", "", "core@opentofu.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	f, err := zw.Create("tofu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "#!/bin/sh")
+	zw.Close()
+	archiveName := fmt.Sprintf("tofu_%s_%s_%s.zip", v, runtime.GOOS, runtime.GOARCH)
+	sum := sha256.Sum256(archive.Bytes())
+	sums := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), archiveName)
+
+	var signature bytes.Buffer
+	if err := openpgp.DetachSign(&signature, signer, strings.NewReader(sums), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := "/v" + v + "/"
+	release := &tofuRelease{files: map[string][]byte{
+		"/opentofu.asc":                             key.Bytes(),
+		prefix + archiveName:                        archive.Bytes(),
+		prefix + "tofu_" + v + "_SHA256SUMS":        []byte(sums),
+		prefix + "tofu_" + v + "_SHA256SUMS.gpgsig": signature.Bytes(),
+	}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := release.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	downloadURL, keyURL, fingerprint := tofuDownloadURL, tofuKeyURL, tofuKeyFingerprint
+	tofuDownloadURL, tofuKeyURL = srv.URL, srv.URL+"/opentofu.asc"
+	tofuKeyFingerprint = hex.EncodeToString(signer.PrimaryKey.Fingerprint)
+	t.Cleanup(func() {
+		tofuDownloadURL, tofuKeyURL, tofuKeyFingerprint = downloadURL, keyURL, fingerprint
+	})
+	return release
+}
+
+func (r *tofuRelease) edit(t *testing.T, suffix string, edit func([]byte) []byte) {
+	t.Helper()
+	for name, b := range r.files {
+		if strings.HasSuffix(name, suffix) {
+			r.files[name] = edit(b)
+			return
+		}
+	}
+	t.Fatalf("no file %s", suffix)
+}
+
+func TestDownloadTofu(t *testing.T) {
+	newTofuRelease(t, "1.6.2")
+	path, err := downloadTofu(context.Background(), version.Must(version.NewVersion("1.6.2")), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "#!/bin/sh\n" {
+		t.Errorf("got binary %q, %v", b, err)
+	}
+}
+
+func TestDownloadTofuUnsigned(t *testing.T) {
+	tests := []struct {
+		name   string
+		suffix string
+		edit   func([]byte) []byte
+	}{
+		{"edited checksums", "_SHA256SUMS", func(b []byte) []byte {
+			return append(b, "0000  tofu_extra.zip\n"...)
+		}},
+		{"other signature", ".gpgsig", func(b []byte) []byte {
+			b = bytes.Clone(b)
+			b[len(b)-1] ^= 0xff
+			return b
+		}},
+		{"other key", "opentofu.asc", func([]byte) []byte {
+			other, _ := openpgp.NewEntity("Mallory", "", "mallory@example.com", nil)
+			var key bytes.Buffer
+			w, _ := armor.Encode(&key, openpgp.PublicKeyType, nil)
+			other.Serialize(w)
+			w.Close()
+			return key.Bytes()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := newTofuRelease(t, "1.6.2")
+			release.edit(t, tt.suffix, tt.edit)
+			dir := t.TempDir()
+			if _, err := downloadTofu(context.Background(), version.Must(version.NewVersion("1.6.2")), dir); err == nil {
+				t.Fatal("unsigned release installed")
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("left %d files in the install dir", len(entries))
+			}
+		})
+	}
+
+	release := newTofuRelease(t, "1.6.2")
+	delete(release.files, "/v1.6.2/tofu_1.6.2_SHA256SUMS.gpgsig")
+	if _, err := downloadTofu(context.Background(), version.Must(version.NewVersion("1.6.2")), t.TempDir()); err == nil {
+		t.Error("release without a signature installed")
+	}
+}
@@ -0,0 +1,210 @@
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	EventActionStarted  = "action_started"
+	EventActionFinished = "action_finished"
+	EventAborted        = "aborted"
+
+	// machine readable UI messages of terraform, see
+	// https://developer.hashicorp.com/terraform/internals/machine-readable-ui
+	EventApplyStart    = "apply_start"
+	EventApplyProgress = "apply_progress"
+	EventApplyComplete = "apply_complete"
+	EventApplyErrored  = "apply_errored"
+	EventPlannedChange = "planned_change"
+	EventResourceDrift = "resource_drift"
+	EventDiagnostic    = "diagnostic"
+	EventChangeSummary = "change_summary"
+	eventVersion       = "version"
+	eventLog           = "log"
+)
+
+const severityError = "error"
+
+// Event describes the progress of a terraform run.
+type Event struct {
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	// Message is terraform's human readable rendering of the event
+	Message        string         `json:"message,omitempty"`
+	Resource       string         `json:"resource,omitempty"`
+	Operation      string         `json:"operation,omitempty"`
+	IDKey          string         `json:"idKey,omitempty"`
+	IDValue        string         `json:"idValue,omitempty"`
+	ElapsedSeconds int            `json:"elapsedSeconds,omitempty"`
+	Diagnostic     *Diagnostic    `json:"diagnostic,omitempty"`
+	Changes        *ChangeSummary `json:"changes,omitempty"`
+	Error          string         `json:"error,omitempty"`
+}
+
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+type ChangeSummary struct {
+	Add       int    `json:"add"`
+	Change    int    `json:"change"`
+	Remove    int    `json:"remove"`
+	Import    int    `json:"import"`
+	Operation string `json:"operation"`
+}
+
+// EventHandler is called for every event of a run, in order.
+type EventHandler func(Event)
+
+// uiMessage is a single line of terraform's -json output.
+type uiMessage struct {
+	Level   string `json:"@level"`
+	Message string `json:"@message"`
+	Type    string `json:"type"`
+	Hook    *struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action         string `json:"action"`
+		IDKey          string `json:"id_key"`
+		IDValue        string `json:"id_value"`
+		ElapsedSeconds int    `json:"elapsed_seconds"`
+	} `json:"hook"`
+	Change *struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"change"`
+	Diagnostic *struct {
+		Severity string `json:"severity"`
+		Summary  string `json:"summary"`
+		Detail   string `json:"detail"`
+		Address  string `json:"address"`
+		Range    *struct {
+			Filename string `json:"filename"`
+			Start    struct {
+				Line int `json:"line"`
+			} `json:"start"`
+		} `json:"range"`
+	} `json:"diagnostic"`
+	Changes *ChangeSummary `json:"changes"`
+}
+
+// eventWriter parses the -json output of terraform commands into events and
+// writes a compact, per-resource rendering of them to out.
+type eventWriter struct {
+	mu      sync.Mutex
+	action  string
+	handler EventHandler
+	out     io.Writer
+	buf     bytes.Buffer
+}
+
+func newEventWriter(action string, handler EventHandler, out io.Writer) *eventWriter {
+	return &eventWriter{action: action, handler: handler, out: out}
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadBytes('\n')
+		if err != nil {
+			// incomplete line, wait for the rest of it
+			w.buf.Reset()
+			w.buf.Write(line)
+			break
+		}
+		w.parse(line)
+	}
+	return len(p), nil
+}
+
+func (w *eventWriter) parse(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var m uiMessage
+	if err := json.Unmarshal(line, &m); err != nil {
+		// not a json message, pass it on as is
+		fmt.Fprintf(w.out, "%s\n", line)
+		return
+	}
+
+	e := Event{Type: m.Type, Action: w.action, Message: m.Message}
+	switch m.Type {
+	case eventVersion:
+		return
+	case EventApplyStart, EventApplyProgress, EventApplyComplete, EventApplyErrored:
+		if m.Hook != nil {
+			e.Resource = m.Hook.Resource.Addr
+			e.Operation = m.Hook.Action
+			e.IDKey = m.Hook.IDKey
+			e.IDValue = m.Hook.IDValue
+			e.ElapsedSeconds = m.Hook.ElapsedSeconds
+		}
+	case EventPlannedChange, EventResourceDrift:
+		if m.Change != nil {
+			e.Resource = m.Change.Resource.Addr
+			e.Operation = m.Change.Action
+		}
+	case EventDiagnostic:
+		if m.Diagnostic != nil {
+			e.Diagnostic = &Diagnostic{
+				Severity: m.Diagnostic.Severity,
+				Summary:  m.Diagnostic.Summary,
+				Detail:   m.Diagnostic.Detail,
+				Address:  m.Diagnostic.Address,
+			}
+			if m.Diagnostic.Range != nil {
+				e.Diagnostic.Filename = m.Diagnostic.Range.Filename
+				e.Diagnostic.Line = m.Diagnostic.Range.Start.Line
+			}
+		}
+	case EventChangeSummary:
+		e.Changes = m.Changes
+	default:
+		// outputs, refreshes and plain log lines are only rendered
+		e.Type = eventLog
+	}
+
+	w.render(e)
+	if e.Type != eventLog && w.handler != nil {
+		w.handler(e)
+	}
+}
+
+func (w *eventWriter) render(e Event) {
+	if e.Diagnostic == nil {
+		fmt.Fprintf(w.out, "%s\n", e.Message)
+		return
+	}
+
+	d := e.Diagnostic
+	severity := "Warning"
+	if d.Severity == severityError {
+		severity = "Error"
+	}
+	fmt.Fprintf(w.out, "%s: %s\n", severity, d.Summary)
+	if d.Filename != "" {
+		fmt.Fprintf(w.out, "  on %s line %d\n", d.Filename, d.Line)
+	}
+	if d.Detail != "" {
+		fmt.Fprintf(w.out, "  %s\n", strings.ReplaceAll(d.Detail, "\n", "\n  "))
+	}
+}
@@ -0,0 +1,114 @@
+package terraform
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// applyOutput is the -json output of an apply, with a line terraform did not
+// render as JSON.
+const applyOutput = `{"@level":"info","@message":"Terraform 1.6.0","type":"version","terraform":"1.6.0","ui":"1.2"}
+{"@level":"info","@message":"aws_s3_bucket.logs: Creating...","type":"apply_start","hook":{"resource":{"addr":"aws_s3_bucket.logs"},"action":"create"}}
+{"@level":"info","@message":"aws_s3_bucket.logs: Still creating... [10s elapsed]","type":"apply_progress","hook":{"resource":{"addr":"aws_s3_bucket.logs"},"action":"create","elapsed_seconds":10}}
+plain output of a provider
+{"@level":"info","@message":"aws_s3_bucket.logs: Creation complete after 12s [id=logs]","type":"apply_complete","hook":{"resource":{"addr":"aws_s3_bucket.logs"},"action":"create","id_key":"id","id_value":"logs","elapsed_seconds":12}}
+{"@level":"info","@message":"Outputs: 0","type":"outputs","outputs":{}}
+{"@level":"error","@message":"Error: creating instance","type":"diagnostic","diagnostic":{"severity":"error","summary":"creating instance","detail":"InvalidAMIID\nthe AMI does not exist","address":"aws_instance.web","range":{"filename":"main.tf","start":{"line":7,"column":1}}}}
+{"@level":"warn","@message":"Warning: deprecated","type":"diagnostic","diagnostic":{"severity":"warning","summary":"deprecated argument"}}
+{"@level":"info","@message":"Apply complete! Resources: 1 added, 0 changed, 0 destroyed.","type":"change_summary","changes":{"add":1,"change":0,"remove":0,"import":0,"operation":"apply"}}
+`
+
+var applyEvents = []Event{
+	{Type: EventApplyStart, Action: Apply, Message: "aws_s3_bucket.logs: Creating...", Resource: "aws_s3_bucket.logs", Operation: "create"},
+	{Type: EventApplyProgress, Action: Apply, Message: "aws_s3_bucket.logs: Still creating... [10s elapsed]", Resource: "aws_s3_bucket.logs", Operation: "create", ElapsedSeconds: 10},
+	{Type: EventApplyComplete, Action: Apply, Message: "aws_s3_bucket.logs: Creation complete after 12s [id=logs]", Resource: "aws_s3_bucket.logs", Operation: "create", IDKey: "id", IDValue: "logs", ElapsedSeconds: 12},
+	{Type: EventDiagnostic, Action: Apply, Message: "Error: creating instance", Diagnostic: &Diagnostic{
+		Severity: "error", Summary: "creating instance", Detail: "InvalidAMIID\nthe AMI does not exist", Address: "aws_instance.web", Filename: "main.tf", Line: 7,
+	}},
+	{Type: EventDiagnostic, Action: Apply, Message: "Warning: deprecated", Diagnostic: &Diagnostic{Severity: "warning", Summary: "deprecated argument"}},
+	{Type: EventChangeSummary, Action: Apply, Message: "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.", Changes: &ChangeSummary{Add: 1, Operation: "apply"}},
+}
+
+const applyRendering = `aws_s3_bucket.logs: Creating...
+aws_s3_bucket.logs: Still creating... [10s elapsed]
+plain output of a provider
+aws_s3_bucket.logs: Creation complete after 12s [id=logs]
+Outputs: 0
+Error: creating instance
+  on main.tf line 7
+  InvalidAMIID
+  the AMI does not exist
+Warning: deprecated argument
+Apply complete! Resources: 1 added, 0 changed, 0 destroyed.
+`
+
+func TestEventWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		// size is how many bytes are written at once, all of them if 0
+		size int
+	}{
+		{"lines", 0},
+		{"bytes", 1},
+		{"split lines", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			var events []Event
+			w := newEventWriter(Apply, func(e Event) { events = append(events, e) }, &out)
+
+			output := []byte(applyOutput)
+			size := tt.size
+			if size == 0 {
+				size = len(output)
+			}
+			for len(output) > 0 {
+				n := min(size, len(output))
+				if written, err := w.Write(output[:n]); written != n || err != nil {
+					t.Fatalf("wrote %d of %d: %v", written, n, err)
+				}
+				output = output[n:]
+			}
+
+			if !reflect.DeepEqual(events, applyEvents) {
+				t.Errorf("got events\n%+v\nwant\n%+v", events, applyEvents)
+			}
+			if out.String() != applyRendering {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), applyRendering)
+			}
+		})
+	}
+}
+
+func TestEventWriterIncompleteLine(t *testing.T) {
+	var out bytes.Buffer
+	var events []Event
+	w := newEventWriter(Plan, func(e Event) { events = append(events, e) }, &out)
+
+	line := `{"@level":"info","@message":"aws_s3_bucket.logs: Plan to create","type":"planned_change","change":{"resource":{"addr":"aws_s3_bucket.logs"},"action":"create"}}`
+	w.Write([]byte(line[:40]))
+	w.Write([]byte(line[40:]))
+	if len(events) != 0 || out.Len() != 0 {
+		t.Fatalf("got %v and %q before the end of the line", events, out.String())
+	}
+	w.Write([]byte("\n\n"))
+
+	want := []Event{{Type: EventPlannedChange, Action: Plan, Message: "aws_s3_bucket.logs: Plan to create", Resource: "aws_s3_bucket.logs", Operation: "create"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %+v, want %+v", events, want)
+	}
+	if out.String() != "aws_s3_bucket.logs: Plan to create\n" {
+		t.Errorf("got %q", out.String())
+	}
+}
+
+func TestEventWriterWithoutHandler(t *testing.T) {
+	var out bytes.Buffer
+	w := newEventWriter(Apply, nil, &out)
+	w.Write([]byte(applyOutput))
+	if out.String() != applyRendering {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), applyRendering)
+	}
+}
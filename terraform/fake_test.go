@@ -0,0 +1,116 @@
+package terraform
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTerraform stands in for terraform. It records its calls in calls.log
+// next to it and in TF_LOG_PATH if set, init prints the dir and log path it
+// runs with, plan saves a plan unique to the call and apply records the plan
+// it was given. With a file named slow in the work dir init hangs, its pid in
+// init.pid, with one named stubborn as well it ignores SIGINT. state pull
+// prints a state.
+const fakeTerraform = `#!/bin/sh
+dir=$(dirname "$0")
+echo "$PWD: $*" >> "$dir/calls.log"
+if [ -n "$TF_LOG_PATH" ]; then
+  echo "[INFO] $1" >> "$TF_LOG_PATH"
+fi
+eval last=\${$#}
+case "$1" in
+  version)
+    echo '{"terraform_version":"1.6.0","platform":"linux_amd64","provider_selections":{},"terraform_outdated":false}';;
+  init)
+    echo "pwd=$PWD log=$TF_LOG_PATH level=$TF_LOG_CORE"
+    if [ -f slow ]; then
+      [ -f stubborn ] && trap '' INT
+      echo $$ > init.pid
+      exec sleep 30
+    fi;;
+  plan)
+    for arg in "$@"; do
+      case "$arg" in -out=*) out="${arg#-out=}";; esac
+    done
+    echo "plan-$$-$(date +%s%N)" > "$out"
+    echo '{"@level":"info","@message":"Terraform 1.6.0","type":"version","terraform":"1.6.0","ui":"1.2"}'
+    exit 2;;
+  show)
+    printf '{"format_version":"1.2","terraform_version":"1.6.0","resource_changes":[{"address":"null_resource.a","mode":"managed","type":"null_resource","name":"a","provider_name":"registry.terraform.io/hashicorp/null","change":{"actions":["create"],"before":null,"after":{"plan":"%s"}}}]}\n' "$(cat "$last")";;
+  apply)
+    if [ -f "$last" ]; then
+      echo "applied $(cat "$last")" >> "$dir/calls.log"
+    else
+      echo "applied without a plan" >> "$dir/calls.log"
+    fi
+    echo '{"@level":"info","@message":"Apply complete!","type":"change_summary","changes":{"add":1,"change":0,"remove":0,"import":0,"operation":"apply"}}';;
+  state)
+    if [ "$2" = pull ]; then
+      echo '{"version":4,"serial":1}'
+    fi;;
+esac
+`
+
+// installFake writes the fake terraform to a dir of its own, returning its
+// path.
+func installFake(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "terraform")
+	if err := os.WriteFile(path, []byte(fakeTerraform), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fakeCalls returns the calls the fake terraform recorded.
+func fakeCalls(t *testing.T, binary string) []string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(binary), "calls.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes, terraform's
+// stdout and stderr are copied from goroutines of their own.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newFakeRunner returns a runner of the actions in a work dir of its own,
+// running the fake terraform at binary.
+func newFakeRunner(t *testing.T, binary string, actions ...string) (*TerraformRunner, *syncBuffer) {
+	t.Helper()
+	config := NewDriverConfig(actions, "", t.TempDir())
+	config.BinaryPath = binary
+	config.InstallDir = t.TempDir()
+	config.RunsDir = t.TempDir()
+	config.OverrideDir = filepath.Join(t.TempDir(), "overrides")
+	config.ContextDataDir = filepath.Join(t.TempDir(), "contextdata")
+
+	out := &syncBuffer{}
+	runner := NewTerraformRunner(slog.New(slog.NewTextHandler(io.Discard, nil)), config)
+	runner.SetStdout(out)
+	runner.SetStderr(out)
+	return runner, out
+}
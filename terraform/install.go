@@ -0,0 +1,164 @@
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	// BinaryPathEnv names a preinstalled terraform binary to use instead of
+	// installing one.
+	BinaryPathEnv = "GINIE_TERRAFORM_BINARY"
+	// InstallDirEnv overrides the directory installed binaries are cached in.
+	InstallDirEnv = "GINIE_TERRAFORM_INSTALL_DIR"
+)
+
+// cachedInstaller installs terraform, or OpenTofu, into a directory per
+// engine and version and reuses the binary found there, so only the first run
+// of a version needs the network.
+type cachedInstaller struct {
+	logger *slog.Logger
+	engine *engine
+	// version is resolved by resolveVersion, see DriverConfig.Version
+	version    string
+	workDir    string
+	installDir string
+	// binaryPath is a preinstalled binary taking precedence over the cache
+	binaryPath string
+}
+
+func newCachedInstaller(logger *slog.Logger, e *engine, spec, workDir, installDir, binaryPath string) *cachedInstaller {
+	return &cachedInstaller{
+		logger:     logger,
+		engine:     e,
+		version:    spec,
+		workDir:    workDir,
+		installDir: installDir,
+		binaryPath: binaryPath,
+	}
+}
+
+func (i *cachedInstaller) Install(ctx context.Context) (string, error) {
+	if i.binaryPath != "" {
+		if !fileExists(i.binaryPath) {
+			return "", fmt.Errorf("%s binary %s not found", i.engine.binary, i.binaryPath)
+		}
+		i.logger.Debug("using preinstalled binary", slog.String("engine", i.engine.binary), slog.String("path", i.binaryPath))
+		return i.binaryPath, nil
+	}
+
+	spec := versionSpec(i.version, i.workDir)
+	switch spec {
+	case VersionPath:
+		return i.findOnPath(ctx)
+	case "":
+		// a binary on PATH meeting the program's constraints saves a
+		// download
+		if execPath, ok := i.usablePathBinary(ctx); ok {
+			i.logger.Debug("using binary from PATH", slog.String("engine", i.engine.binary), slog.String("path", execPath))
+			return execPath, nil
+		}
+	}
+
+	v, err := i.resolveVersion(ctx, spec)
+	if err != nil {
+		return "", err
+	}
+	return i.installVersion(ctx, v)
+}
+
+// usablePathBinary returns the engine's binary on PATH if it meets the
+// program's required_version.
+func (i *cachedInstaller) usablePathBinary(ctx context.Context) (string, bool) {
+	execPath, err := i.findOnPath(ctx)
+	if err != nil {
+		return "", false
+	}
+	constraints, err := RequiredVersion(i.workDir)
+	if err != nil {
+		return "", false
+	}
+	v, err := binaryVersion(ctx, execPath)
+	if err != nil || !constraints.Check(v) {
+		return "", false
+	}
+	return execPath, true
+}
+
+// installVersion returns the cached binary of the version, downloading it
+// first if it is not cached yet.
+func (i *cachedInstaller) installVersion(ctx context.Context, v *version.Version) (string, error) {
+	dir, err := filepath.Abs(filepath.Join(i.cacheDir(), v.String()))
+	if err != nil {
+		return "", err
+	}
+	execPath := filepath.Join(dir, i.engine.binary)
+
+	if cached, err := binaryVersion(ctx, execPath); err == nil && cached.Equal(v) {
+		i.logger.Debug("using cached binary", slog.String("engine", i.engine.binary), slog.String("path", execPath))
+		return execPath, nil
+	}
+
+	if err := createDir(dir, 0o755); err != nil {
+		return "", err
+	}
+	// concurrent runs each download into a directory of their own and
+	// replace the cached binary atomically
+	tmpDir, err := os.MkdirTemp(dir, ".install-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	downloaded, err := i.engine.download(ctx, v, tmpDir)
+	if err != nil {
+		return "", err
+	}
+
+	tmpPath := filepath.Join(tmpDir, i.engine.binary+".cached")
+	if err := copyTFBinary(downloaded, tmpPath); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, execPath); err != nil {
+		return "", err
+	}
+	i.logger.Debug("cached binary", slog.String("engine", i.engine.binary), slog.String("path", execPath))
+	return execPath, nil
+}
+
+// cacheDir is where the versions of the engine are cached.
+func (i *cachedInstaller) cacheDir() string {
+	return filepath.Join(i.installDir, i.engine.binary)
+}
+
+// Remove keeps the cached binary for the next run.
+func (i *cachedInstaller) Remove(context.Context) error {
+	return nil
+}
+
+// binaryVersion returns the version of the terraform or OpenTofu binary at
+// path, both report it the same way.
+func binaryVersion(ctx context.Context, path string) (*version.Version, error) {
+	if !fileExists(path) {
+		return nil, os.ErrNotExist
+	}
+
+	out, err := exec.CommandContext(ctx, path, "version", "-json").Output()
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		Version string `json:"terraform_version"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return nil, err
+	}
+	return version.NewVersion(v.Version)
+}
@@ -0,0 +1,235 @@
+package terraform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+var errOffline = errors.New("no network")
+
+// fakeEngine releases versions of a terraform that only reports its version.
+// It counts its downloads, or lists no releases at all if offline.
+type fakeEngine struct {
+	mu        sync.Mutex
+	releases  []string
+	offline   bool
+	downloads []string
+	// slow makes a download take a while, written in two halves
+	slow time.Duration
+}
+
+func (f *fakeEngine) engine() *engine {
+	return &engine{
+		name:   "Fake",
+		binary: "terraform",
+		releases: func(context.Context) ([]*version.Version, error) {
+			if f.offline {
+				return nil, errOffline
+			}
+			var versions []*version.Version
+			for _, v := range f.releases {
+				versions = append(versions, version.Must(version.NewVersion(v)))
+			}
+			return versions, nil
+		},
+		download: func(_ context.Context, v *version.Version, dir string) (string, error) {
+			f.mu.Lock()
+			f.downloads = append(f.downloads, v.String())
+			f.mu.Unlock()
+
+			path := filepath.Join(dir, "terraform")
+			script := versionScript(v.String())
+			if err := os.WriteFile(path, []byte(script[:len(script)/2]), 0755); err != nil {
+				return "", err
+			}
+			time.Sleep(f.slow)
+			return path, os.WriteFile(path, []byte(script), 0755)
+		},
+	}
+}
+
+func (f *fakeEngine) downloaded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.downloads...)
+}
+
+func versionScript(v string) string {
+	return fmt.Sprintf("#!/bin/sh\necho '{\"terraform_version\":\"%s\",\"platform\":\"linux_amd64\"}'\n", v)
+}
+
+func newTestInstaller(e *engine, spec, workDir, installDir string) *cachedInstaller {
+	return newCachedInstaller(slog.New(slog.NewTextHandler(io.Discard, nil)), e, spec, workDir, installDir, "")
+}
+
+// installed checks that path is the cached binary of version v in
+// installDir, without downloads left next to it.
+func installed(t *testing.T, path, installDir, v string) {
+	t.Helper()
+	if want := filepath.Join(installDir, "terraform", v, "terraform"); path != want {
+		t.Fatalf("got %s, want %s", path, want)
+	}
+	got, err := binaryVersion(context.Background(), path)
+	if err != nil || got.String() != v {
+		t.Fatalf("installed version %v, %v, want %s", got, err, v)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".install-") {
+			t.Errorf("left download %s", e.Name())
+		}
+	}
+}
+
+func TestInstallCache(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeEngine{}
+	installDir := t.TempDir()
+
+	path, err := newTestInstaller(fake.engine(), "1.6.0", t.TempDir(), installDir).Install(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed(t, path, installDir, "1.6.0")
+
+	// another run, in another work dir
+	again, err := newTestInstaller(fake.engine(), "1.6.0", t.TempDir(), installDir).Install(ctx)
+	if err != nil || again != path {
+		t.Fatalf("got %s, %v, want the cached %s", again, err, path)
+	}
+	if got := fake.downloaded(); len(got) != 1 {
+		t.Fatalf("downloaded %v, want 1.6.0 once", got)
+	}
+
+	other, err := newTestInstaller(fake.engine(), "1.7.0", t.TempDir(), installDir).Install(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed(t, other, installDir, "1.7.0")
+
+	// a broken binary in the cache is replaced
+	if err := os.WriteFile(path, []byte(versionScript("1.5.0")), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if path, err = newTestInstaller(fake.engine(), "1.6.0", t.TempDir(), installDir).Install(ctx); err != nil {
+		t.Fatal(err)
+	}
+	installed(t, path, installDir, "1.6.0")
+	if got, want := fake.downloaded(), []string{"1.6.0", "1.7.0", "1.6.0"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("downloaded %v, want %v", got, want)
+	}
+}
+
+func TestInstallPreinstalled(t *testing.T) {
+	fake := &fakeEngine{}
+	binary := filepath.Join(t.TempDir(), "terraform")
+	if err := os.WriteFile(binary, []byte(versionScript("1.2.0")), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	installer := newCachedInstaller(slog.New(slog.NewTextHandler(io.Discard, nil)), fake.engine(), "1.6.0", t.TempDir(), t.TempDir(), binary)
+	if path, err := installer.Install(context.Background()); err != nil || path != binary {
+		t.Fatalf("got %s, %v, want %s", path, err, binary)
+	}
+	if got := fake.downloaded(); len(got) != 0 {
+		t.Errorf("downloaded %v", got)
+	}
+
+	installer.binaryPath = filepath.Join(t.TempDir(), "missing")
+	if _, err := installer.Install(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got %v, want the binary not found", err)
+	}
+}
+
+// installDelay is how long a download of TestInstallProcesses takes.
+const installDelay = 400 * time.Millisecond
+
+// TestInstallProcesses installs the same version from two processes at once,
+// each downloading it slowly, and checks both end up with a complete binary.
+func TestInstallProcesses(t *testing.T) {
+	installDir := t.TempDir()
+	path := filepath.Join(installDir, "terraform", "1.6.0", "terraform")
+
+	// the cached binary is complete whenever it is there
+	done := make(chan struct{})
+	partial := make(chan string, 1)
+	go func() {
+		defer close(partial)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			if b, err := os.ReadFile(path); err == nil && string(b) != versionScript("1.6.0") {
+				partial <- string(b)
+				return
+			}
+		}
+	}()
+
+	var cmds []*exec.Cmd
+	var outs []*strings.Builder
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestInstallProcess$")
+		cmd.Env = append(os.Environ(), "GINIE_TEST_INSTALL_DIR="+installDir)
+		out := &strings.Builder{}
+		cmd.Stdout, cmd.Stderr = out, out
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+		outs = append(outs, out)
+		// the second one starts while the first one is still downloading
+		time.Sleep(installDelay / 2)
+	}
+	for i, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("process %d: %s\n%s", i+1, err, outs[i])
+		}
+	}
+	close(done)
+	if b, ok := <-partial; ok {
+		t.Fatalf("partial binary cached: %q", b)
+	}
+
+	for i, out := range outs {
+		if !strings.Contains(out.String(), "installed "+path+"\n") {
+			t.Errorf("process %d: %s", i+1, out)
+		}
+	}
+	installed(t, path, installDir, "1.6.0")
+}
+
+// TestInstallProcess is a process of TestInstallProcesses.
+func TestInstallProcess(t *testing.T) {
+	installDir := os.Getenv("GINIE_TEST_INSTALL_DIR")
+	if installDir == "" {
+		t.Skip("run by TestInstallProcesses")
+	}
+	fake := &fakeEngine{slow: installDelay}
+	path, err := newTestInstaller(fake.engine(), "1.6.0", t.TempDir(), installDir).Install(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the other process may still be downloading
+	if v, err := binaryVersion(context.Background(), path); err != nil || v.String() != "1.6.0" {
+		t.Fatalf("installed version %v, %v", v, err)
+	}
+	fmt.Printf("installed %s\n", path)
+}
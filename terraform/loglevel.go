@@ -0,0 +1,82 @@
+package terraform
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+const (
+	// LogLevelEnv sets the level of Ginie's own logs: debug, info, warn or
+	// error.
+	LogLevelEnv = "GINIE_LOG_LEVEL"
+	// TerraformLogEnv and ProviderLogEnv set the levels of terraform's log,
+	// named like terraform's own variables.
+	TerraformLogEnv = "TF_LOG"
+	ProviderLogEnv  = "TF_LOG_PROVIDER"
+
+	defaultLogLevel          = "info"
+	defaultTerraformLogLevel = "INFO"
+	defaultProviderLogLevel  = "WARN"
+
+	// logLevelOff turns terraform's log off
+	logLevelOff = "OFF"
+	// logLevelJSON is a TRACE log of terraform core and providers as JSON
+	logLevelJSON = "JSON"
+)
+
+// terraformLogLevels are the levels terraform accepts for TF_LOG_PROVIDER,
+// TF_LOG also takes JSON.
+var terraformLogLevels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", logLevelOff}
+
+// SlogLevel returns the level of Ginie's own logs.
+func (d *DriverConfig) SlogLevel() slog.Level {
+	level, err := parseLogLevel(d.LogLevel)
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level %q, use debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// validateLogLevels checks the levels against those Ginie and terraform
+// accept.
+func (d *DriverConfig) validateLogLevels() error {
+	if _, err := parseLogLevel(d.LogLevel); err != nil {
+		return err
+	}
+
+	core := strings.ToUpper(d.TerraformLogLevel)
+	if !slices.Contains(terraformLogLevels, core) && core != logLevelJSON {
+		return fmt.Errorf("invalid terraform log level %q, use one of %s or %s", d.TerraformLogLevel, strings.Join(terraformLogLevels, ", "), logLevelJSON)
+	}
+	if provider := strings.ToUpper(d.ProviderLogLevel); !slices.Contains(terraformLogLevels, provider) {
+		return fmt.Errorf("invalid provider log level %q, use one of %s", d.ProviderLogLevel, strings.Join(terraformLogLevels, ", "))
+	}
+	return nil
+}
+
+// terraformLogEnv maps the levels to terraform's TF_LOG, TF_LOG_CORE and
+// TF_LOG_PROVIDER. JSON logs everything as JSON and ignores the provider
+// level, OFF leaves the respective log out.
+func (d *DriverConfig) terraformLogEnv() (log, core, provider string) {
+	coreLevel := strings.ToUpper(d.TerraformLogLevel)
+	if coreLevel == logLevelJSON {
+		return logLevelJSON, "", ""
+	}
+	if coreLevel != logLevelOff {
+		core = coreLevel
+	}
+	if providerLevel := strings.ToUpper(d.ProviderLogLevel); providerLevel != logLevelOff {
+		provider = providerLevel
+	}
+	return "", core, provider
+}
@@ -0,0 +1,129 @@
+package terraform
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLevel(t *testing.T) {
+	tests := []struct {
+		level string
+		want  slog.Level
+	}{
+		{"debug", slog.LevelDebug},
+		{"INFO", slog.LevelInfo},
+		{"warn", slog.LevelWarn},
+		{"Error", slog.LevelError},
+		{"debug+2", slog.LevelDebug + 2},
+		// an invalid level is refused by Validate, and falls back to info
+		{"trace", slog.LevelInfo},
+		{"", slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		d := &DriverConfig{LogLevel: tt.level}
+		if got := d.SlogLevel(); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestTerraformLogLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		core     string
+		provider string
+		// log, core and provider are TF_LOG, TF_LOG_CORE and TF_LOG_PROVIDER
+		log, wantCore, wantProvider string
+		err                         string
+	}{
+		{name: "defaults", core: defaultTerraformLogLevel, provider: defaultProviderLogLevel, wantCore: "INFO", wantProvider: "WARN"},
+		{name: "lower case", core: "trace", provider: "debug", wantCore: "TRACE", wantProvider: "DEBUG"},
+		{name: "core off", core: "off", provider: "ERROR", wantProvider: "ERROR"},
+		{name: "provider off", core: "WARN", provider: "OFF", wantCore: "WARN"},
+		{name: "off", core: "OFF", provider: "OFF"},
+		{name: "json", core: "json", provider: "ERROR", log: "JSON"},
+		{name: "invalid core", core: "VERBOSE", provider: "WARN", err: `invalid terraform log level "VERBOSE", use one of TRACE, DEBUG, INFO, WARN, ERROR, OFF or JSON`},
+		{name: "empty core", core: "", provider: "WARN", err: `invalid terraform log level ""`},
+		{name: "json provider", core: "INFO", provider: "JSON", err: `invalid provider log level "JSON", use one of TRACE, DEBUG, INFO, WARN, ERROR, OFF`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DriverConfig{LogLevel: "info", TerraformLogLevel: tt.core, ProviderLogLevel: tt.provider}
+			err := d.validateLogLevels()
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			log, core, provider := d.terraformLogEnv()
+			if log != tt.log || core != tt.wantCore || provider != tt.wantProvider {
+				t.Errorf("got %q, %q, %q, want %q, %q, %q", log, core, provider, tt.log, tt.wantCore, tt.wantProvider)
+			}
+		})
+	}
+
+	d := &DriverConfig{LogLevel: "verbose", TerraformLogLevel: "INFO", ProviderLogLevel: "WARN"}
+	if err := d.validateLogLevels(); err == nil || err.Error() != `invalid log level "verbose", use debug, info, warn or error` {
+		t.Errorf("got %v for an invalid log level", err)
+	}
+}
+
+func TestLogLevelsFromEnv(t *testing.T) {
+	for _, key := range []string{LogLevelEnv, TerraformLogEnv, ProviderLogEnv} {
+		t.Setenv(key, "")
+	}
+	d := NewDriverConfig([]string{Init}, "", t.TempDir())
+	if d.LogLevel != defaultLogLevel || d.TerraformLogLevel != defaultTerraformLogLevel || d.ProviderLogLevel != defaultProviderLogLevel {
+		t.Errorf("got levels %s, %s, %s by default", d.LogLevel, d.TerraformLogLevel, d.ProviderLogLevel)
+	}
+
+	t.Setenv(LogLevelEnv, "debug")
+	t.Setenv(TerraformLogEnv, "TRACE")
+	t.Setenv(ProviderLogEnv, "OFF")
+	d = NewDriverConfig([]string{Init}, "", t.TempDir())
+	if d.LogLevel != "debug" || d.TerraformLogLevel != "TRACE" || d.ProviderLogLevel != "OFF" {
+		t.Errorf("got levels %s, %s, %s from the env", d.LogLevel, d.TerraformLogLevel, d.ProviderLogLevel)
+	}
+}
+
+// TestRunLogLevels checks the levels terraform runs with.
+func TestRunLogLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		core     string
+		provider string
+		want     string
+		err      string
+	}{
+		{name: "levels", core: "debug", provider: "WARN", want: "level=DEBUG"},
+		// without a log path terraform does not log
+		{name: "off", core: "OFF", provider: "OFF", want: "log= level="},
+		{name: "invalid", core: "LOUD", provider: "WARN", err: "error setting terraform logger: invalid terraform log level"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, out := newFakeRunner(t, installFake(t), Init)
+			runner.TerraformLogLevel = tt.core
+			runner.ProviderLogLevel = tt.provider
+			err := runner.Execute(context.Background())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s\n%s", err, out)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("got %s, want %s", out, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package terraform
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	defaultRunsDir      = "runs"
+	defaultLogRetention = 20
+
+	// TerraformLogFile is terraform's own log, TF_LOG
+	TerraformLogFile = "terraform.log"
+	// GinieLogFile is the runner's structured log
+	GinieLogFile = "ginie.log"
+	// OutputLogFile is what the run printed
+	OutputLogFile = "output.log"
+)
+
+// newRunID returns an id for a run, sortable by the time it started.
+func newRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// RunDir returns the directory the logs of the run are written to.
+func (t *TerraformRunner) RunDir() string {
+	runsDir := t.RunsDir
+	if runsDir == "" {
+		runsDir = filepath.Join(t.workDir, defaultRunsDir)
+	}
+	return filepath.Join(runsDir, t.RunID)
+}
+
+// openRunLogs creates the log dir of the run and sends the runner's own logs
+// and the output of terraform there as well. The logs of runs beyond the
+// retention are removed. The returned func closes the logs.
+func (t *TerraformRunner) openRunLogs() (func(), error) {
+	if t.RunID == "" {
+		t.RunID = newRunID()
+	}
+	dir := t.RunDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ginieLog, err := os.Create(filepath.Join(dir, GinieLogFile))
+	if err != nil {
+		return nil, err
+	}
+	outputLog, err := os.Create(filepath.Join(dir, OutputLogFile))
+	if err != nil {
+		ginieLog.Close()
+		return nil, err
+	}
+
+	t.logger = slog.New(teeHandler{
+		t.logger.Handler(),
+		slog.NewJSONHandler(ginieLog, &slog.HandlerOptions{Level: t.SlogLevel()}),
+	}).With(slog.String("run", t.RunID))
+	t.tfLog = newTfLogger(t.logger)
+	t.stdout = io.MultiWriter(t.stdout, outputLog)
+	t.stderr = io.MultiWriter(t.stderr, outputLog)
+
+	if err := pruneRuns(filepath.Dir(dir), t.RunID, t.LogRetention, t.LogMaxAge); err != nil {
+		t.logger.Warn("error removing old run logs", "error", err)
+	}
+
+	return func() {
+		ginieLog.Close()
+		outputLog.Close()
+	}, nil
+}
+
+// pruneRuns removes the log dirs of all but the newest retain runs, and of
+// runs older than maxAge if it is set.
+func pruneRuns(runsDir, current string, retain int, maxAge time.Duration) error {
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		return err
+	}
+
+	type run struct {
+		name    string
+		modTime time.Time
+	}
+	var runs []run
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == current {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		runs = append(runs, run{e.Name(), info.ModTime()})
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].modTime.After(runs[j].modTime)
+	})
+
+	var errs []error
+	for i, r := range runs {
+		// the current run counts against the retention
+		expired := retain > 0 && i+1 >= retain
+		if maxAge > 0 && time.Since(r.modTime) > maxAge {
+			expired = true
+		}
+		if expired {
+			errs = append(errs, os.RemoveAll(filepath.Join(runsDir, r.name)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// teeHandler sends log records to all of its handlers.
+type teeHandler []slog.Handler
+
+func (h teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
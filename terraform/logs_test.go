@@ -0,0 +1,119 @@
+package terraform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneRuns(t *testing.T) {
+	// run-1 is the oldest, the current run the newest
+	runs := []string{"run-1", "run-2", "run-3", "run-4", "current"}
+	tests := []struct {
+		name   string
+		retain int
+		maxAge time.Duration
+		want   []string
+	}{
+		{name: "retain all", want: runs},
+		{name: "retain 3", retain: 3, want: []string{"run-3", "run-4", "current"}},
+		{name: "retain 1", retain: 1, want: []string{"current"}},
+		{name: "retain more than there are", retain: 10, want: runs},
+		{name: "max age", maxAge: 210 * time.Minute, want: []string{"run-3", "run-4", "current"}},
+		{name: "max age within the retention", retain: 4, maxAge: 210 * time.Minute, want: []string{"run-3", "run-4", "current"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			now := time.Now()
+			for i, run := range runs {
+				path := filepath.Join(dir, run)
+				if err := os.Mkdir(path, 0755); err != nil {
+					t.Fatal(err)
+				}
+				// an hour apart, the current run without a time of its own
+				if run != "current" {
+					modTime := now.Add(-time.Duration(len(runs)-i) * time.Hour)
+					if err := os.Chtimes(path, modTime, modTime); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			// not a run
+			writeFiles(t, dir, map[string]string{"notes.txt": ""})
+
+			if err := pruneRuns(dir, "current", tt.retain, tt.maxAge); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, run := range runs {
+				if fileExists(filepath.Join(dir, run)) {
+					got = append(got, run)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !fileExists(filepath.Join(dir, "notes.txt")) {
+				t.Error("removed a file that is not a run")
+			}
+		})
+	}
+}
+
+// TestRunLogs runs twice with a retention of one run and checks the logs the
+// last run leaves.
+func TestRunLogs(t *testing.T) {
+	binary := installFake(t)
+	runsDir := t.TempDir()
+
+	var runDirs []string
+	for i := 0; i < 2; i++ {
+		runner, out := newFakeRunner(t, binary, Init)
+		runner.RunsDir = runsDir
+		runner.LogRetention = 1
+		runner.TerraformLogLevel = "INFO"
+		if err := runner.Execute(context.Background()); err != nil {
+			t.Fatalf("%s\n%s", err, out)
+		}
+		runDirs = append(runDirs, runner.RunDir())
+	}
+
+	if runDirs[0] == runDirs[1] {
+		t.Fatalf("both runs logged to %s", runDirs[0])
+	}
+	if got := readDir(t, runsDir); len(got) != 4 || got[0] != filepath.Base(runDirs[1]) {
+		t.Fatalf("got %v, want the logs of the last run", got)
+	}
+
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(runDirs[1], name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := read(OutputLogFile); !strings.Contains(got, "level=INFO") {
+		t.Errorf("output log has %q, want the output of init", got)
+	}
+	if got := read(GinieLogFile); !strings.Contains(got, `"run":"`+filepath.Base(runDirs[1])+`"`) {
+		t.Errorf("ginie log has %q, want records of the run", got)
+	}
+	if got := read(TerraformLogFile); !strings.HasSuffix(got, "[INFO] init\n") {
+		t.Errorf("terraform log has %q", got)
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	a, b := newRunID(), newRunID()
+	if a == b {
+		t.Errorf("got %s twice", a)
+	}
+	if _, err := time.Parse("20060102T150405", strings.SplitN(a, "-", 2)[0]); err != nil {
+		t.Errorf("%s does not start with the time: %s", a, err)
+	}
+}
@@ -61,6 +61,10 @@ func (d *DriverConfig) GetPlanOptions() []tfexec.PlanOption {
 		planOptions = append(planOptions, tfexec.Refresh(d.Refresh))
 	}
 
+	if d.RefreshOnly {
+		planOptions = append(planOptions, tfexec.RefreshOnly(d.RefreshOnly))
+	}
+
 	if d.Destroy {
 		planOptions = append(planOptions, tfexec.Destroy(d.Destroy))
 	}
@@ -88,7 +92,39 @@ func (d *DriverConfig) GetForceUnlockOptions() []tfexec.ForceUnlockOption {
 	return forceUnlockOptions
 }
 
+// GetPlanApplyOptions applies the saved plan as it is. Terraform takes the
+// variables, targets and replacements from the plan and rejects them on the
+// command line.
+func (d *DriverConfig) GetPlanApplyOptions(planFile string) []tfexec.ApplyOption {
+	applyOptions := []tfexec.ApplyOption{tfexec.DirOrPlan(planFile)}
+
+	if d.Backup != "" {
+		applyOptions = append(applyOptions, tfexec.Backup(d.Backup))
+	}
+
+	if !d.Lock {
+		applyOptions = append(applyOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		applyOptions = append(applyOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	if d.Parallelism != defaultParallelism {
+		applyOptions = append(applyOptions, tfexec.Parallelism(d.Parallelism))
+	}
+
+	if d.StateOut != "" {
+		applyOptions = append(applyOptions, tfexec.StateOut(d.StateOut))
+	}
+	return applyOptions
+}
+
 func (d *DriverConfig) GetApplyOptions() []tfexec.ApplyOption {
+	if d.PlanFile != "" {
+		return d.GetPlanApplyOptions(d.PlanFile)
+	}
+
 	var applyOptions []tfexec.ApplyOption
 
 	for i := range d.Target {
@@ -103,10 +139,6 @@ func (d *DriverConfig) GetApplyOptions() []tfexec.ApplyOption {
 		applyOptions = append(applyOptions, tfexec.VarFile(d.VarFile[i]))
 	}
 
-	if d.PlanFile != "" {
-		applyOptions = append(applyOptions, tfexec.DirOrPlan(d.PlanFile))
-	}
-
 	if !d.Refresh {
 		applyOptions = append(applyOptions, tfexec.Refresh(d.Refresh))
 	}
@@ -179,3 +211,249 @@ func (d *DriverConfig) GetDestroyOptions() []tfexec.DestroyOption {
 
 	return destroyOptions
 }
+
+func (d *DriverConfig) GetWorkspaceNewOptions() []tfexec.WorkspaceNewCmdOption {
+	var workspaceNewOptions []tfexec.WorkspaceNewCmdOption
+
+	if !d.Lock {
+		workspaceNewOptions = append(workspaceNewOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		workspaceNewOptions = append(workspaceNewOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	return workspaceNewOptions
+}
+
+func (d *DriverConfig) GetWorkspaceDeleteOptions() []tfexec.WorkspaceDeleteCmdOption {
+	var workspaceDeleteOptions []tfexec.WorkspaceDeleteCmdOption
+
+	if d.Force {
+		workspaceDeleteOptions = append(workspaceDeleteOptions, tfexec.Force(d.Force))
+	}
+
+	if !d.Lock {
+		workspaceDeleteOptions = append(workspaceDeleteOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		workspaceDeleteOptions = append(workspaceDeleteOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	return workspaceDeleteOptions
+}
+
+func (d *DriverConfig) GetFormatOptions() []tfexec.FormatOption {
+	var formatOptions []tfexec.FormatOption
+
+	if d.Recursive {
+		formatOptions = append(formatOptions, tfexec.Recursive(d.Recursive))
+	}
+
+	return formatOptions
+}
+
+func (d *DriverConfig) GetRefreshOptions() []tfexec.RefreshCmdOption {
+	var refreshOptions []tfexec.RefreshCmdOption
+
+	for i := range d.Target {
+		refreshOptions = append(refreshOptions, tfexec.Target(d.Target[i]))
+	}
+
+	for i := range d.Var {
+		refreshOptions = append(refreshOptions, tfexec.Var(d.Var[i]))
+	}
+
+	for i := range d.VarFile {
+		refreshOptions = append(refreshOptions, tfexec.VarFile(d.VarFile[i]))
+	}
+
+	if d.Backup != "" {
+		refreshOptions = append(refreshOptions, tfexec.Backup(d.Backup))
+	}
+
+	if !d.Lock {
+		refreshOptions = append(refreshOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		refreshOptions = append(refreshOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	if d.StateOut != "" {
+		refreshOptions = append(refreshOptions, tfexec.StateOut(d.StateOut))
+	}
+
+	return refreshOptions
+}
+
+func (d *DriverConfig) GetImportOptions() []tfexec.ImportOption {
+	var importOptions []tfexec.ImportOption
+
+	for i := range d.Var {
+		importOptions = append(importOptions, tfexec.Var(d.Var[i]))
+	}
+
+	for i := range d.VarFile {
+		importOptions = append(importOptions, tfexec.VarFile(d.VarFile[i]))
+	}
+
+	if d.Backup != "" {
+		importOptions = append(importOptions, tfexec.Backup(d.Backup))
+	}
+
+	if d.AllowMissing {
+		importOptions = append(importOptions, tfexec.AllowMissingConfig(d.AllowMissing))
+	}
+
+	if !d.Lock {
+		importOptions = append(importOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		importOptions = append(importOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	if d.StateOut != "" {
+		importOptions = append(importOptions, tfexec.StateOut(d.StateOut))
+	}
+
+	return importOptions
+}
+
+func (d *DriverConfig) GetStateRmOptions() []tfexec.StateRmCmdOption {
+	var stateRmOptions []tfexec.StateRmCmdOption
+
+	if d.Backup != "" {
+		stateRmOptions = append(stateRmOptions, tfexec.Backup(d.Backup))
+	}
+
+	if d.BackupOut != "" {
+		stateRmOptions = append(stateRmOptions, tfexec.BackupOut(d.BackupOut))
+	}
+
+	if d.DryRun {
+		stateRmOptions = append(stateRmOptions, tfexec.DryRun(d.DryRun))
+	}
+
+	if !d.Lock {
+		stateRmOptions = append(stateRmOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		stateRmOptions = append(stateRmOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	if d.StateOut != "" {
+		stateRmOptions = append(stateRmOptions, tfexec.StateOut(d.StateOut))
+	}
+
+	return stateRmOptions
+}
+
+func (d *DriverConfig) GetStateMvOptions() []tfexec.StateMvCmdOption {
+	var stateMvOptions []tfexec.StateMvCmdOption
+
+	if d.Backup != "" {
+		stateMvOptions = append(stateMvOptions, tfexec.Backup(d.Backup))
+	}
+
+	if d.BackupOut != "" {
+		stateMvOptions = append(stateMvOptions, tfexec.BackupOut(d.BackupOut))
+	}
+
+	if d.DryRun {
+		stateMvOptions = append(stateMvOptions, tfexec.DryRun(d.DryRun))
+	}
+
+	if !d.Lock {
+		stateMvOptions = append(stateMvOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		stateMvOptions = append(stateMvOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	if d.StateOut != "" {
+		stateMvOptions = append(stateMvOptions, tfexec.StateOut(d.StateOut))
+	}
+
+	return stateMvOptions
+}
+
+func (d *DriverConfig) GetTaintOptions() []tfexec.TaintOption {
+	var taintOptions []tfexec.TaintOption
+
+	if d.AllowMissing {
+		taintOptions = append(taintOptions, tfexec.AllowMissing(d.AllowMissing))
+	}
+
+	if !d.Lock {
+		taintOptions = append(taintOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		taintOptions = append(taintOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	return taintOptions
+}
+
+func (d *DriverConfig) GetUntaintOptions() []tfexec.UntaintOption {
+	var untaintOptions []tfexec.UntaintOption
+
+	if d.AllowMissing {
+		untaintOptions = append(untaintOptions, tfexec.AllowMissing(d.AllowMissing))
+	}
+
+	if !d.Lock {
+		untaintOptions = append(untaintOptions, tfexec.Lock(d.Lock))
+	}
+
+	if d.LockTimeout != defaultLockTimeout {
+		untaintOptions = append(untaintOptions, tfexec.LockTimeout(d.LockTimeout))
+	}
+
+	return untaintOptions
+}
+
+func (d *DriverConfig) GetGraphOptions() []tfexec.GraphOption {
+	var graphOptions []tfexec.GraphOption
+
+	if d.PlanFile != "" {
+		graphOptions = append(graphOptions, tfexec.GraphPlan(d.PlanFile))
+	}
+
+	if d.DrawCycles {
+		graphOptions = append(graphOptions, tfexec.DrawCycles(d.DrawCycles))
+	}
+
+	if d.GraphType != "" {
+		graphOptions = append(graphOptions, tfexec.GraphType(d.GraphType))
+	}
+
+	return graphOptions
+}
+
+func (d *DriverConfig) GetProvidersLockOptions() []tfexec.ProvidersLockOption {
+	var providersLockOptions []tfexec.ProvidersLockOption
+
+	if d.FSMirror != "" {
+		providersLockOptions = append(providersLockOptions, tfexec.FSMirror(d.FSMirror))
+	}
+
+	if d.NetMirror != "" {
+		providersLockOptions = append(providersLockOptions, tfexec.NetMirror(d.NetMirror))
+	}
+
+	for i := range d.Platform {
+		providersLockOptions = append(providersLockOptions, tfexec.Platform(d.Platform[i]))
+	}
+
+	for i := range d.Provider {
+		providersLockOptions = append(providersLockOptions, tfexec.Provider(d.Provider[i]))
+	}
+
+	return providersLockOptions
+}
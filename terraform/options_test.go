@@ -0,0 +1,269 @@
+package terraform
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestCommandOptions runs every command with options set and checks the
+// command line terraform gets.
+func TestCommandOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		actions   []string
+		configure func(*DriverConfig)
+		// command is the first words of the command line checked
+		command string
+		want    []string
+		// not holds flags that must not be set
+		not []string
+	}{
+		{
+			name:    "init defaults",
+			actions: []string{Init},
+			command: "init",
+			want:    []string{"-backend=true", "-get=true", "-upgrade=false"},
+			not:     []string{"-reconfigure", "-force-copy", "-backend-config", "-from-module"},
+		},
+		{
+			name:    "init",
+			actions: []string{Init},
+			configure: func(d *DriverConfig) {
+				d.Backend = false
+				d.Get = false
+				d.Upgrade = true
+				d.Reconfigure = true
+				d.ForceCopy = true
+				d.BackendConfig = []string{"path=state.tfstate"}
+			},
+			command: "init",
+			want:    []string{"-backend=false", "-get=false", "-upgrade=true", "-reconfigure", "-force-copy", "-backend-config=path=state.tfstate"},
+		},
+		{
+			name:    "plan defaults",
+			actions: []string{Plan},
+			command: "plan",
+			want:    []string{"-out=" + defaultPlanFile, "-json"},
+			not:     []string{"-refresh=false", "-lock=false", "-destroy", "-refresh-only", "-target", "-var", "-replace"},
+		},
+		{
+			name:    "plan",
+			actions: []string{Plan},
+			configure: func(d *DriverConfig) {
+				d.Target = []string{"aws_s3_bucket.logs"}
+				d.Var = []string{"name=logs"}
+				d.VarFile = []string{"prod.tfvars"}
+				d.Replace = []string{"aws_instance.web"}
+				d.Refresh = false
+				d.Lock = false
+				d.LockTimeout = "30s"
+				d.Parallelism = 3
+				d.Destroy = true
+				d.PlanFile = "custom.plan"
+			},
+			command: "plan",
+			want: []string{"-target=aws_s3_bucket.logs", "-var name=logs", "-var-file=prod.tfvars", "-replace=aws_instance.web",
+				"-refresh=false", "-lock=false", "-lock-timeout=30s", "-parallelism=3", "-destroy", "-out=custom.plan"},
+			not: []string{"-out=" + defaultPlanFile},
+		},
+		{
+			name:    "plan refresh only",
+			actions: []string{Plan},
+			configure: func(d *DriverConfig) {
+				d.RefreshOnly = true
+			},
+			command: "plan",
+			want:    []string{"-refresh-only"},
+		},
+		{
+			name:    "apply without a plan",
+			actions: []string{Apply},
+			configure: func(d *DriverConfig) {
+				d.Target = []string{"aws_s3_bucket.logs"}
+				d.Var = []string{"name=logs"}
+				d.Replace = []string{"aws_instance.web"}
+				d.Backup = "backup.tfstate"
+				d.StateOut = "out.tfstate"
+				d.Parallelism = 5
+			},
+			command: "apply",
+			want:    []string{"-target=aws_s3_bucket.logs", "-var name=logs", "-replace=aws_instance.web", "-backup=backup.tfstate", "-state-out=out.tfstate", "-parallelism=5"},
+		},
+		{
+			name:    "apply of a plan file",
+			actions: []string{Apply},
+			configure: func(d *DriverConfig) {
+				d.PlanFile = "custom.plan"
+				d.Var = []string{"name=logs"}
+				d.Target = []string{"aws_s3_bucket.logs"}
+				d.Lock = false
+			},
+			command: "apply",
+			want:    []string{"-lock=false", "custom.plan"},
+			not:     []string{"-var", "-target"},
+		},
+		{
+			name:    "destroy",
+			actions: []string{Destroy},
+			configure: func(d *DriverConfig) {
+				d.Target = []string{"aws_s3_bucket.logs"}
+				d.VarFile = []string{"prod.tfvars"}
+				d.Refresh = false
+				d.Backup = "backup.tfstate"
+				d.LockTimeout = "1m"
+			},
+			command: "destroy",
+			want:    []string{"-target=aws_s3_bucket.logs", "-var-file=prod.tfvars", "-refresh=false", "-backup=backup.tfstate", "-lock-timeout=1m", "-auto-approve"},
+		},
+		{
+			name:    "refresh",
+			actions: []string{Refresh},
+			configure: func(d *DriverConfig) {
+				d.Target = []string{"aws_s3_bucket.logs"}
+				d.Var = []string{"name=logs"}
+				d.StateOut = "out.tfstate"
+			},
+			command: "refresh",
+			want:    []string{"-target=aws_s3_bucket.logs", "-var name=logs", "-state-out=out.tfstate"},
+		},
+		{
+			name:    "import",
+			actions: []string{Import},
+			configure: func(d *DriverConfig) {
+				d.Address = "aws_s3_bucket.logs"
+				d.ImportID = "logs"
+				d.Var = []string{"name=logs"}
+				d.AllowMissing = true
+			},
+			command: "import",
+			want:    []string{"-var name=logs", "-allow-missing-config", "aws_s3_bucket.logs logs"},
+		},
+		{
+			name:    "state rm",
+			actions: []string{StateRm},
+			configure: func(d *DriverConfig) {
+				d.Address = "aws_s3_bucket.logs"
+				d.BackupOut = "backup.tfstate"
+				d.DryRun = true
+			},
+			command: "state rm",
+			want:    []string{"-backup-out=backup.tfstate", "-dry-run", "aws_s3_bucket.logs"},
+		},
+		{
+			name:    "state mv",
+			actions: []string{StateMv},
+			configure: func(d *DriverConfig) {
+				d.Address = "aws_s3_bucket.logs"
+				d.Destination = "aws_s3_bucket.archive"
+				d.Lock = false
+			},
+			command: "state mv",
+			want:    []string{"-lock=false", "aws_s3_bucket.logs aws_s3_bucket.archive"},
+			not:     []string{"-dry-run"},
+		},
+		{
+			name:    "taint",
+			actions: []string{Taint},
+			configure: func(d *DriverConfig) {
+				d.Address = "aws_instance.web"
+				d.AllowMissing = true
+			},
+			command: "taint",
+			want:    []string{"-allow-missing", "aws_instance.web"},
+		},
+		{
+			name:    "untaint",
+			actions: []string{Untaint},
+			configure: func(d *DriverConfig) {
+				d.Address = "aws_instance.web"
+				d.LockTimeout = "10s"
+			},
+			command: "untaint",
+			want:    []string{"-lock-timeout=10s", "aws_instance.web"},
+			not:     []string{"-allow-missing"},
+		},
+		{
+			name:    "fmt",
+			actions: []string{Fmt},
+			configure: func(d *DriverConfig) {
+				d.Recursive = true
+			},
+			command: "fmt",
+			want:    []string{"-recursive"},
+		},
+		{
+			name:    "graph",
+			actions: []string{Graph},
+			configure: func(d *DriverConfig) {
+				d.GraphType = "plan"
+				d.DrawCycles = true
+			},
+			command: "graph",
+			want:    []string{"-type=plan", "-draw-cycles"},
+		},
+		{
+			name:    "providers lock",
+			actions: []string{ProvidersLock},
+			configure: func(d *DriverConfig) {
+				d.Platform = []string{"linux_amd64", "darwin_arm64"}
+				d.FSMirror = "/mirror"
+				d.Provider = []string{"hashicorp/aws"}
+			},
+			command: "providers lock",
+			want:    []string{"-fs-mirror=/mirror", "-platform=linux_amd64", "-platform=darwin_arm64", "hashicorp/aws"},
+		},
+		{
+			name:    "workspace new",
+			actions: []string{WorkspaceNew},
+			configure: func(d *DriverConfig) {
+				d.Workspace = "staging"
+				d.Lock = false
+			},
+			command: "workspace new",
+			want:    []string{"-lock=false", "staging"},
+		},
+		{
+			name:    "workspace delete",
+			actions: []string{WorkspaceDelete},
+			configure: func(d *DriverConfig) {
+				d.Workspace = "staging"
+				d.Force = true
+			},
+			command: "workspace delete",
+			want:    []string{"-force", "staging"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary := installFake(t)
+			runner, out := newFakeRunner(t, binary, tt.actions...)
+			if tt.configure != nil {
+				tt.configure(runner.DriverConfig)
+			}
+			if err := runner.Execute(context.Background()); err != nil {
+				t.Fatalf("%s\n%s", err, out)
+			}
+
+			var command string
+			for _, call := range fakeCalls(t, binary) {
+				if _, args, ok := strings.Cut(call, ": "); ok && strings.HasPrefix(args, tt.command+" ") {
+					command = args
+				}
+			}
+			if command == "" {
+				t.Fatalf("no %s in %q", tt.command, fakeCalls(t, binary))
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(command, want) {
+					t.Errorf("%s misses %s", command, want)
+				}
+			}
+			for _, not := range tt.not {
+				if strings.Contains(command, not) {
+					t.Errorf("%s has %s", command, not)
+				}
+			}
+		})
+	}
+}
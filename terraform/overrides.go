@@ -1,21 +1,131 @@
 package terraform
 
 import (
+	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
-func copyOverrides(src, dst string) error {
-	srcPath, err := filepath.Abs(src)
+// contextDataDir is where the context data is made available to the program,
+// relative to the work dir, e.g. file("${path.module}/contextdata/creds.json").
+const contextDataDir = "contextdata"
+
+// overridesFile lists the override files copied into the work dir. It is
+// removed after them, so the ones a run that did not get to clean up left
+// behind are known to be Ginie's.
+const overridesFile = ".ginie-overrides"
+
+// applyOverrides layers the files of the override dir, e.g. provider pinning
+// or default tags in *_override.tf files, onto the program and copies the
+// context data, e.g. mounted credentials, next to it. Both are removed once
+// the run is done, so the work dir keeps only the generated program.
+func (t *TerraformRunner) applyOverrides() error {
+	applied, err := t.copyOverrides()
 	if err != nil {
+		return fmt.Errorf("error applying overrides: %s", err)
+	}
+	t.overrides = applied
+	if len(applied) > 0 {
+		t.logger.Info("applied overrides", slog.Any("files", applied))
+		fmt.Fprintf(t.stdout, "Applied overrides: %s\n", strings.Join(applied, ", "))
+	}
+
+	dir := filepath.Join(t.workDir, contextDataDir)
+	if err := os.RemoveAll(dir); err != nil {
 		return err
 	}
+	t.deleteOnCleanUp(dir)
+	data, err := listFiles(t.ContextDataDir)
+	if err == nil {
+		_, err = copyFiles(t.ContextDataDir, dir, data)
+	}
+	if err != nil {
+		return fmt.Errorf("error copying context data: %s", err)
+	}
+	if len(data) > 0 {
+		t.logger.Info("copied context data", slog.Any("files", data))
+	}
+	return nil
+}
+
+// copyOverrides copies the files of the override dir into the work dir and
+// returns them, relative to the work dir. Override files left behind by an
+// earlier run are replaced or removed, a file of the program itself is never
+// overridden.
+func (t *TerraformRunner) copyOverrides() ([]string, error) {
+	files, err := listFiles(t.OverrideDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest := filepath.Join(t.workDir, overridesFile)
+	var owned []string
+	if b, err := os.ReadFile(manifest); err == nil {
+		owned = strings.Fields(string(b))
+	}
+	for _, f := range files {
+		if fileExists(filepath.Join(t.workDir, f)) && !slices.Contains(owned, f) {
+			return nil, fmt.Errorf("%s is part of the program and cannot be overridden", f)
+		}
+	}
+	if len(files) == 0 && len(owned) == 0 {
+		return nil, nil
+	}
+
+	// recorded before copying, so that none of them is left behind unknown
+	recorded := slices.Clone(owned)
+	for _, f := range files {
+		if !slices.Contains(recorded, f) {
+			recorded = append(recorded, f)
+		}
+	}
+	if err := os.WriteFile(manifest, []byte(strings.Join(recorded, "\n")+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	for _, f := range owned {
+		if !slices.Contains(files, f) {
+			t.logger.Info("removing stale override", "file", f)
+			if err := os.Remove(filepath.Join(t.workDir, f)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	for _, f := range files {
+		t.deleteOnCleanUp(filepath.Join(t.workDir, f))
+	}
+	dirs, err := copyFiles(t.OverrideDir, t.workDir, files)
+	for _, dir := range dirs {
+		t.deleteOnCleanUp(dir)
+	}
+	t.deleteOnCleanUp(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Overrides returns the override files applied to the program, relative to
+// the work dir.
+func (t *TerraformRunner) Overrides() []string {
+	return t.overrides
+}
+
+// listFiles returns the paths of the files below src, relative to src.
+func listFiles(src string) ([]string, error) {
+	srcPath, err := filepath.Abs(src)
+	if err != nil {
+		return nil, err
+	}
 	if _, err = os.Stat(srcPath); err != nil {
-		return nil
+		return nil, nil
 	}
+
+	var files []string
 	err = filepath.WalkDir(srcPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -23,34 +133,56 @@ func copyOverrides(src, dst string) error {
 
 		// mounted k8s secrets creates symlinks in dirs prefixed with '..'
 		// these can be ignored
-		if strings.Contains(path, "/..") {
+		if strings.Contains(path, "/..") || d.IsDir() {
 			return nil
 		}
 
-		if d.IsDir() {
-			info, err := d.Info()
+		// https://developer.hashicorp.com/terraform/language/files/override
+		files = append(files, strings.TrimPrefix(strings.TrimPrefix(path, srcPath), string(os.PathSeparator)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// copyFiles copies the files, relative to src, into dst and returns the dirs
+// it had to create for them.
+func copyFiles(src, dst string, files []string) ([]string, error) {
+	var created []string
+	for _, f := range files {
+		dir := filepath.Dir(filepath.Join(dst, f))
+		if top := missingDir(dst, dir); top != "" {
+			info, err := os.Stat(filepath.Dir(filepath.Join(src, f)))
 			if err != nil {
-				return err
+				return created, err
 			}
-			err = createDir(filepath.Join(dst, strings.TrimPrefix(path, src)), info.Mode())
-			if err != nil {
-				return err
+			if err := os.MkdirAll(dir, info.Mode().Perm()); err != nil {
+				return created, err
 			}
-			return nil
+			created = append(created, top)
 		}
-
-		// https://developer.hashicorp.com/terraform/language/files/override
-		err = copyFile(path, filepath.Join(dst, strings.TrimPrefix(path, src)))
-		if err != nil {
-			return err
+		if err := copyFile(filepath.Join(src, f), filepath.Join(dst, f)); err != nil {
+			return created, err
 		}
+	}
+	return created, nil
+}
 
-		return nil
-	})
-	if err != nil {
-		return err
+// missingDir returns the topmost dir from dir up to root that does not exist
+// yet, or "" if dir exists.
+func missingDir(root, dir string) string {
+	missing := ""
+	for {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			missing = dir
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			return missing
+		}
+		dir = filepath.Dir(dir)
 	}
-	return nil
 }
 
 func createDir(dir string, mode os.FileMode) error {
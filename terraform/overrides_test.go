@@ -0,0 +1,85 @@
+package terraform
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readDir(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && path != dir {
+			files = append(files, strings.TrimPrefix(path, dir+"/"))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestOverridesReplaceStaleOnes(t *testing.T) {
+	runner, _ := newFakeRunner(t, "", Plan)
+	writeFiles(t, runner.OverrideDir, map[string]string{
+		"provider_override.tf":          "new",
+		"modules/tags/main_override.tf": "tags",
+	})
+	// left behind by a run that did not get to clean up
+	writeFiles(t, runner.workDir, map[string]string{
+		"main.tf":              "program",
+		"provider_override.tf": "old",
+		"old_override.tf":      "old",
+		overridesFile:          "provider_override.tf\nold_override.tf\n",
+	})
+
+	if err := runner.applyOverrides(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(runner.workDir, "provider_override.tf")); string(b) != "new" {
+		t.Errorf("stale override kept: %q", b)
+	}
+	if fileExists(filepath.Join(runner.workDir, "old_override.tf")) {
+		t.Error("override no longer in the override dir kept")
+	}
+	if !slices.Contains(runner.Overrides(), "modules/tags/main_override.tf") {
+		t.Errorf("got overrides %v", runner.Overrides())
+	}
+
+	if err := runner.cleanUp(); err != nil {
+		t.Fatal(err)
+	}
+	if files := readDir(t, runner.workDir); !slices.Equal(files, []string{"main.tf"}) {
+		t.Errorf("work dir after clean up has %v, want only main.tf", files)
+	}
+}
+
+func TestOverridesKeepProgramFiles(t *testing.T) {
+	runner, _ := newFakeRunner(t, "", Plan)
+	writeFiles(t, runner.OverrideDir, map[string]string{"main_override.tf": "override"})
+	writeFiles(t, runner.workDir, map[string]string{"main_override.tf": "program"})
+
+	if err := runner.applyOverrides(); err == nil || !strings.Contains(err.Error(), "part of the program") {
+		t.Errorf("got %v, want an error", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(runner.workDir, "main_override.tf")); string(b) != "program" {
+		t.Errorf("program file overridden: %q", b)
+	}
+}
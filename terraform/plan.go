@@ -0,0 +1,267 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionReplace = "replace"
+	ActionRead    = "read"
+	ActionNoop    = "no-op"
+)
+
+// ResourceChange is a planned change, or a detected drift, of a single
+// resource.
+type ResourceChange struct {
+	Address       string      `json:"address"`
+	ModuleAddress string      `json:"moduleAddress,omitempty"`
+	Mode          string      `json:"mode"`
+	Type          string      `json:"type"`
+	Name          string      `json:"name"`
+	ProviderName  string      `json:"providerName"`
+	Action        string      `json:"action"`
+	Before        interface{} `json:"before,omitempty"`
+	After         interface{} `json:"after,omitempty"`
+	// AfterUnknown marks the values of After only known once applied, true
+	// in their place
+	AfterUnknown interface{} `json:"afterUnknown,omitempty"`
+	// Region is the region configured for the provider of the resource,
+	// empty if it has none or it is only known on apply
+	Region string `json:"region,omitempty"`
+}
+
+// OutputChange is a planned change of a root module output.
+type OutputChange struct {
+	Name      string `json:"name"`
+	Action    string `json:"action"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+// PlanCounts summarizes a plan the way terraform does,
+// "Plan: 1 to add, 0 to change, 0 to destroy."
+type PlanCounts struct {
+	Add     int `json:"add"`
+	Change  int `json:"change"`
+	Destroy int `json:"destroy"`
+	Replace int `json:"replace"`
+	Drift   int `json:"drift"`
+}
+
+// PlanResult is the structured form of a terraform plan.
+type PlanResult struct {
+	TerraformVersion string `json:"terraformVersion"`
+	HasChanges       bool   `json:"hasChanges"`
+	// ResourceChanges holds the changes of managed resources grouped by
+	// action, no-ops are left out
+	ResourceChanges map[string][]ResourceChange `json:"resourceChanges"`
+	OutputChanges   []OutputChange              `json:"outputChanges,omitempty"`
+	// Drift holds the changes made outside of terraform since the last run
+	Drift  []ResourceChange `json:"drift,omitempty"`
+	Counts PlanCounts       `json:"counts"`
+	// Unchanged holds the managed resources the plan leaves as they are,
+	// e.g. to estimate the cost of all resources
+	Unchanged []ResourceChange `json:"-"`
+}
+
+// NewPlanResult builds a PlanResult from the output of terraform show -json.
+func NewPlanResult(plan *tfjson.Plan) *PlanResult {
+	result := &PlanResult{
+		TerraformVersion: plan.TerraformVersion,
+		ResourceChanges:  make(map[string][]ResourceChange),
+	}
+
+	regions := providerRegions(plan)
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change == nil || rc.Mode != tfjson.ManagedResourceMode {
+			continue
+		}
+		change := newResourceChange(rc)
+		change.Region = regions[configAddress(rc)]
+		switch change.Action {
+		case ActionNoop:
+			result.Unchanged = append(result.Unchanged, change)
+			continue
+		case ActionRead:
+			continue
+		case ActionCreate:
+			result.Counts.Add++
+		case ActionUpdate:
+			result.Counts.Change++
+		case ActionDelete:
+			result.Counts.Destroy++
+		case ActionReplace:
+			// terraform counts a replacement as an add and a destroy
+			result.Counts.Add++
+			result.Counts.Destroy++
+			result.Counts.Replace++
+		}
+		result.ResourceChanges[change.Action] = append(result.ResourceChanges[change.Action], change)
+	}
+
+	for _, rc := range plan.ResourceDrift {
+		if rc.Change == nil {
+			continue
+		}
+		result.Drift = append(result.Drift, newResourceChange(rc))
+	}
+	result.Counts.Drift = len(result.Drift)
+
+	for name, oc := range plan.OutputChanges {
+		action := actionOf(oc.Actions)
+		if action == ActionNoop {
+			continue
+		}
+		sensitive, _ := oc.AfterSensitive.(bool)
+		result.OutputChanges = append(result.OutputChanges, OutputChange{
+			Name:      name,
+			Action:    action,
+			Sensitive: sensitive,
+		})
+	}
+	sort.Slice(result.OutputChanges, func(i, j int) bool {
+		return result.OutputChanges[i].Name < result.OutputChanges[j].Name
+	})
+
+	result.HasChanges = len(result.ResourceChanges) > 0 || len(result.OutputChanges) > 0
+
+	return result
+}
+
+// Changes returns all resource changes, whatever their action.
+func (p *PlanResult) Changes() []ResourceChange {
+	var changes []ResourceChange
+	for _, action := range []string{ActionCreate, ActionUpdate, ActionReplace, ActionDelete} {
+		changes = append(changes, p.ResourceChanges[action]...)
+	}
+	return changes
+}
+
+func newResourceChange(rc *tfjson.ResourceChange) ResourceChange {
+	return ResourceChange{
+		Address:       rc.Address,
+		ModuleAddress: rc.ModuleAddress,
+		Mode:          string(rc.Mode),
+		Type:          rc.Type,
+		Name:          rc.Name,
+		ProviderName:  rc.ProviderName,
+		Action:        actionOf(rc.Change.Actions),
+		Before:        rc.Change.Before,
+		After:         rc.Change.After,
+		AfterUnknown:  rc.Change.AfterUnknown,
+	}
+}
+
+// indexPattern matches the instance keys of an address, as in [0] or ["a"].
+var indexPattern = regexp.MustCompile(`\[[^\]]*\]`)
+
+// configAddress returns the address of the resource in the configuration,
+// without the instance keys of it and of its modules.
+func configAddress(rc *tfjson.ResourceChange) string {
+	address := rc.Type + "." + rc.Name
+	if rc.ModuleAddress != "" {
+		address = rc.ModuleAddress + "." + address
+	}
+	return indexPattern.ReplaceAllString(address, "")
+}
+
+// providerRegions maps the configuration address of every resource to the
+// region configured for its provider, either a constant or a root module
+// variable.
+func providerRegions(plan *tfjson.Plan) map[string]string {
+	regions := make(map[string]string)
+	if plan.Config == nil {
+		return regions
+	}
+
+	region := func(key string) string {
+		config, ok := plan.Config.ProviderConfigs[key]
+		if !ok {
+			return ""
+		}
+		expr, ok := config.Expressions["region"]
+		if !ok || expr.ExpressionData == nil {
+			return ""
+		}
+		if value, ok := expr.ConstantValue.(string); ok {
+			return value
+		}
+		for _, ref := range expr.References {
+			name, ok := strings.CutPrefix(ref, "var.")
+			if !ok {
+				continue
+			}
+			if variable, ok := plan.Variables[name]; ok {
+				value, _ := variable.Value.(string)
+				return value
+			}
+		}
+		return ""
+	}
+
+	var walk func(prefix string, module *tfjson.ConfigModule)
+	walk = func(prefix string, module *tfjson.ConfigModule) {
+		if module == nil {
+			return
+		}
+		for _, resource := range module.Resources {
+			regions[prefix+resource.Address] = region(resource.ProviderConfigKey)
+		}
+		for name, call := range module.ModuleCalls {
+			walk(prefix+"module."+name+".", call.Module)
+		}
+	}
+	walk("", plan.Config.RootModule)
+	return regions
+}
+
+func actionOf(actions tfjson.Actions) string {
+	switch {
+	case actions.Replace():
+		return ActionReplace
+	case actions.Create():
+		return ActionCreate
+	case actions.Update():
+		return ActionUpdate
+	case actions.Delete():
+		return ActionDelete
+	case actions.Read():
+		return ActionRead
+	default:
+		return ActionNoop
+	}
+}
+
+var actionSymbols = map[string]string{
+	ActionCreate:  "+",
+	ActionUpdate:  "~",
+	ActionReplace: "-/+",
+	ActionDelete:  "-",
+}
+
+// Summary renders the plan as a short human readable listing of the changes.
+func (p *PlanResult) Summary() string {
+	var sb strings.Builder
+	for _, change := range p.Changes() {
+		fmt.Fprintf(&sb, "  %3s %s\n", actionSymbols[change.Action], change.Address)
+	}
+	for _, change := range p.Drift {
+		fmt.Fprintf(&sb, "  %3s %s (changed outside of terraform)\n", "!", change.Address)
+	}
+	for _, change := range p.OutputChanges {
+		fmt.Fprintf(&sb, "  %3s output.%s\n", actionSymbols[change.Action], change.Name)
+	}
+	if !p.HasChanges {
+		sb.WriteString("No changes.")
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "Plan: %d to add, %d to change, %d to destroy.", p.Counts.Add, p.Counts.Change, p.Counts.Destroy)
+	return sb.String()
+}
@@ -0,0 +1,146 @@
+package terraform
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+func loadPlan(t *testing.T) *PlanResult {
+	t.Helper()
+	b, err := os.ReadFile("testdata/plan.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan tfjson.Plan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		t.Fatal(err)
+	}
+	return NewPlanResult(&plan)
+}
+
+func TestNewPlanResult(t *testing.T) {
+	p := loadPlan(t)
+
+	if p.TerraformVersion != "1.6.0" || !p.HasChanges {
+		t.Errorf("got version %s and changes %t", p.TerraformVersion, p.HasChanges)
+	}
+	// the replacements count as an add and a destroy each
+	if want := (PlanCounts{Add: 3, Change: 1, Destroy: 3, Replace: 2, Drift: 1}); p.Counts != want {
+		t.Errorf("got counts %+v, want %+v", p.Counts, want)
+	}
+
+	actions := make(map[string][]string)
+	for action, changes := range p.ResourceChanges {
+		for _, change := range changes {
+			actions[action] = append(actions[action], change.Address)
+		}
+	}
+	want := map[string][]string{
+		ActionCreate: {"aws_s3_bucket.logs"},
+		ActionUpdate: {"aws_instance.web[0]"},
+		// delete before create and create before destroy
+		ActionReplace: {"aws_instance.db", "aws_eip.gw"},
+		ActionDelete:  {"aws_iam_role.old"},
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("got changes %v, want %v", actions, want)
+	}
+
+	if len(p.Unchanged) != 1 || p.Unchanged[0].Address != "module.vpc.aws_vpc.main" || p.Unchanged[0].ModuleAddress != "module.vpc" {
+		t.Errorf("got unchanged %+v, want the vpc of the module", p.Unchanged)
+	}
+	if len(p.Drift) != 1 || p.Drift[0].Address != "aws_instance.web[0]" || p.Drift[0].Action != ActionUpdate {
+		t.Errorf("got drift %+v", p.Drift)
+	}
+	wantOutputs := []OutputChange{
+		{Name: "password", Action: ActionUpdate, Sensitive: true},
+		{Name: "url", Action: ActionCreate},
+	}
+	if !reflect.DeepEqual(p.OutputChanges, wantOutputs) {
+		t.Errorf("got outputs %+v, want %+v", p.OutputChanges, wantOutputs)
+	}
+
+	logs := p.ResourceChanges[ActionCreate][0]
+	if logs.Type != "aws_s3_bucket" || logs.Name != "logs" || logs.Mode != "managed" || logs.ProviderName != "registry.terraform.io/hashicorp/aws" {
+		t.Errorf("got %+v", logs)
+	}
+	if !reflect.DeepEqual(logs.After, map[string]interface{}{"bucket": "logs"}) || !reflect.DeepEqual(logs.AfterUnknown, map[string]interface{}{"arn": true}) {
+		t.Errorf("got after %v and unknown %v", logs.After, logs.AfterUnknown)
+	}
+}
+
+func TestPlanRegions(t *testing.T) {
+	p := loadPlan(t)
+	regions := make(map[string]string)
+	for _, change := range append(p.Changes(), p.Unchanged...) {
+		regions[change.Address] = change.Region
+	}
+	want := map[string]string{
+		// a constant
+		"aws_s3_bucket.logs": "eu-west-1",
+		"aws_instance.db":    "eu-west-1",
+		// a variable, for every instance of the resource
+		"aws_instance.web[0]": "us-east-1",
+		// a provider without a region
+		"aws_eip.gw": "",
+		// a resource no longer configured
+		"aws_iam_role.old": "",
+		// inherited by the module
+		"module.vpc.aws_vpc.main": "eu-west-1",
+	}
+	if !reflect.DeepEqual(regions, want) {
+		t.Errorf("got regions %v, want %v", regions, want)
+	}
+
+	// a plan without its configuration
+	if regions := providerRegions(&tfjson.Plan{}); len(regions) != 0 {
+		t.Errorf("got regions %v without a configuration", regions)
+	}
+}
+
+func TestActionOf(t *testing.T) {
+	tests := []struct {
+		actions tfjson.Actions
+		want    string
+	}{
+		{tfjson.Actions{tfjson.ActionCreate}, ActionCreate},
+		{tfjson.Actions{tfjson.ActionUpdate}, ActionUpdate},
+		{tfjson.Actions{tfjson.ActionDelete}, ActionDelete},
+		{tfjson.Actions{tfjson.ActionDelete, tfjson.ActionCreate}, ActionReplace},
+		{tfjson.Actions{tfjson.ActionCreate, tfjson.ActionDelete}, ActionReplace},
+		{tfjson.Actions{tfjson.ActionRead}, ActionRead},
+		{tfjson.Actions{tfjson.ActionNoop}, ActionNoop},
+	}
+	for _, tt := range tests {
+		if got := actionOf(tt.actions); got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.actions, got, tt.want)
+		}
+	}
+}
+
+func TestPlanSummary(t *testing.T) {
+	want := "    + aws_s3_bucket.logs\n" +
+		"    ~ aws_instance.web[0]\n" +
+		"  -/+ aws_instance.db\n" +
+		"  -/+ aws_eip.gw\n" +
+		"    - aws_iam_role.old\n" +
+		"    ! aws_instance.web[0] (changed outside of terraform)\n" +
+		"    ~ output.password\n" +
+		"    + output.url\n" +
+		"Plan: 3 to add, 1 to change, 3 to destroy."
+	if got := loadPlan(t).Summary(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	drifted := &PlanResult{Drift: []ResourceChange{{Address: "aws_s3_bucket.logs", Action: ActionUpdate}}}
+	if got, want := drifted.Summary(), "    ! aws_s3_bucket.logs (changed outside of terraform)\nNo changes."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := (&PlanResult{}).Summary(); got != "No changes." {
+		t.Errorf("got %q for an empty plan", got)
+	}
+}
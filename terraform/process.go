@@ -0,0 +1,186 @@
+package terraform
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const (
+	defaultInterruptGracePeriod = 60 * time.Second
+	defaultTerminateGracePeriod = 10 * time.Second
+)
+
+// watch stops the terraform process started by the runner once ctx is
+// canceled. The returned function must be called when the command returned,
+// the process is then known to be gone.
+//
+// Commands must not run with ctx itself, exec would kill terraform right away
+// without giving it a chance to release the state lock and persist state.
+func (t *TerraformRunner) watch(ctx context.Context) func() {
+	hurry, done := startCommand()
+	exited := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			t.abort(exited, hurry)
+		case <-exited:
+		}
+	}()
+
+	return func() {
+		close(exited)
+		<-stopped
+		done()
+	}
+}
+
+// abort stops the terraform process the runner started, asking it nicely
+// first. Its process group is sent SIGINT, which makes terraform finish the
+// operations in flight, release its locks and persist state, then SIGTERM
+// and finally SIGKILL if it did not exit within the grace period of the
+// previous signal. A command about to start when ctx was canceled is
+// waited for and stopped as soon as it started. Once hurry is closed SIGINT
+// is followed by SIGTERM right away.
+func (t *TerraformRunner) abort(exited, hurry <-chan struct{}) {
+	pid, ok := t.awaitProcess(exited)
+	if !ok {
+		t.logger.Debug("no terraform process to stop")
+		return
+	}
+
+	steps := []struct {
+		signal      syscall.Signal
+		gracePeriod time.Duration
+	}{
+		{syscall.SIGINT, t.InterruptGracePeriod},
+		{syscall.SIGTERM, t.TerminateGracePeriod},
+		{syscall.SIGKILL, 0},
+	}
+
+	for _, step := range steps {
+		t.logger.Info("stopping process",
+			slog.String("engine", t.Engine),
+			slog.Int("pid", pid),
+			slog.String("signal", step.signal.String()),
+		)
+		// commands are started in a process group of their own, led by
+		// terraform, so its providers are stopped with it
+		if err := syscall.Kill(-pid, step.signal); err != nil && err != syscall.ESRCH {
+			t.logger.Error("failed to signal process", "engine", t.Engine, "pid", pid, "error", err)
+		}
+
+		// after a second signal SIGTERM follows right away
+		var skip <-chan struct{}
+		if step.signal == syscall.SIGINT {
+			skip = hurry
+		}
+		select {
+		case <-exited:
+			t.stopped(step.signal)
+			return
+		case <-time.After(step.gracePeriod):
+		case <-skip:
+		}
+	}
+
+	// SIGKILL cannot be ignored, the command returns as soon as the process
+	// is reaped
+	<-exited
+	t.stopped(syscall.SIGKILL)
+}
+
+func (t *TerraformRunner) stopped(signal syscall.Signal) {
+	t.logger.Info("process stopped", slog.String("engine", t.Engine), slog.String("signal", signal.String()))
+	t.emit(Event{Type: EventAborted, Message: EngineName(t.Engine) + " stopped after " + signal.String()})
+}
+
+// processPollInterval is how often a command about to start is looked for.
+const processPollInterval = 100 * time.Millisecond
+
+// awaitProcess returns the pid of the terraform process running the command,
+// waiting for it to start. It returns false if the command returned first.
+func (t *TerraformRunner) awaitProcess(exited <-chan struct{}) (int, bool) {
+	for {
+		if pid, ok := t.startedProcess(); ok {
+			return pid, true
+		}
+		select {
+		case <-exited:
+			return 0, false
+		case <-time.After(processPollInterval):
+		}
+	}
+}
+
+// startedProcess returns the pid of the terraform or OpenTofu process the
+// runner started: the child of this process carrying the runner's tag in its
+// environment. Where the environment of processes cannot be read, it is the
+// child running the runner's binary in the runner's work dir.
+func (t *TerraformRunner) startedProcess() (int, bool) {
+	ctx := context.Background()
+
+	self, err := process.NewProcessWithContext(ctx, int32(os.Getpid()))
+	if err != nil {
+		t.logger.Error("failed to get own process", "error", err)
+		return 0, false
+	}
+	children, err := self.ChildrenWithContext(ctx)
+	if err != nil {
+		// no children at all
+		return 0, false
+	}
+
+	workDir, _ := filepath.Abs(t.workDir)
+	for _, p := range children {
+		// the group is only set up once the command started
+		if pgid, err := syscall.Getpgid(int(p.Pid)); err != nil || pgid != int(p.Pid) {
+			continue
+		}
+
+		env, err := p.EnvironWithContext(ctx)
+		if err == nil {
+			if slices.ContainsFunc(env, func(v string) bool {
+				return strings.HasPrefix(v, userAgentEnv+"=") && strings.Contains(v, t.processTag)
+			}) {
+				return int(p.Pid), true
+			}
+			continue
+		}
+
+		exe, err := p.ExeWithContext(ctx)
+		if err != nil || exe != t.execPath {
+			continue
+		}
+		cwd, err := p.CwdWithContext(ctx)
+		if err != nil || cwd != workDir {
+			continue
+		}
+		return int(p.Pid), true
+	}
+	return 0, false
+}
+
+// userAgentEnv is passed to every command by terraform-exec, it carries the
+// runner's tag.
+const userAgentEnv = "TF_APPEND_USER_AGENT"
+
+// newProcessTag returns the tag the processes of a runner are told apart by,
+// it is appended to the user agent terraform sends.
+func newProcessTag(runID string) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("ginie/%s.%s", runID, hex.EncodeToString(b))
+}
@@ -0,0 +1,109 @@
+package terraform
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCancelStopsRunningCommand(t *testing.T) {
+	binary := installFake(t)
+	runner, _ := newFakeRunner(t, binary, Init, Plan)
+	runner.InterruptGracePeriod = time.Second
+	if err := os.WriteFile(filepath.Join(runner.workDir, "slow"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- runner.Execute(ctx)
+	}()
+
+	pid := awaitPid(t, filepath.Join(runner.workDir, "init.pid"))
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("canceled run succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("canceled run did not stop")
+	}
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("terraform still running after the run stopped: %v", err)
+	}
+	for _, call := range fakeCalls(t, binary) {
+		if strings.Contains(call, ": plan ") {
+			t.Errorf("canceled run went on: %s", call)
+		}
+	}
+}
+
+// TestCancelBeforeStart cancels before the command started, it is stopped
+// as soon as it did.
+func TestCancelBeforeStart(t *testing.T) {
+	runner, _ := newFakeRunner(t, installFake(t), Init)
+	runner.InterruptGracePeriod = time.Second
+	runner.processTag = newProcessTag("test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stop := runner.watch(ctx)
+
+	time.Sleep(3 * processPollInterval)
+	cmd := exec.Command("sleep", "30")
+	cmd.Dir = runner.workDir
+	cmd.Env = append(os.Environ(), userAgentEnv+"="+runner.processTag)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		stop()
+		waited <- err
+	}()
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("command was not stopped")
+		}
+	case <-time.After(10 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("command started after cancel was not stopped")
+	}
+}
+
+// TestProcessOfOtherRunner checks a runner does not take the processes of
+// another runner for its own.
+func TestProcessOfOtherRunner(t *testing.T) {
+	runner, _ := newFakeRunner(t, installFake(t), Init)
+	runner.processTag = newProcessTag("test")
+
+	cmd := exec.Command("sleep", "30")
+	cmd.Dir = runner.workDir
+	cmd.Env = append(os.Environ(), userAgentEnv+"="+newProcessTag("other"))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if pid, ok := runner.startedProcess(); ok {
+		t.Errorf("found process %d of another runner", pid)
+	}
+}
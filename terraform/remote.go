@@ -0,0 +1,278 @@
+package terraform
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	jobLogName   = "job.log"
+	jobStateName = "terraform.tfstate"
+)
+
+// httpClient returns the client used for the job's downloads and uploads.
+func (t *TerraformRunner) httpClient() *http.Client {
+	if !t.SkipTLSVerify {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return &http.Client{Transport: transport}
+}
+
+func authHeaders(token string) map[string]string {
+	if token == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// download fetches the work dir from DownloadUrl, a zip archive or a zstd
+// compressed tarball, and unpacks it into the work dir.
+func (t *TerraformRunner) download(ctx context.Context) error {
+	if t.DownloadUrl == "" {
+		return nil
+	}
+	defer t.timer("download")()
+
+	var body []byte
+	var contentType string
+	err := t.retryOnError(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.DownloadUrl, nil)
+		if err != nil {
+			return err
+		}
+		setHeaders(req, authHeaders(t.DownloadToken))
+
+		resp, err := t.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status downloading work dir: %s", resp.Status)
+		}
+
+		contentType = resp.Header.Get("Content-Type")
+		body, err = io.ReadAll(resp.Body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %s", downloadArchiveName, err)
+	}
+
+	t.logger.Debug("downloaded work dir",
+		slog.String("content-type", contentType),
+		slog.Int("size", len(body)),
+	)
+
+	if isContentTypeZip(contentType) {
+		return unzip(body, t.workDir)
+	}
+	return untarZstd(bytes.NewReader(body), t.workDir)
+}
+
+// upload packages the results of the job, the plan, outputs, log and state,
+// into a zstd compressed tarball and uploads it to UploadUrl.
+func (t *TerraformRunner) upload(ctx context.Context, tf *tfexec.Terraform) error {
+	if t.UploadUrl == "" {
+		return nil
+	}
+	defer t.timer("upload")()
+
+	files := map[string]string{
+		planFile:   t.planPath,
+		outputFile: t.outputPath,
+		jobLogName: t.logPath(),
+	}
+	entries := make(map[string][]byte)
+	for name, path := range files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		entries[name] = b
+	}
+
+	// the state is pulled from wherever the backend keeps it
+	tf.SetStdout(io.Discard)
+	state, err := tf.StatePull(context.WithoutCancel(ctx))
+	tf.SetStdout(t.stdout)
+	if err != nil {
+		t.logger.Warn("error pulling state, uploading the job without it", "error", err)
+	} else if state != "" {
+		entries[jobStateName] = []byte(state)
+	}
+
+	archive, err := tarZstd(entries)
+	if err != nil {
+		return err
+	}
+
+	err = t.retryOnError(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, t.UploadUrl, bytes.NewReader(archive))
+		if err != nil {
+			return err
+		}
+		setHeaders(req, authHeaders(t.UploadToken))
+		req.Header.Set("Content-Type", "application/zstd")
+		req.Header.Set("Content-Disposition", "attachment; filename="+uploadArchiveName)
+
+		resp, err := t.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status uploading %s: %s", uploadArchiveName, resp.Status)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading job: %s", err)
+	}
+
+	t.logger.Debug("uploaded job", slog.Int("size", len(archive)))
+	return nil
+}
+
+// tarZstd writes the entries into a zstd compressed tarball.
+func tarZstd(entries map[string][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+
+	now := time.Now()
+	for name, b := range entries {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(b)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// untarZstd unpacks a zstd compressed tarball into dir.
+func untarZstd(r io.Reader, dir string) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := archivePath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(target, tr, hdr.FileInfo().Mode()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// unzip unpacks a zip archive into dir.
+func unzip(b []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		target, err := archivePath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(target, rc, f.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath returns where an archive entry is unpacked to, refusing
+// entries that would end up outside of dir.
+func archivePath(dir, name string) (string, error) {
+	target := filepath.Join(dir, name)
+	if target != filepath.Clean(dir) && !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid archive entry: %s", name)
+	}
+	return target, nil
+}
+
+func writeArchiveFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,247 @@
+package terraform
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarZstdArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	entries := make(map[string][]byte)
+	for name, content := range files {
+		entries[name] = []byte(content)
+	}
+	b, err := tarZstd(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// readTarZstd returns the files of a zstd compressed tarball.
+func readTarZstd(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	zr, err := zstd.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(content)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	files := map[string]string{
+		"main.tf":             `resource "null_resource" "a" {}`,
+		"modules/vpc/main.tf": `resource "null_resource" "vpc" {}`,
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		archive     []byte
+		want        []string
+		err         string
+	}{
+		{
+			name:    "tarball",
+			archive: tarZstdArchive(t, files),
+			want:    []string{"main.tf", "modules", "modules/vpc", "modules/vpc/main.tf"},
+		},
+		{
+			name:        "zip",
+			contentType: "application/zip",
+			archive:     zipArchive(t, files),
+			want:        []string{"main.tf", "modules", "modules/vpc", "modules/vpc/main.tf"},
+		},
+		{
+			name:        "zip of windows",
+			contentType: "application/x-zip-compressed",
+			archive:     zipArchive(t, map[string]string{"main.tf": ""}),
+			want:        []string{"main.tf"},
+		},
+		{
+			name:    "entry outside of the work dir",
+			archive: tarZstdArchive(t, map[string]string{"../main.tf": ""}),
+			err:     "invalid archive entry: ../main.tf",
+		},
+		{
+			name:        "zip entry outside of the work dir",
+			contentType: "application/zip",
+			archive:     zipArchive(t, map[string]string{"modules/../../main.tf": ""}),
+			err:         "invalid archive entry: modules/../../main.tf",
+		},
+		{
+			name:        "not an archive",
+			contentType: "application/zip",
+			archive:     []byte("main.tf"),
+			err:         "zip",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var auth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				auth = r.Header.Get("Authorization")
+				mu.Unlock()
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.Write(tt.archive)
+			}))
+			defer server.Close()
+
+			runner, _ := newFakeRunner(t, installFake(t))
+			runner.DownloadUrl = server.URL
+			runner.DownloadToken = "secret"
+
+			err := runner.download(context.Background())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readDir(t, runner.workDir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if auth != "Bearer secret" {
+				t.Errorf("got authorization %q", auth)
+			}
+		})
+	}
+}
+
+// TestDownloadRetried takes the delay between attempts.
+func TestDownloadRetried(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(tarZstdArchive(t, map[string]string{"main.tf": ""}))
+	}))
+	defer server.Close()
+
+	runner, _ := newFakeRunner(t, installFake(t))
+	runner.DownloadUrl = server.URL
+	if err := runner.download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 || !fileExists(filepath.Join(runner.workDir, "main.tf")) {
+		t.Errorf("got %d requests and %v", requests.Load(), readDir(t, runner.workDir))
+	}
+}
+
+// TestRemoteJob runs a job from a downloaded work dir and checks the results
+// it uploads.
+func TestRemoteJob(t *testing.T) {
+	var mu sync.Mutex
+	var upload []byte
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write(tarZstdArchive(t, map[string]string{"main.tf": `resource "null_resource" "a" {}`}))
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			upload, headers = b, r.Header
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	binary := installFake(t)
+	runner, out := newFakeRunner(t, binary, Init, Plan)
+	runner.DownloadUrl = server.URL + "/workdir"
+	runner.UploadUrl = server.URL + "/job"
+	runner.UploadToken = "secret"
+	runner.TerraformLogLevel = "INFO"
+	if err := runner.Execute(context.Background()); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+
+	// terraform runs in the downloaded work dir
+	if !fileExists(filepath.Join(runner.workDir, "main.tf")) {
+		t.Errorf("work dir has %v", readDir(t, runner.workDir))
+	}
+	if calls := fakeCalls(t, binary); !strings.HasPrefix(calls[0], runner.workDir+": ") {
+		t.Errorf("ran %q", calls)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if headers.Get("Authorization") != "Bearer secret" || headers.Get("Content-Type") != "application/zstd" ||
+		headers.Get("Content-Disposition") != "attachment; filename=job.tar.zst" {
+		t.Errorf("got headers %v", headers)
+	}
+	files := readTarZstd(t, upload)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	if len(files) != 3 || !strings.Contains(files[planFile], `"resource_changes"`) ||
+		files[jobStateName] != "{\"version\":4,\"serial\":1}\n" || !strings.Contains(files[jobLogName], "[INFO] plan") {
+		t.Errorf("uploaded %v", names)
+	}
+	if _, err := os.Stat(filepath.Dir(runner.planPath)); !os.IsNotExist(err) {
+		t.Errorf("left scratch dir %s", filepath.Dir(runner.planPath))
+	}
+}
@@ -4,74 +4,113 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
-
-	"github.com/shirou/gopsutil/v3/process"
 )
 
 // Adapted from https://github.com/kubernetes-sigs/controller-runtime/blob/master/pkg/manager/signals/signal.go
 
 var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
 
-var onlyOneSignalHandler = make(chan struct{})
-
-// SetupSignalHandler registers for SIGTERM and SIGINT. A context is returned
-// which is canceled on one of these signals. If a second signal is caught, the program
-// is terminated with exit code 1.
-func setupSignalHandler() context.Context {
-	close(onlyOneSignalHandler) // panics when called twice
-
-	ctx, cancel := context.WithCancel(context.Background())
-
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, shutdownSignals...)
-	go func() {
-		<-c
-		abort(ctx)
-		cancel()
+// exit is replaced in tests
+var exit = os.Exit
+
+// commands tracks the commands of all runners, a second signal hurries their
+// processes up.
+var commands struct {
+	mu      sync.Mutex
+	running int
+	// hurry is closed by a second signal, processes being stopped skip the
+	// grace period of SIGINT
+	hurry chan struct{}
+	// idle is closed once no command runs any more after a second signal
+	idle chan struct{}
+}
 
-		<-c
-		os.Exit(1) // second signal. Exit directly.
-	}()
+func init() {
+	commands.hurry = make(chan struct{})
+}
 
-	return ctx
+// startCommand tracks a command until the returned func is called, returning
+// the channel closed when its process is to be stopped without delay.
+func startCommand() (<-chan struct{}, func()) {
+	commands.mu.Lock()
+	defer commands.mu.Unlock()
+	commands.running++
+	return commands.hurry, func() {
+		commands.mu.Lock()
+		defer commands.mu.Unlock()
+		commands.running--
+		if commands.running == 0 && commands.idle != nil {
+			close(commands.idle)
+			commands.idle = nil
+		}
+	}
 }
 
-func abort(ctx context.Context) {
-	processes, err := process.ProcessesWithContext(ctx)
-	if err != nil {
-		logger.Error("failed to get processes", "error", err)
+// hurryCommands makes the runners stopping their processes skip the grace
+// period of SIGINT, sending SIGTERM right away. The returned channel is
+// closed once all commands returned.
+func hurryCommands() <-chan struct{} {
+	commands.mu.Lock()
+	defer commands.mu.Unlock()
+
+	idle := make(chan struct{})
+	select {
+	case <-commands.hurry:
+	default:
+		close(commands.hurry)
+	}
+	if commands.running == 0 {
+		close(idle)
+	} else {
+		commands.idle = idle
 	}
+	return idle
+}
 
-	for _, p := range processes {
-		name, err := p.NameWithContext(ctx)
-		if err != nil {
-			logger.Error("failed to get process name", "error", err)
-		}
+// SetupSignalHandler registers for SIGTERM and SIGINT for the lifetime of a
+// single operation. A context is returned which is canceled on one of these
+// signals, runners executing with it then stop the terraform processes they
+// spawned. If a second signal is caught, the processes are sent SIGINT and
+// SIGTERM without waiting for the grace period and the program exits with
+// code 1 once they are gone, a third one exits right away.
+//
+// The returned stop function unregisters the handler and must be called once
+// the operation is over, so that later signals get their default behaviour.
+func SetupSignalHandler(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
 
-		if name != "terraform" {
-			continue
-		}
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, shutdownSignals...)
 
-		pid := int(p.Pid)
-		pgid, err := syscall.Getpgid(pid)
-		if err != nil {
-			logger.Error("failed to get terraform process group id", "error", err)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-done:
+			return
 		}
 
-		logger.Debug("killing terraform process")
-
-		if err = syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
-			logger.Error("failed to kill terraform process", "error", err)
+		select {
+		case <-c:
+		case <-done:
+			return
 		}
 
-		// wait for terraform to terminate
-		for pgid != -1 {
-			pgid, _ = syscall.Getpgid(pid)
-			time.Sleep(time.Second * 1)
+		// second signal, the state is only left locked if terraform does not
+		// get to exit
+		select {
+		case <-hurryCommands():
+		case <-c:
 		}
+		exit(1)
+	}()
 
-		break
+	return ctx, func() {
+		signal.Stop(c)
+		close(done)
+		cancel()
 	}
 }
@@ -0,0 +1,86 @@
+package terraform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestSecondSignal interrupts a run twice, its terraform ignoring SIGINT
+// for longer than the test lasts. The second signal sends SIGTERM and exits
+// once terraform is gone.
+func TestSecondSignal(t *testing.T) {
+	exits := make(chan int, 1)
+	exit = func(code int) { exits <- code }
+	defer func() {
+		exit = os.Exit
+		commands.hurry = make(chan struct{})
+	}()
+
+	binary := installFake(t)
+	runner, _ := newFakeRunner(t, binary, Init)
+	runner.InterruptGracePeriod = time.Hour
+	for _, name := range []string{"slow", "stubborn"} {
+		if err := os.WriteFile(filepath.Join(runner.workDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, stop := SetupSignalHandler(context.Background())
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- runner.Execute(ctx)
+	}()
+
+	pid := awaitPid(t, filepath.Join(runner.workDir, "init.pid"))
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	<-ctx.Done()
+	select {
+	case <-errs:
+		t.Fatal("run stopped before the second signal")
+	case <-time.After(500 * time.Millisecond):
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case code := <-exits:
+		if code != 1 {
+			t.Errorf("exit code %d, want 1", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not exit after the second signal")
+	}
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("terraform still running after exit: %v", err)
+	}
+	if err := <-errs; err == nil {
+		t.Error("interrupted run succeeded")
+	}
+}
+
+// awaitPid waits for the fake terraform to write its pid.
+func awaitPid(t *testing.T, path string) int {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		b, err := os.ReadFile(path)
+		if err == nil {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+				return pid
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("terraform did not start")
+	return 0
+}
@@ -0,0 +1,212 @@
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// Stack is one of the programs a project is split into, e.g. network, data
+// and app, deployed from a work dir of its own. A stack is deployed after the
+// stacks it depends on and gets their outputs as variables.
+type Stack struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// ErrStackSkipped is returned for stacks that did not run because a stack
+// they depend on failed.
+var ErrStackSkipped = errors.New("skipped")
+
+// OrderStacks returns the stacks in the order they are deployed, every stack
+// after the stacks it depends on, and the names otherwise. Dependencies on
+// unknown stacks and cycles are errors.
+func OrderStacks(stacks []Stack) ([]Stack, error) {
+	byName := make(map[string]Stack, len(stacks))
+	for _, s := range stacks {
+		if _, ok := byName[s.Name]; ok {
+			return nil, fmt.Errorf("duplicate stack: %s", s.Name)
+		}
+		byName[s.Name] = s
+	}
+	for _, s := range stacks {
+		for _, dep := range s.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("stack %s depends on unknown stack %s", s.Name, dep)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(stacks))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(stacks))
+	ordered := make([]Stack, 0, len(stacks))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("stacks depend on each other: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		deps := append([]string(nil), byName[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// RunStacks calls run for every stack, concurrently for stacks independent of
+// each other. A stack runs once the stacks it depends on succeeded, or with
+// reverse, as for destroy, once the stacks depending on it did. Stacks that
+// cannot run because of a failure are skipped with ErrStackSkipped. The
+// errors of the stacks are returned by name.
+func RunStacks(ctx context.Context, stacks []Stack, reverse bool, run func(ctx context.Context, stack Stack) error) (map[string]error, error) {
+	ordered, err := OrderStacks(stacks)
+	if err != nil {
+		return nil, err
+	}
+
+	// waitFor lists the stacks a stack has to wait for
+	waitFor := make(map[string][]string, len(ordered))
+	for _, s := range ordered {
+		if !reverse {
+			waitFor[s.Name] = append(waitFor[s.Name], s.DependsOn...)
+			continue
+		}
+		for _, dep := range s.DependsOn {
+			waitFor[dep] = append(waitFor[dep], s.Name)
+		}
+	}
+
+	done := make(map[string]chan struct{}, len(ordered))
+	for _, s := range ordered {
+		done[s.Name] = make(chan struct{})
+	}
+
+	var mu sync.Mutex
+	errs := make(map[string]error, len(ordered))
+	var wg sync.WaitGroup
+	for _, s := range ordered {
+		wg.Add(1)
+		go func(s Stack) {
+			defer wg.Done()
+			defer close(done[s.Name])
+
+			var err error
+			for _, name := range waitFor[s.Name] {
+				<-done[name]
+				mu.Lock()
+				failed := errs[name] != nil
+				mu.Unlock()
+				if failed {
+					err = fmt.Errorf("%w, stack %s failed", ErrStackSkipped, name)
+					break
+				}
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err == nil {
+				err = run(ctx, s)
+			}
+
+			mu.Lock()
+			errs[s.Name] = err
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+	return errs, nil
+}
+
+var variableBlock = regexp.MustCompile(`(?m)^\s*variable\s+"([^"]+)"`)
+
+// DeclaredVariables returns the names of the variables the configuration in
+// dir declares.
+func DeclaredVariables(dir string) (map[string]bool, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string]bool)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range variableBlock.FindAllSubmatch(b, -1) {
+			variables[string(m[1])] = true
+		}
+	}
+	return variables, nil
+}
+
+// StackVars turns the outputs of upstream stacks into -var values for the
+// variables of the same name the configuration in dir declares. Outputs
+// without a declared variable are left out, terraform refuses values for
+// undeclared variables.
+func StackVars(dir string, outputs map[string]tfexec.OutputMeta) ([]string, error) {
+	variables, err := DeclaredVariables(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var vars []string
+	for name, output := range outputs {
+		if !variables[name] {
+			continue
+		}
+		value, ok := varValue(output.Value)
+		if !ok {
+			continue
+		}
+		vars = append(vars, name+"="+value)
+	}
+	sort.Strings(vars)
+	return vars, nil
+}
+
+// varValue renders an output value as -var takes it: strings as they are,
+// everything else as the JSON it is, which terraform parses as an expression.
+func varValue(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	return string(raw), true
+}
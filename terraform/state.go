@@ -0,0 +1,68 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const stateFile = "terraform.tfstate"
+
+type state struct {
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey interface{} `json:"index_key"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// StateResources returns the addresses of all resources recorded in the local
+// state of workDir, e.g. aws_s3_bucket.logs or module.vpc.aws_vpc.this[0].
+func StateResources(workDir string) ([]string, error) {
+	b, err := os.ReadFile(path.Join(workDir, stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return StateAddresses(b)
+}
+
+// StateAddresses returns the addresses of all resources recorded in a state.
+func StateAddresses(b []byte) ([]string, error) {
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, r := range s.Resources {
+		var sb strings.Builder
+		if r.Module != "" {
+			sb.WriteString(r.Module + ".")
+		}
+		if r.Mode == "data" {
+			sb.WriteString("data.")
+		}
+		sb.WriteString(r.Type + "." + r.Name)
+		base := sb.String()
+
+		addresses = append(addresses, base)
+		for _, i := range r.Instances {
+			switch key := i.IndexKey.(type) {
+			case float64:
+				addresses = append(addresses, fmt.Sprintf("%s[%d]", base, int(key)))
+			case string:
+				addresses = append(addresses, fmt.Sprintf("%s[%q]", base, key))
+			}
+		}
+	}
+	return addresses, nil
+}
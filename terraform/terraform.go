@@ -3,20 +3,14 @@ package terraform
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"path"
+	"path/filepath"
 	"time"
 
-	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/hc-install/product"
-	"github.com/hashicorp/hc-install/releases"
 	"github.com/hashicorp/terraform-exec/tfexec"
-)
-
-var (
-	filesToBeDeleted []string
-	logger           *slog.Logger
+	tfjson "github.com/hashicorp/terraform-json"
 )
 
 const (
@@ -24,13 +18,10 @@ const (
 	uploadArchiveName   = "job.tar.zst"
 	planFile            = "plan.json"
 	outputFile          = "output.json"
-	appPath             = "app/"
-	basePath            = "app/scratch/"
-	terraformWorkDir    = "gen-ai-tf/"
-	installDir          = "gen-ai-tf/app"
-	overrideDir         = "tmp/overrides"
+	defaultInstallDir   = "gen-ai-tf/app"
+	defaultOverrideDir  = "tmp/overrides"
 	secretMountPath     = "tmp/contextdata"
-	binaryName          = "terraform"
+	defaultPlanFile     = "ginie.tfplan"
 )
 
 type Installer interface {
@@ -47,7 +38,7 @@ func newTfLogger(slogger *slog.Logger) *tfLogger {
 }
 
 func (l tfLogger) Printf(format string, v ...interface{}) {
-	l.logger.Info(fmt.Sprintf(format, v...))
+	l.logger.Debug(fmt.Sprintf(format, v...))
 }
 
 type TerraformRunner struct {
@@ -59,44 +50,125 @@ type TerraformRunner struct {
 	logger    *slog.Logger
 	tfLog     *tfLogger
 	installer Installer
+	execPath  string
+	// processTag tells the processes of the runner apart from those of
+	// others running side by side
+	processTag string
+
+	stdout  io.Writer
+	stderr  io.Writer
+	onEvent EventHandler
+	onPlan  PlanHandler
+
+	planResult *PlanResult
+	// plannedFile is the plan saved by the plan action, a following apply
+	// applies it as it was checked rather than planning again
+	plannedFile     string
+	workspaces      []string
+	providerSchemas *tfjson.ProviderSchemas
+	overrides       []string
+	outputs         map[string]tfexec.OutputMeta
+
+	// files and dirs removed once the run is done
+	filesToBeDeleted []string
 }
 
+// PlanHandler is called with the result of the plan action before the
+// following actions run. Returning an error stops the run, e.g. to keep a
+// plan that violates a policy from being applied.
+type PlanHandler func(*PlanResult) error
+
 func NewTerraformRunner(_logger *slog.Logger, driverConfig *DriverConfig) *TerraformRunner {
 	t := &TerraformRunner{
 		DriverConfig: driverConfig,
 		workDir:      driverConfig.WorkDir,
 		logger:       _logger,
 		tfLog:        newTfLogger(_logger),
+		stdout:       os.Stdout,
+		stderr:       os.Stderr,
 	}
 
-	t.installer = &releases.ExactVersion{
-		Product: product.Terraform,
-		Version: version.Must(version.NewVersion(t.Version)),
-		//InstallDir: installDir,
-	}
+	return t
+}
 
-	logger = _logger
+// SetStdout sets the writer the output of terraform commands is sent to,
+// os.Stdout by default.
+func (t *TerraformRunner) SetStdout(w io.Writer) {
+	t.stdout = w
+}
 
-	return t
+// SetStderr sets the writer the errors of terraform commands are sent to,
+// os.Stderr by default.
+func (t *TerraformRunner) SetStderr(w io.Writer) {
+	t.stderr = w
+}
+
+// SetEventHandler registers a handler notified of the progress of the run:
+// actions starting and finishing, per-resource apply progress and
+// diagnostics.
+func (t *TerraformRunner) SetEventHandler(handler EventHandler) {
+	t.onEvent = handler
+}
+
+// SetPlanHandler registers a handler consuming the result of the plan action.
+func (t *TerraformRunner) SetPlanHandler(handler PlanHandler) {
+	t.onPlan = handler
+}
+
+// PlanResult returns the result of the last plan or show action of the run,
+// nil if there was none.
+func (t *TerraformRunner) PlanResult() *PlanResult {
+	return t.planResult
+}
+
+// eventWriter consumes the -json output of an action, notifying the event
+// handler and rendering a compact progress view to stdout.
+func (t *TerraformRunner) eventWriter(action string) io.Writer {
+	return newEventWriter(action, t.onEvent, t.stdout)
+}
+
+// Workspaces returns the terraform workspaces found by the workspace-list
+// action, and the selected one.
+func (t *TerraformRunner) Workspaces() ([]string, string) {
+	return t.workspaces, t.Workspace
+}
+
+func (t *TerraformRunner) emit(e Event) {
+	if t.onEvent != nil {
+		t.onEvent(e)
+	}
 }
 
 func (t *TerraformRunner) install(ctx context.Context) (*tfexec.Terraform, error) {
 	now := time.Now()
 
+	e, err := getEngine(t.Engine)
+	if err != nil {
+		return nil, err
+	}
+	t.installer = newCachedInstaller(t.logger, e, t.Version, t.workDir, t.InstallDir, t.BinaryPath)
+
 	var execPath string
-	var err error
-	err = retryOnError(func() error {
+	err = t.retryOnError(ctx, func() error {
 		execPath, err = t.installer.Install(ctx)
 		return err
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error installing Terraform: %s", err)
+		return nil, fmt.Errorf("error installing %s: %s", e.name, err)
+	}
+
+	// the path is compared with the executable of spawned processes
+	if resolved, err := filepath.EvalSymlinks(execPath); err == nil {
+		execPath = resolved
 	}
+	t.execPath = execPath
 
 	tf, err := tfexec.NewTerraform(t.workDir, execPath)
 	if err != nil {
 		return nil, fmt.Errorf("error running NewTerraform: %s", err)
 	}
+	t.processTag = newProcessTag(t.RunID)
+	tf.SetAppendUserAgent(t.processTag)
 
 	v, _, err := tf.Version(ctx, true)
 	if err != nil {
@@ -106,6 +178,7 @@ func (t *TerraformRunner) install(ctx context.Context) (*tfexec.Terraform, error
 
 	t.logger.Debug("time taken to install terraform binary",
 		slog.Duration("duration", time.Since(now)),
+		slog.String("engine", e.binary),
 		slog.String("version", v.String()),
 	)
 
@@ -113,65 +186,180 @@ func (t *TerraformRunner) install(ctx context.Context) (*tfexec.Terraform, error
 }
 
 func (t *TerraformRunner) runCommand(ctx context.Context, tf *tfexec.Terraform, action string) error {
+	// cancellation is handled by watch, which gives terraform a chance to
+	// stop gracefully
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stop := t.watch(ctx)
+	defer stop()
+	cmdCtx := context.WithoutCancel(ctx)
+
 	switch action {
 	case Init:
-		err := retryOnError(func() error {
-			return tf.Init(ctx, t.GetInitOptions()...)
+		err := t.retryOnError(ctx, func() error {
+			return tf.Init(cmdCtx, t.GetInitOptions()...)
 		})
 		if err != nil {
 			return fmt.Errorf("error running Init: %s", err)
 		}
 	case Plan:
-		if _, err := tf.Plan(ctx, t.GetPlanOptions()...); err != nil {
+		planFile := t.PlanFile
+		planOptions := t.GetPlanOptions()
+		if planFile == "" {
+			// the plan is saved regardless, to be able to show it
+			planFile = defaultPlanFile
+			planOptions = append(planOptions, tfexec.Out(planFile))
+			t.deleteOnCleanUp(filepath.Join(t.workDir, planFile))
+		}
+
+		hasChanges, err := tf.PlanJSON(cmdCtx, t.eventWriter(action), planOptions...)
+		tf.SetStdout(t.stdout)
+		if err != nil {
 			return fmt.Errorf("error running Plan: %s", err)
 		}
-		// if plan file is provided, execute show command and upload terraform json output
-		if t.PlanFile != "" {
-			goto showCase
+
+		plan, err := t.showPlan(cmdCtx, tf, planFile)
+		if err != nil {
+			return err
+		}
+		// terraform's own verdict, it accounts for changes of the state only
+		plan.HasChanges = hasChanges
+		t.planResult = plan
+
+		if t.onPlan != nil {
+			if err := t.onPlan(plan); err != nil {
+				return err
+			}
 		}
-		break
-	showCase:
-		fallthrough
+		t.plannedFile = planFile
 	case Show:
 		if t.PlanFile == "" {
 			return fmt.Errorf("please provide -plan-file flag  to show the terraform plan")
 		}
 
-		if err := setTerraformMultiStdout(tf, t.planPath, t.Debug); err != nil {
-			return fmt.Errorf("error setting multi stdout to terraform: %s", err)
-		}
-		if _, err := tf.ShowPlanFile(ctx, t.PlanFile); err != nil {
-			return fmt.Errorf("error running Show: %s", err)
+		plan, err := t.showPlan(cmdCtx, tf, t.PlanFile)
+		if err != nil {
+			return err
 		}
+		t.planResult = plan
 	case Apply:
-		// do not write the output of apply to the plan file if both are in single activity
-		tf.SetStdout(os.Stdout)
-		if err := tf.Apply(ctx, t.GetApplyOptions()...); err != nil {
+		applyOptions := t.GetApplyOptions()
+		if t.plannedFile != "" {
+			applyOptions = t.GetPlanApplyOptions(t.plannedFile)
+		}
+		err := tf.ApplyJSON(cmdCtx, t.eventWriter(action), applyOptions...)
+		tf.SetStdout(t.stdout)
+		if err != nil {
 			return fmt.Errorf("error running Apply: %s", err)
 		}
 	case Destroy:
-		tf.SetStdout(os.Stdout)
-		if err := tf.Destroy(ctx, t.GetDestroyOptions()...); err != nil {
+		err := tf.DestroyJSON(cmdCtx, t.eventWriter(action), t.GetDestroyOptions()...)
+		tf.SetStdout(t.stdout)
+		if err != nil {
 			return fmt.Errorf("error running Destroy: %s", err)
 		}
 	case Output:
-		if err := setTerraformMultiStdout(tf, t.outputPath, t.Debug); err != nil {
+		if err := t.setTerraformMultiStdout(tf, t.outputPath); err != nil {
 			return fmt.Errorf("error setting multi stdout to terraform: %s", err)
 		}
-		if _, err := tf.Output(ctx); err != nil {
+		outputs, err := tf.Output(cmdCtx)
+		if err != nil {
 			return fmt.Errorf("error running Output: %s", err)
 		}
+		t.outputs = outputs
+	case Validate:
+		return t.validate(cmdCtx, tf)
+	case Fmt:
+		return t.format(cmdCtx, tf)
+	case Refresh:
+		err := tf.RefreshJSON(cmdCtx, t.eventWriter(action), t.GetRefreshOptions()...)
+		tf.SetStdout(t.stdout)
+		if err != nil {
+			return fmt.Errorf("error running Refresh: %s", err)
+		}
+	case Import:
+		if err := tf.Import(cmdCtx, t.Address, t.ImportID, t.GetImportOptions()...); err != nil {
+			return fmt.Errorf("error running Import: %s", err)
+		}
+	case StateList:
+		return t.stateList(cmdCtx, tf)
+	case StateShow:
+		return t.stateShow(cmdCtx, tf)
+	case StateRm:
+		if err := tf.StateRm(cmdCtx, t.Address, t.GetStateRmOptions()...); err != nil {
+			return fmt.Errorf("error running StateRm: %s", err)
+		}
+	case StateMv:
+		if err := tf.StateMv(cmdCtx, t.Address, t.Destination, t.GetStateMvOptions()...); err != nil {
+			return fmt.Errorf("error running StateMv: %s", err)
+		}
+	case Taint:
+		if err := tf.Taint(cmdCtx, t.Address, t.GetTaintOptions()...); err != nil {
+			return fmt.Errorf("error running Taint: %s", err)
+		}
+	case Untaint:
+		if err := tf.Untaint(cmdCtx, t.Address, t.GetUntaintOptions()...); err != nil {
+			return fmt.Errorf("error running Untaint: %s", err)
+		}
+	case Graph:
+		return t.graph(cmdCtx, tf)
+	case ProvidersSchema:
+		return t.providersSchema(cmdCtx, tf)
+	case ProvidersLock:
+		if err := tf.ProvidersLock(cmdCtx, t.GetProvidersLockOptions()...); err != nil {
+			return fmt.Errorf("error running ProvidersLock: %s", err)
+		}
+	case WorkspaceNew:
+		if err := tf.WorkspaceNew(cmdCtx, t.Workspace, t.GetWorkspaceNewOptions()...); err != nil {
+			return fmt.Errorf("error running WorkspaceNew: %s", err)
+		}
+	case WorkspaceSelect:
+		if err := tf.WorkspaceSelect(cmdCtx, t.Workspace); err != nil {
+			return fmt.Errorf("error running WorkspaceSelect: %s", err)
+		}
+	case WorkspaceList:
+		workspaces, current, err := tf.WorkspaceList(cmdCtx)
+		if err != nil {
+			return fmt.Errorf("error running WorkspaceList: %s", err)
+		}
+		t.workspaces = workspaces
+		t.Workspace = current
+	case WorkspaceDelete:
+		if err := tf.WorkspaceDelete(cmdCtx, t.Workspace, t.GetWorkspaceDeleteOptions()...); err != nil {
+			return fmt.Errorf("error running WorkspaceDelete: %s", err)
+		}
 	case ForceUnlock:
-		tf.SetStdout(os.Stdout)
-		if err := tf.ForceUnlock(ctx, t.LockID, t.GetForceUnlockOptions()...); err != nil {
+		tf.SetStdout(t.stdout)
+		if err := tf.ForceUnlock(cmdCtx, t.LockID, t.GetForceUnlockOptions()...); err != nil {
 			return fmt.Errorf("error running ForceUnlock: %s", err)
 		}
 	}
 	return nil
 }
 
+// showPlan reads a saved plan into a PlanResult. When a plan file was asked
+// for, or the job's results are uploaded, the json output of terraform show
+// is written to the plan path as well.
+func (t *TerraformRunner) showPlan(ctx context.Context, tf *tfexec.Terraform, planFile string) (*PlanResult, error) {
+	if t.PlanFile != "" || t.UploadUrl != "" {
+		if err := t.setTerraformMultiStdout(tf, t.planPath); err != nil {
+			return nil, fmt.Errorf("error setting multi stdout to terraform: %s", err)
+		}
+	} else {
+		tf.SetStdout(io.Discard)
+	}
+	defer tf.SetStdout(t.stdout)
+
+	plan, err := tf.ShowPlanFile(ctx, planFile)
+	if err != nil {
+		return nil, fmt.Errorf("error running Show: %s", err)
+	}
+	return NewPlanResult(plan), nil
+}
+
 func (t *TerraformRunner) run(ctx context.Context) error {
-	defer timer("runTerraform")()
+	defer t.timer("runTerraform")()
 
 	tf, err := t.install(ctx)
 	if err != nil {
@@ -186,14 +374,40 @@ func (t *TerraformRunner) run(ctx context.Context) error {
 		return fmt.Errorf("error setting terraform logger: %s", err)
 	}
 
+	// the results are uploaded whether the actions succeeded or not
+	err = t.runActions(ctx, tf)
+	if uploadErr := t.upload(ctx, tf); uploadErr != nil {
+		if err == nil {
+			return uploadErr
+		}
+		t.logger.Error("failed to upload job", "error", uploadErr)
+	}
+	return err
+}
+
+func (t *TerraformRunner) runActions(ctx context.Context, tf *tfexec.Terraform) error {
 	for _, action := range t.Actions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		t.logger.Info("running terraform command",
 			slog.String("action", action),
+			slog.String("engine", t.Engine),
 			slog.String("version", t.Version),
 			slog.String("workdir", t.workDir),
 		)
 
-		if err = t.runCommand(ctx, tf, action); err != nil {
+		t.emit(Event{Type: EventActionStarted, Action: action})
+
+		err := t.runCommand(ctx, tf, action)
+		finished := Event{Type: EventActionFinished, Action: action}
+		if err != nil {
+			finished.Error = err.Error()
+		}
+		t.emit(finished)
+
+		if err != nil {
 			return err
 		}
 	}
@@ -206,40 +420,91 @@ func (t *TerraformRunner) setTerraformLogger(tf *tfexec.Terraform) error {
 	tf.SetLogger(t.tfLog)
 
 	// display the output of terraform commands to the terminal
-	tf.SetStdout(os.Stdout)
-	tf.SetStderr(os.Stderr)
+	tf.SetStdout(t.stdout)
+	tf.SetStderr(t.stderr)
 
 	// For terraform logs
-	logLvl := t.GetLogLvl()
-	err := tf.SetLogProvider(logLvl)
-	if err != nil {
+	if err := t.validateLogLevels(); err != nil {
+		return err
+	}
+	log, core, provider := t.terraformLogEnv()
+	if log == "" && core == "" && provider == "" {
+		// without a log path terraform does not log at all
+		return nil
+	}
+	if err := tf.SetLog(log); err != nil {
+		return err
+	}
+	if err := tf.SetLogCore(core); err != nil {
+		return err
+	}
+	if err := tf.SetLogProvider(provider); err != nil {
 		return err
 	}
 
-	err = tf.SetLog(logLvl)
-	if err != nil {
+	logPath := t.logPath()
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
 		return err
 	}
+	return tf.SetLogPath(logPath)
+}
 
-	return tf.SetLogPath("./job.log")
+// logPath returns the absolute path of terraform's log, terraform runs in the
+// work dir.
+func (t *TerraformRunner) logPath() string {
+	logPath := t.LogPath
+	if logPath == "" {
+		logPath = filepath.Join(t.RunDir(), TerraformLogFile)
+	}
+	if abs, err := filepath.Abs(logPath); err == nil {
+		logPath = abs
+	}
+	return logPath
 }
 
-func (t *TerraformRunner) Execute() error {
-	defer timer("main")()
+// Execute installs terraform and runs the configured actions. Canceling ctx
+// stops the run in progress.
+func (t *TerraformRunner) Execute(ctx context.Context) error {
+	closeLogs, err := t.openRunLogs()
+	if err != nil {
+		t.logger.Error("unable to create run logs", "error", err)
+		return err
+	}
+	defer closeLogs()
+
+	defer t.timer("main")()
 
-	defer errorCheck(cleanUp)
+	defer t.errorCheck(t.cleanUp)
 
-	if err := os.MkdirAll(terraformWorkDir, 0755); err != nil {
-		logger.Error("unable to create job dir", "error", err)
+	if err := os.MkdirAll(t.workDir, 0755); err != nil {
+		t.logger.Error("unable to create job dir", "error", err)
 		return err
 	}
 
-	t.planPath = path.Join(basePath, planFile)
-	t.outputPath = path.Join(basePath, outputFile)
+	// plan.json and output.json are written to a scratch dir of the run
+	scratchDir, err := os.MkdirTemp("", "ginie-job-")
+	if err != nil {
+		t.logger.Error("unable to create scratch dir", "error", err)
+		return err
+	}
+	t.deleteOnCleanUp(scratchDir)
+	t.planPath = filepath.Join(scratchDir, planFile)
+	t.outputPath = filepath.Join(scratchDir, outputFile)
+
+	// a stateless job starts from the work dir it downloads
+	if err := t.download(ctx); err != nil {
+		t.logger.Error("failed to download work dir", "error", err)
+		return err
+	}
+
+	if err := t.applyOverrides(); err != nil {
+		t.logger.Error("failed to apply overrides", "error", err)
+		return err
+	}
 
 	// install terraform binary and run the terraform commands
-	if err := t.run(context.Background()); err != nil {
-		logger.Error("failed to run terraform job", "error", err)
+	if err := t.run(ctx); err != nil {
+		t.logger.Error("failed to run terraform job", "error", err)
 		return err
 	}
 
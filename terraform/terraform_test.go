@@ -0,0 +1,104 @@
+package terraform
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestApplyAppliesCheckedPlan(t *testing.T) {
+	binary := installFake(t)
+	runner, _ := newFakeRunner(t, binary, Init, Plan, Apply)
+	// terraform rejects variables when applying a saved plan
+	runner.Var = []string{"name=logs"}
+
+	var checked string
+	runner.SetPlanHandler(func(plan *PlanResult) error {
+		changes := plan.Changes()
+		if len(changes) != 1 {
+			t.Fatalf("got %d changes, want 1", len(changes))
+		}
+		checked = changes[0].After.(map[string]interface{})["plan"].(string)
+		return nil
+	})
+	if err := runner.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var apply, applied string
+	for _, call := range fakeCalls(t, binary) {
+		if strings.Contains(call, ": apply ") {
+			apply = call
+		}
+		if strings.HasPrefix(call, "applied ") {
+			applied = strings.TrimPrefix(call, "applied ")
+		}
+	}
+	if !strings.HasSuffix(apply, " "+defaultPlanFile) {
+		t.Errorf("apply did not apply the saved plan: %s", apply)
+	}
+	if strings.Contains(apply, "-var") {
+		t.Errorf("apply of a saved plan was given variables: %s", apply)
+	}
+	if checked == "" || applied != checked {
+		t.Errorf("applied plan %q, checked plan %q", applied, checked)
+	}
+}
+
+func TestPlanHandlerBlocksApply(t *testing.T) {
+	binary := installFake(t)
+	runner, _ := newFakeRunner(t, binary, Init, Plan, Apply)
+	runner.SetPlanHandler(func(*PlanResult) error {
+		return errBlocked
+	})
+	if err := runner.Execute(context.Background()); err != errBlocked {
+		t.Fatalf("got %v, want %v", err, errBlocked)
+	}
+	for _, call := range fakeCalls(t, binary) {
+		if strings.Contains(call, ": apply ") {
+			t.Errorf("blocked plan was applied: %s", call)
+		}
+	}
+}
+
+var errBlocked = blockedError("blocked by policy")
+
+type blockedError string
+
+func (e blockedError) Error() string { return string(e) }
+
+func TestConcurrentRunners(t *testing.T) {
+	binary := installFake(t)
+	levels := []string{"DEBUG", "TRACE"}
+	runners := make([]*TerraformRunner, len(levels))
+	outs := make([]*syncBuffer, len(levels))
+	for i, level := range levels {
+		runners[i], outs[i] = newFakeRunner(t, binary, Init, Plan)
+		runners[i].TerraformLogLevel = level
+	}
+
+	errs := make(chan error, len(runners))
+	for _, runner := range runners {
+		go func(runner *TerraformRunner) {
+			errs <- runner.Execute(context.Background())
+		}(runner)
+	}
+	for range runners {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, runner := range runners {
+		other := runners[1-i]
+		out := outs[i].String()
+		want := fmt.Sprintf("pwd=%s log=%s level=%s", runner.workDir, runner.logPath(), levels[i])
+		if !strings.Contains(out, want) {
+			t.Errorf("runner %d output %q, want %q", i, out, want)
+		}
+		if strings.Contains(out, other.workDir) || strings.Contains(out, other.RunDir()) {
+			t.Errorf("runner %d output has the other runner's: %q", i, out)
+		}
+	}
+}
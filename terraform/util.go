@@ -1,6 +1,7 @@
 package terraform
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"net/http"
@@ -21,6 +22,25 @@ const (
 	Show        = "show"
 	ForceUnlock = "force-unlock"
 
+	Validate        = "validate"
+	Fmt             = "fmt"
+	Refresh         = "refresh"
+	Import          = "import"
+	StateList       = "state-list"
+	StateShow       = "state-show"
+	StateRm         = "state-rm"
+	StateMv         = "state-mv"
+	Taint           = "taint"
+	Untaint         = "untaint"
+	Graph           = "graph"
+	ProvidersSchema = "providers-schema"
+	ProvidersLock   = "providers-lock"
+
+	WorkspaceNew    = "workspace-new"
+	WorkspaceSelect = "workspace-select"
+	WorkspaceList   = "workspace-list"
+	WorkspaceDelete = "workspace-delete"
+
 	defaultAttempts = 3
 	defaultDelay    = 5 * time.Second
 )
@@ -34,6 +54,25 @@ var (
 		Output:      true,
 		Show:        true,
 		ForceUnlock: true,
+
+		Validate:        true,
+		Fmt:             true,
+		Refresh:         true,
+		Import:          true,
+		StateList:       true,
+		StateShow:       true,
+		StateRm:         true,
+		StateMv:         true,
+		Taint:           true,
+		Untaint:         true,
+		Graph:           true,
+		ProvidersSchema: true,
+		ProvidersLock:   true,
+
+		WorkspaceNew:    true,
+		WorkspaceSelect: true,
+		WorkspaceList:   true,
+		WorkspaceDelete: true,
 	}
 )
 
@@ -51,17 +90,17 @@ func isContentTypeZip(contentType string) bool {
 	return false
 }
 
-func setTerraformMultiStdout(tf *tfexec.Terraform, file string, debug bool) error {
+func (t *TerraformRunner) setTerraformMultiStdout(tf *tfexec.Terraform, file string) error {
 	f, err := os.Create(file)
 	if err != nil {
 		return err
 	}
 
-	filesToBeDeleted = append(filesToBeDeleted, file)
+	t.deleteOnCleanUp(file)
 
 	writers := []io.Writer{f}
-	if debug {
-		writers = append(writers, os.Stdout)
+	if t.Debug {
+		writers = append(writers, t.stdout)
 	}
 
 	multi := io.MultiWriter(writers...)
@@ -69,13 +108,6 @@ func setTerraformMultiStdout(tf *tfexec.Terraform, file string, debug bool) erro
 	return nil
 }
 
-var onRetryFunc = func(n uint, err error) {
-	logger.Debug("retrying...",
-		slog.Uint64("count", uint64(n)),
-		slog.Any("error", err),
-	)
-}
-
 func setHeaders(request *http.Request, headers map[string]string) {
 	if headers == nil {
 		return
@@ -86,27 +118,34 @@ func setHeaders(request *http.Request, headers map[string]string) {
 	}
 }
 
-func cleanUp() error {
-	defer timer("cleanUp")()
-	logger.Debug("cleaning up files...")
-	for _, f := range filesToBeDeleted {
+// deleteOnCleanUp registers a file or directory to remove once the run is
+// done.
+func (t *TerraformRunner) deleteOnCleanUp(path string) {
+	t.filesToBeDeleted = append(t.filesToBeDeleted, path)
+}
+
+func (t *TerraformRunner) cleanUp() error {
+	defer t.timer("cleanUp")()
+	t.logger.Debug("cleaning up files...")
+	for _, f := range t.filesToBeDeleted {
 		if err := os.RemoveAll(f); err != nil {
 			return err
 		}
 	}
+	t.filesToBeDeleted = nil
 	return nil
 }
 
-func errorCheck(f func() error) {
+func (t *TerraformRunner) errorCheck(f func() error) {
 	if err := f(); err != nil {
-		logger.Error("error while cleaning up", err)
+		t.logger.Error("error while cleaning up", "error", err)
 	}
 }
 
-func timer(name string) func() {
+func (t *TerraformRunner) timer(name string) func() {
 	now := time.Now()
 	return func() {
-		logger.Debug("time taken to execute",
+		t.logger.Debug("time taken to execute",
 			slog.Duration(name, time.Since(now)),
 		)
 	}
@@ -131,11 +170,12 @@ func dirSize(path string) (float32, error) {
 	return float32(size) / 1024 / 1024, err
 }
 
-func retryOnError(f func() error) error {
+func (t *TerraformRunner) retryOnError(ctx context.Context, f func() error) error {
 	return retry.Do(
 		f,
+		retry.Context(ctx),
 		retry.OnRetry(func(n uint, err error) {
-			logger.Debug("retrying...",
+			t.logger.Debug("retrying...",
 				slog.String("error", err.Error()),
 				slog.Uint64("attempt", uint64(n)+1),
 			)
@@ -0,0 +1,135 @@
+package terraform
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hc-install/fs"
+)
+
+const (
+	// VersionEnv sets the terraform version to run, see DriverConfig.Version.
+	VersionEnv = "GINIE_TERRAFORM_VERSION"
+
+	// VersionLatest resolves to the latest release meeting the program's
+	// required_version.
+	VersionLatest = "latest"
+	// VersionPath uses the binary of the engine found on PATH, whatever its
+	// version.
+	VersionPath = "path"
+
+	// VersionFile pins the version of a work dir, as it does for tfenv
+	VersionFile = ".terraform-version"
+)
+
+var requiredVersion = regexp.MustCompile(`(?m)^\s*required_version\s*=\s*"([^"]*)"`)
+
+// RequiredVersion returns the required_version constraints of the
+// configuration in dir, nil if it has none.
+func RequiredVersion(dir string) (version.Constraints, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	var constraints version.Constraints
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range requiredVersion.FindAllSubmatch(b, -1) {
+			c, err := version.NewConstraint(string(m[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid required_version in %s: %s", filepath.Base(file), err)
+			}
+			constraints = append(constraints, c...)
+		}
+	}
+	return constraints, nil
+}
+
+// versionSpec returns what the version of terraform is resolved from: the
+// configured version, else the .terraform-version file of the work dir. An
+// empty spec leaves it to the program's required_version.
+func versionSpec(configured, workDir string) string {
+	if configured != "" {
+		return configured
+	}
+	b, err := os.ReadFile(filepath.Join(workDir, VersionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// resolveVersion resolves spec, an exact version, "latest", a constraint
+// such as "~> 1.5" or empty, to an exact version. Versions other than exact
+// ones also have to meet the program's required_version. Without network the
+// newest cached version meeting the constraints is used.
+func (i *cachedInstaller) resolveVersion(ctx context.Context, spec string) (*version.Version, error) {
+	if v, err := version.NewVersion(spec); err == nil {
+		return v, nil
+	}
+
+	constraints, err := RequiredVersion(i.workDir)
+	if err != nil {
+		return nil, err
+	}
+	if spec != "" && spec != VersionLatest {
+		c, err := version.NewConstraint(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid terraform version %q: %s", spec, err)
+		}
+		constraints = append(constraints, c...)
+	}
+
+	versions, err := i.engine.releases(ctx)
+	if err != nil {
+		i.logger.Warn("error listing releases, falling back to cached versions", "engine", i.engine.binary, "error", err)
+		if versions, err = i.cachedVersions(); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range versions {
+		if v.Prerelease() == "" && constraints.Check(v) {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s version meets %q", i.engine.binary, constraints.String())
+}
+
+// findOnPath returns the engine's binary found on PATH.
+func (i *cachedInstaller) findOnPath(ctx context.Context) (string, error) {
+	finder := &fs.AnyVersion{Product: &i.engine.product}
+	execPath, err := finder.Find(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error finding %s on PATH: %s", i.engine.binary, err)
+	}
+	return execPath, nil
+}
+
+// cachedVersions lists the versions in the install dir, newest first.
+func (i *cachedInstaller) cachedVersions() ([]*version.Version, error) {
+	entries, err := os.ReadDir(i.cacheDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var versions []*version.Version
+	for _, e := range entries {
+		v, err := version.NewVersion(e.Name())
+		if err != nil || !fileExists(filepath.Join(i.cacheDir(), e.Name(), i.engine.binary)) {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(version.Collection(versions)))
+	return versions, nil
+}
@@ -0,0 +1,145 @@
+package terraform
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequiredVersion(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+		err   string
+	}{
+		{
+			name:  "none",
+			files: map[string]string{"main.tf": `resource "null_resource" "a" {}`},
+		},
+		{
+			name: "terraform block",
+			files: map[string]string{"versions.tf": `terraform {
+  required_version = ">= 1.5.0"
+}`},
+			want: ">= 1.5.0",
+		},
+		{
+			name: "files and modules",
+			files: map[string]string{
+				"versions.tf":         `  required_version   =   "~> 1.6"`,
+				"main.tf":             `  required_version = "< 2.0.0"`,
+				"modules/vpc/main.tf": `  required_version = "= 1.0.0"`,
+			},
+			// the order of the files
+			want: "< 2.0.0,~> 1.6",
+		},
+		{
+			name:  "not an attribute",
+			files: map[string]string{"main.tf": `# required_version = "1.0.0" is not set here`},
+		},
+		{
+			name:  "other files",
+			files: map[string]string{"main.tf.json": `{"terraform": {"required_version": "1.0.0"}}`, "notes.txt": `required_version = "1.0.0"`},
+		},
+		{
+			name:  "invalid",
+			files: map[string]string{"versions.tf": `required_version = "one"`},
+			err:   "invalid required_version in versions.tf",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			constraints, err := RequiredVersion(dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := constraints.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionSpec(t *testing.T) {
+	dir := t.TempDir()
+	if got := versionSpec("", dir); got != "" {
+		t.Errorf("got %q without a version", got)
+	}
+	writeFiles(t, dir, map[string]string{VersionFile: "1.5.7\n"})
+	if got := versionSpec("", dir); got != "1.5.7" {
+		t.Errorf("got %q, want the version of the file", got)
+	}
+	if got := versionSpec("latest", dir); got != "latest" {
+		t.Errorf("got %q, want the configured version", got)
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	releases := []string{"1.7.0-rc1", "1.6.2", "1.6.1", "1.5.7", "1.4.0"}
+	tests := []struct {
+		name     string
+		spec     string
+		required string
+		// cached are the versions in the install dir
+		cached  []string
+		offline bool
+		want    string
+		err     string
+	}{
+		{name: "exact", spec: "1.3.0", required: ">= 1.5", want: "1.3.0"},
+		{name: "latest", spec: VersionLatest, want: "1.6.2"},
+		{name: "required version", required: "< 1.6", want: "1.5.7"},
+		{name: "latest required version", spec: VersionLatest, required: "~> 1.5.0", want: "1.5.7"},
+		{name: "constraint", spec: "~> 1.6.0", want: "1.6.2"},
+		{name: "constraint and required version", spec: ">= 1.5", required: "< 1.6.2", want: "1.6.1"},
+		{name: "prerelease", spec: ">= 1.7.0-rc1", err: `no terraform version meets ">= 1.7.0-rc1"`},
+		{name: "unmet", spec: "~> 1.6", required: "< 1.5", err: `no terraform version meets "< 1.5,~> 1.6"`},
+		{name: "invalid", spec: "newest", err: `invalid terraform version "newest"`},
+		{name: "invalid required version", required: "one", err: "invalid required_version"},
+		{name: "offline", spec: VersionLatest, cached: []string{"1.4.0", "1.6.1", "1.5.7"}, offline: true, want: "1.6.1"},
+		{name: "offline constraint", spec: "< 1.6", cached: []string{"1.4.0", "1.6.1", "1.5.7"}, offline: true, want: "1.5.7"},
+		{name: "offline without cache", spec: VersionLatest, offline: true, err: `no terraform version meets ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir, installDir := t.TempDir(), t.TempDir()
+			if tt.required != "" {
+				writeFiles(t, workDir, map[string]string{"versions.tf": `required_version = "` + tt.required + `"`})
+			}
+			files := make(map[string]string)
+			for _, v := range tt.cached {
+				files[filepath.Join("terraform", v, "terraform")] = versionScript(v)
+			}
+			// neither a version nor a binary
+			files[filepath.Join("terraform", "9.9.9", "notes.txt")] = ""
+			files[filepath.Join("terraform", "tmp", "terraform")] = ""
+			writeFiles(t, installDir, files)
+
+			fake := &fakeEngine{releases: releases, offline: tt.offline}
+			v, err := newTestInstaller(fake.engine(), tt.spec, workDir, installDir).resolveVersion(context.Background(), tt.spec)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, %v, want %s", v, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.String() != tt.want {
+				t.Errorf("got %s, want %s", v, tt.want)
+			}
+		})
+	}
+}
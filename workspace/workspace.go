@@ -0,0 +1,411 @@
+// Package workspace allocates an isolated work directory per session or
+// project, so that conversations do not clobber each other's program, state
+// and logs.
+//
+// A workspace is laid out as
+//
+//	<root>/<name>/                  terraform work dir, program, .terraform and state
+//	<root>/<name>/.ginie/           ginie's own metadata
+//	<root>/<name>/.ginie/logs/      logs of the runs
+//	<root>/<name>/.ginie/revisions/ every program generated in the workspace
+//	<root>/<name>/.ginie/drift/     reports of the drift detected
+//	<root>/<name>/stacks/<stack>/   work dir of a stack, if the program is split into stacks
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+const (
+	DefaultName = "default"
+
+	metadataDir  = ".ginie"
+	metadataFile = "workspace.json"
+	logsDir      = "logs"
+	revisionsDir = "revisions"
+	driftDir     = "drift"
+	stacksDir    = "stacks"
+	runLockFile  = "run.lock"
+	metadataLock = "workspace.lock"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Workspace is a directory a program is generated into and deployed from.
+type Workspace struct {
+	Name      string    `json:"name"`
+	Dir       string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Engine runs the program, terraform unless set to tofu for OpenTofu
+	Engine string `json:"engine,omitempty"`
+	// TerraformVersion is the version the first run resolved to, later runs
+	// stick to it
+	TerraformVersion string `json:"terraformVersion,omitempty"`
+	// Stacks splits the program into stacks deployed in dependency order,
+	// the program lives in the workspace dir itself without them
+	Stacks []terraform.Stack `json:"stacks,omitempty"`
+	// Budget is the monthly cost the resources may add up to, applies that
+	// would exceed it are blocked. No budget if 0.
+	Budget float64 `json:"budget,omitempty"`
+}
+
+// ErrLocked is returned by Lock while the workspace is locked by another run.
+var ErrLocked = errors.New("workspace is locked by another run")
+
+// Lock locks the workspace for a run until the returned func is called, so
+// that sessions and processes sharing it take turns deploying it. It returns
+// ErrLocked rather than waiting if another run holds the lock.
+func (w *Workspace) Lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Join(w.Dir, metadataDir), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(w.Dir, metadataDir, runLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// flock is held by the open file, even within the same process
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// LogDir is where the logs of the runs in the workspace are written.
+func (w *Workspace) LogDir() string {
+	return filepath.Join(w.Dir, metadataDir, logsDir)
+}
+
+// RevisionDir is where every program generated in the workspace is kept.
+func (w *Workspace) RevisionDir() string {
+	return filepath.Join(w.Dir, metadataDir, revisionsDir)
+}
+
+// SetTerraformVersion records the version of terraform runs in the workspace
+// use.
+func (w *Workspace) SetTerraformVersion(v string) error {
+	return w.update(func(w *Workspace) {
+		w.TerraformVersion = v
+	})
+}
+
+// SetEngine switches the workspace to another engine. The version recorded
+// for the previous one no longer applies.
+func (w *Workspace) SetEngine(engine string) error {
+	return w.update(func(w *Workspace) {
+		if engine != w.Engine {
+			w.Engine = engine
+			w.TerraformVersion = ""
+		}
+	})
+}
+
+// SetBudget sets the monthly cost the resources of the workspace may add up
+// to, 0 for none.
+func (w *Workspace) SetBudget(budget float64) error {
+	if budget < 0 {
+		return fmt.Errorf("invalid budget: %g", budget)
+	}
+	return w.update(func(w *Workspace) {
+		w.Budget = budget
+	})
+}
+
+// DriftDir is where the reports of the drift detected in the workspace are
+// kept.
+func (w *Workspace) DriftDir() string {
+	return filepath.Join(w.Dir, metadataDir, driftDir)
+}
+
+// StackDir is the work dir of a stack of the workspace.
+func (w *Workspace) StackDir(stack string) string {
+	return filepath.Join(w.Dir, stacksDir, stack)
+}
+
+// SetStacks changes the stacks the program of the workspace is split into.
+// Dependencies have to be on stacks of the workspace and must not form a
+// cycle. The work dirs of removed stacks are kept.
+func (w *Workspace) SetStacks(stacks []terraform.Stack) error {
+	for _, s := range stacks {
+		if !validName.MatchString(s.Name) {
+			return fmt.Errorf("invalid stack name: %s", s.Name)
+		}
+	}
+	if _, err := terraform.OrderStacks(stacks); err != nil {
+		return err
+	}
+	for _, s := range stacks {
+		if err := os.MkdirAll(w.StackDir(s.Name), 0755); err != nil {
+			return err
+		}
+	}
+
+	return w.update(func(w *Workspace) {
+		w.Stacks = stacks
+	})
+}
+
+// Reload returns the workspace as it is recorded now, with the changes other
+// sessions and processes made to it since w was read. w is left as it is.
+func (w *Workspace) Reload() (*Workspace, error) {
+	current := &Workspace{Dir: w.Dir}
+	if err := current.load(); err != nil {
+		return nil, err
+	}
+	current.Name = w.Name
+	return current, nil
+}
+
+// update makes the change to the workspace as it is recorded, so that the
+// changes other sessions and processes made to it are kept rather than
+// overwritten with those of w, and to w itself.
+func (w *Workspace) update(change func(w *Workspace)) error {
+	unlock, err := w.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := w.Reload()
+	if err != nil {
+		return err
+	}
+	change(current)
+	if err := current.save(); err != nil {
+		return err
+	}
+	change(w)
+	return nil
+}
+
+// lockMetadata locks the metadata of the workspace against changes of other
+// sessions and processes until the returned func is called.
+func (w *Workspace) lockMetadata() (func(), error) {
+	if err := os.MkdirAll(filepath.Join(w.Dir, metadataDir), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(w.Dir, metadataDir, metadataLock), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+func (w *Workspace) metadataPath() string {
+	return filepath.Join(w.Dir, metadataDir, metadataFile)
+}
+
+func (w *Workspace) load() error {
+	b, err := os.ReadFile(w.metadataPath())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, w)
+}
+
+// save replaces the metadata file at once, so that it is never read half
+// written.
+func (w *Workspace) save() error {
+	b, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(w.metadataPath()), metadataFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), w.metadataPath())
+}
+
+// Manager allocates workspaces below a root directory.
+type Manager struct {
+	root string
+}
+
+func NewManager(root string) *Manager {
+	return &Manager{root: root}
+}
+
+// List returns all workspaces, sorted by name.
+func (m *Manager) List() ([]*Workspace, error) {
+	entries, err := os.ReadDir(m.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var workspaces []*Workspace
+	for _, e := range entries {
+		if !e.IsDir() || !validName.MatchString(e.Name()) {
+			continue
+		}
+		w, err := m.Get(e.Name())
+		if err != nil {
+			// not a workspace, e.g. a directory left by an older version
+			continue
+		}
+		workspaces = append(workspaces, w)
+	}
+
+	sort.Slice(workspaces, func(i, j int) bool {
+		return workspaces[i].Name < workspaces[j].Name
+	})
+	return workspaces, nil
+}
+
+// Get returns an existing workspace.
+func (m *Manager) Get(name string) (*Workspace, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid workspace name: %s", name)
+	}
+
+	w := &Workspace{Dir: filepath.Join(m.root, name)}
+	b, err := os.ReadFile(w.metadataPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("workspace %s does not exist", name)
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, w); err != nil {
+		return nil, fmt.Errorf("invalid workspace %s: %s", name, err)
+	}
+	w.Name = name
+	return w, nil
+}
+
+// Create allocates a new workspace.
+func (m *Manager) Create(name string) (*Workspace, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid workspace name: %s", name)
+	}
+
+	w := &Workspace{
+		Name:      name,
+		Dir:       filepath.Join(m.root, name),
+		CreatedAt: time.Now(),
+	}
+	for _, dir := range []string{w.LogDir(), w.RevisionDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	unlock, err := w.lockMetadata()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := os.Stat(w.metadataPath()); err == nil {
+		return nil, fmt.Errorf("workspace %s already exists", name)
+	}
+	if err := w.save(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Open returns the workspace, creating it if it does not exist yet.
+func (m *Manager) Open(name string) (*Workspace, error) {
+	w, err := m.Get(name)
+	if err == nil {
+		return w, nil
+	}
+	if _, statErr := os.Stat(filepath.Join(m.root, name, metadataDir, metadataFile)); os.IsNotExist(statErr) {
+		w, err := m.Create(name)
+		if err != nil {
+			// created by another session or process in the meantime
+			if w, getErr := m.Get(name); getErr == nil {
+				return w, nil
+			}
+		}
+		return w, err
+	}
+	return nil, err
+}
+
+// isLegacy reports whether a file in the root is one an older version of
+// Ginie kept there, before it had workspaces: the program, its state and
+// terraform's own files.
+func isLegacy(name string) bool {
+	switch name {
+	case ".terraform", ".terraform.lock.hcl", ".terraform-version", "terraform.tfstate", "terraform.tfstate.backup", "terraform.tfstate.d":
+		return true
+	}
+	for _, suffix := range []string{".tf", ".tf.json", ".tfvars", ".tfvars.json"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateLegacy moves the program and state an older version of Ginie kept
+// in the root into the default workspace and returns the files it moved. It
+// moves none of them if the default workspace has any of them already,
+// rather than mixing up two programs or states.
+func (m *Manager) MigrateLegacy() ([]string, error) {
+	entries, err := os.ReadDir(m.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var legacy []string
+	for _, e := range entries {
+		if isLegacy(e.Name()) {
+			legacy = append(legacy, e.Name())
+		}
+	}
+	if len(legacy) == 0 {
+		return nil, nil
+	}
+
+	w, err := m.Open(DefaultName)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range legacy {
+		if _, err := os.Lstat(filepath.Join(w.Dir, name)); err == nil {
+			return nil, fmt.Errorf("%s of an older version is in %s and in workspace %s, move it by hand", name, m.root, w.Name)
+		}
+	}
+	for i, name := range legacy {
+		if err := os.Rename(filepath.Join(m.root, name), filepath.Join(w.Dir, name)); err != nil {
+			return legacy[:i], err
+		}
+	}
+	return legacy, nil
+}
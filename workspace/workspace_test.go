@@ -0,0 +1,178 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/niravparikh05/ginie-ai/terraform"
+)
+
+func TestLock(t *testing.T) {
+	m := NewManager(t.TempDir())
+	w, err := m.Create("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another session, or process, with the same workspace
+	other, err := m.Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := w.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Lock(); err != ErrLocked {
+		t.Fatalf("got %v, want %v", err, ErrLocked)
+	}
+	unlock()
+
+	unlock, err = other.Lock()
+	if err != nil {
+		t.Fatalf("lock after unlock: %s", err)
+	}
+	unlock()
+}
+
+func TestLockPerWorkspace(t *testing.T) {
+	m := NewManager(t.TempDir())
+	web, err := m.Create("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := m.Create("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := web.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	unlockDB, err := db.Lock()
+	if err != nil {
+		t.Fatalf("lock of another workspace: %s", err)
+	}
+	unlockDB()
+}
+
+// TestUpdatesKeepOtherChanges changes a workspace through copies of it, as
+// sessions and processes sharing it hold. None of the changes is lost.
+func TestUpdatesKeepOtherChanges(t *testing.T) {
+	m := NewManager(t.TempDir())
+	if _, err := m.Create("web"); err != nil {
+		t.Fatal(err)
+	}
+	copies := make([]*Workspace, 4)
+	for i := range copies {
+		w, err := m.Get("web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		copies[i] = w
+	}
+
+	stacks := []terraform.Stack{{Name: "network"}}
+	changes := []func(w *Workspace) error{
+		func(w *Workspace) error { return w.SetBudget(100) },
+		func(w *Workspace) error { return w.SetEngine(terraform.EngineOpenTofu) },
+		func(w *Workspace) error { return w.SetStacks(stacks) },
+		func(w *Workspace) error { return w.SetTerraformVersion("1.6.2") },
+	}
+	var wg sync.WaitGroup
+	for i, change := range changes {
+		wg.Add(1)
+		go func(w *Workspace, change func(w *Workspace) error) {
+			defer wg.Done()
+			if err := change(w); err != nil {
+				t.Error(err)
+			}
+		}(copies[i], change)
+	}
+	wg.Wait()
+
+	w, err := m.Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the version is forgotten if the engine was switched after it was recorded
+	if w.Budget != 100 || w.Engine != terraform.EngineOpenTofu || len(w.Stacks) != 1 {
+		t.Errorf("got %+v, want all changes", w)
+	}
+
+	// a copy read before the changes sees them once reloaded
+	current, err := copies[0].Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Engine != terraform.EngineOpenTofu || len(current.Stacks) != 1 {
+		t.Errorf("reloaded %+v, want the changes of the other copies", current)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"main.tf", "terraform.tfstate", ".terraform/terraform.tfstate", "notes.txt"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewManager(root)
+
+	moved, err := m.MigrateLegacy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".terraform", "main.tf", "terraform.tfstate"}; !slices.Equal(moved, want) {
+		t.Errorf("moved %v, want %v", moved, want)
+	}
+	w, err := m.Get(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"main.tf", "terraform.tfstate", ".terraform/terraform.tfstate"} {
+		if b, err := os.ReadFile(filepath.Join(w.Dir, name)); err != nil || string(b) != name {
+			t.Errorf("%s not moved: %q, %v", name, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "notes.txt")); err != nil {
+		t.Errorf("other file moved: %s", err)
+	}
+	if moved, err := m.MigrateLegacy(); err != nil || len(moved) != 0 {
+		t.Errorf("second migration moved %v, %v", moved, err)
+	}
+}
+
+func TestMigrateLegacyConflict(t *testing.T) {
+	root := t.TempDir()
+	m := NewManager(root)
+	w, err := m.Create(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(root, "main.tf"), filepath.Join(root, "terraform.tfstate"), filepath.Join(w.Dir, "terraform.tfstate")} {
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := m.MigrateLegacy(); err == nil {
+		t.Fatal("migrated into a workspace with a state of its own")
+	}
+	// nothing moved
+	if _, err := os.Stat(filepath.Join(root, "main.tf")); err != nil {
+		t.Error(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(w.Dir, "terraform.tfstate")); string(b) != filepath.Join(w.Dir, "terraform.tfstate") {
+		t.Errorf("state of the workspace overwritten: %q", b)
+	}
+}